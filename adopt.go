@@ -0,0 +1,99 @@
+package safe
+
+import (
+	"context"
+	"fmt"
+)
+
+// AdoptResult: the ciphertexts registered, or in a dry run that would be
+// registered, by Adopt
+type AdoptResult struct {
+	Result
+
+	Adopted []string
+
+	// Undecryptable are ciphertexts the current keys can't decrypt, which
+	// are left unregistered
+	Undecryptable []string
+
+	// Foreign end with another tool's encrypted suffix rather than the
+	// config's, and are left unregistered
+	Foreign []string
+}
+
+// Adopt: register the encrypted files under dir that are missing from the
+// config, as happens after encrypting with gpg by hand or a merge that lost
+// entries from safe.yml. Each file must decrypt with the current keys
+// before it's registered. The recipients of an adopted file aren't known,
+// so it's reencrypted by the next ReencryptAll.
+func Adopt(dir string, config Config, opts Options) (AdoptResult, error) {
+	result := AdoptResult{
+		Adopted:       make([]string, 0),
+		Undecryptable: make([]string, 0),
+		Foreign:       make([]string, 0),
+	}
+
+	candidates := make([]string, 0)
+	err := walkFiles(dir, config, func(path, relPath string) error {
+		suffix := encryptedSuffix(path, config)
+		if suffix == "" {
+			return nil
+		}
+
+		if suffix != Suffix(config) {
+			result.Foreign = append(result.Foreign, relPath)
+			return nil
+		}
+
+		protected, err := IsProtected(path, config)
+		if err != nil || protected {
+			return err
+		}
+
+		candidates = append(candidates, path)
+		return nil
+	})
+	if err != nil {
+		return AdoptResult{}, err
+	}
+
+	// the plaintext is only held in memory, to prove the file can be read
+	results, _ := DecryptMany(context.Background(), candidates, config, DecryptOptions{})
+	for _, decrypted := range results {
+		relFilepath, err := relativePath(decrypted.Filepath, config)
+		if err != nil {
+			return AdoptResult{}, err
+		}
+
+		if decrypted.Err != nil {
+			result.Undecryptable = append(result.Undecryptable, relFilepath)
+			continue
+		}
+
+		result.Adopted = append(result.Adopted, relFilepath)
+	}
+
+	if len(result.Adopted) == 0 {
+		return result, nil
+	}
+
+	result.ConfigChanged = true
+	result.Committed = opts.Commit
+	if opts.DryRun {
+		return result, nil
+	}
+
+	config.Files = append(config.Files, result.Adopted...)
+	if err := WriteConfig(&config); err != nil {
+		return AdoptResult{}, err
+	}
+
+	if opts.Commit {
+		gitFilepaths := append(configFilepaths(config), configPaths(result.Adopted, config)...)
+		if err := Commit("adopt", fmt.Sprintf("%d files", len(result.Adopted)), gitFilepaths, config); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
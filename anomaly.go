@@ -0,0 +1,128 @@
+package safe
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// defaultAnomalyWindow is the window mass decryption is detected within,
+// unless the config sets one
+const defaultAnomalyWindow = 10 * time.Minute
+
+// anomalyBaseline is how much history a user needs before reading a file
+// they've never read is considered unusual
+const anomalyBaseline = 7 * 24 * time.Hour
+
+// AnomalyConfig: the access patterns that raise alerts, an early signal of a
+// compromised workstation scraping secrets. When set, every decryption is
+// recorded in the audit log.
+type AnomalyConfig struct {
+	// MassFiles alerts when a user decrypts this many different files
+	// within WindowMinutes, defaulting to 10 minutes
+	MassFiles     int `yaml:"mass_files,omitempty"`
+	WindowMinutes int `yaml:"window_minutes,omitempty"`
+
+	// NewFiles alerts when a user with at least a week of history decrypts
+	// a file they've never decrypted before
+	NewFiles bool `yaml:"new_files,omitempty"`
+}
+
+// Enabled: return whether any anomaly is detected
+func (a AnomalyConfig) Enabled() bool {
+	return a.MassFiles > 0 || a.NewFiles
+}
+
+// window: return the window mass decryption is detected within
+func (a AnomalyConfig) window() time.Duration {
+	if a.WindowMinutes > 0 {
+		return time.Duration(a.WindowMinutes) * time.Minute
+	}
+
+	return defaultAnomalyWindow
+}
+
+// detectAnomalies: alert on the anomalies that decrypting a file completes,
+// based on the user's history in the audit log. Detection never blocks the
+// decryption, so failures are only logged.
+func detectAnomalies(relFilepath string, config Config) {
+	anomalies := config.Anomalies
+	if !anomalies.Enabled() {
+		return
+	}
+
+	history, err := readAudit(AuditLogPath(config))
+	if err != nil {
+		logWarning("unable to read the audit log:", err)
+		return
+	}
+
+	actor := currentUser(config)
+	now := time.Now().UTC()
+
+	seen := false
+	var firstAccess time.Time
+	recent := make(map[string]bool)
+	for _, entry := range history {
+		if entry.Actor != actor || entry.Action == "anomaly" || entry.Filepath == "" {
+			continue
+		}
+
+		seen = seen || entry.Filepath == relFilepath
+		if firstAccess.IsZero() || entry.Time.Before(firstAccess) {
+			firstAccess = entry.Time
+		}
+		if now.Sub(entry.Time) <= anomalies.window() {
+			recent[entry.Filepath] = true
+		}
+	}
+
+	// alert once, as the access that reaches the threshold is made
+	if anomalies.MassFiles > 0 && !recent[relFilepath] && len(recent)+1 == anomalies.MassFiles {
+		alertAnomaly(relFilepath, fmt.Sprintf("%s decrypted %d files within %s", actor, anomalies.MassFiles, anomalies.window()), config)
+	}
+
+	if anomalies.NewFiles && !seen && !firstAccess.IsZero() && now.Sub(firstAccess) >= anomalyBaseline {
+		alertAnomaly(relFilepath, fmt.Sprintf("%s decrypted %s for the first time", actor, relFilepath), config)
+	}
+}
+
+// alertAnomaly: warn about an anomaly, record it in the audit log and send
+// it to the notification hooks
+func alertAnomaly(relFilepath, detail string, config Config) {
+	logWarning("unusual access:", detail)
+
+	entry := AuditEntry{Time: time.Now().UTC(), Actor: currentUser(config), Action: "anomaly", Filepath: relFilepath, Reason: detail}
+	if err := appendAudit(AuditLogPath(config), entry); err != nil {
+		logWarning("unable to record anomaly in the audit log:", err)
+	}
+
+	notify("anomaly", []string{configPath(relFilepath, config)}, detail, config)
+}
+
+// readAudit: read the entries of an audit log, skipping lines that can't be
+// parsed. A missing log has no entries.
+func readAudit(auditLogPath string) ([]AuditEntry, error) {
+	reader, err := os.Open(auditLogPath)
+	if os.IsNotExist(err) {
+		return []AuditEntry(nil), nil
+	}
+	if err != nil {
+		return []AuditEntry(nil), err
+	}
+	defer reader.Close()
+
+	entries := make([]AuditEntry, 0)
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err == nil {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, scanner.Err()
+}
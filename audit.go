@@ -0,0 +1,135 @@
+package safe
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// AuditIssue: a problem found with a protected file
+type AuditIssue struct {
+	Filepath string `json:"filepath"`
+	Problem  string `json:"problem"`
+}
+
+func (i AuditIssue) String() string {
+	return fmt.Sprintf("%s: %s", i.Filepath, i.Problem)
+}
+
+// coloredString: format the issue with its file in red
+func (i AuditIssue) coloredString() string {
+	return fmt.Sprintf("%s: %s", colorize(redColor, i.Filepath), i.Problem)
+}
+
+// Audit: inspect the ciphertext of every active protected file, reporting
+// files that are corrupt, encrypted to keys missing from the keyring, that
+// none of the user's secret keys can decrypt, or that are still encrypted to
+// a denied recipient, or to a ci recipient without being tagged ci. Only
+// the packets of gpg ciphertexts are parsed, unless decrypt is set, in
+// which case every file is decrypted in memory to prove it can be read.
+func Audit(config Config, decrypt bool) ([]AuditIssue, error) {
+	denied, err := deniedKeyIDs(config)
+	if err != nil {
+		return []AuditIssue(nil), err
+	}
+
+	ciKeyIDs, err := keyIDsOf(config.CIRecipients, config)
+	if err != nil {
+		return []AuditIssue(nil), err
+	}
+
+	secretKeys, err := secretKeyIDs(config)
+	if err != nil {
+		return []AuditIssue(nil), err
+	}
+
+	filepaths, err := ProtectedFiles(config)
+	if err != nil {
+		return []AuditIssue(nil), err
+	}
+
+	issues := make([]AuditIssue, 0)
+	parsed := make([]string, 0, len(filepaths))
+	knownKeys := make(map[string]bool)
+	for _, filepath := range filepaths {
+		if config.Metadata[filepath].Archived {
+			continue
+		}
+
+		backend, err := BackendFor(filepath, config)
+		if err != nil {
+			return []AuditIssue(nil), err
+		}
+
+		if _, ok := backend.(gpgBackend); !ok {
+			parsed = append(parsed, filepath)
+			continue
+		}
+
+		keyIDs, err := ciphertextKeyIDs(configPath(filepath, config), config)
+		if err != nil {
+			issues = append(issues, AuditIssue{Filepath: filepath, Problem: "corrupt, unable to list packets: " + err.Error()})
+			continue
+		}
+		parsed = append(parsed, filepath)
+
+		ownKey := false
+		for _, keyID := range keyIDs {
+			if _, ok := secretKeys[keyID]; ok {
+				ownKey = true
+			}
+
+			// hidden recipients are listed with an all zero key id
+			if strings.Trim(keyID, "0") == "" {
+				continue
+			}
+
+			known, ok := knownKeys[keyID]
+			if !ok {
+				keys, err := listGPGKeys(keyID, config)
+				if err != nil {
+					return []AuditIssue(nil), err
+				}
+				known = len(keys) > 0
+				knownKeys[keyID] = known
+			}
+
+			if !known {
+				issues = append(issues, AuditIssue{Filepath: filepath, Problem: "encrypted to unknown key " + keyID})
+			}
+
+			if recipient, ok := denied[keyID]; ok {
+				issues = append(issues, AuditIssue{
+					Filepath: filepath,
+					Problem:  "encrypted to denied recipient " + recipient + ", reencrypt it",
+				})
+			}
+
+			if recipient, ok := ciKeyIDs[keyID]; ok && !config.Metadata[filepath].CI {
+				issues = append(issues, AuditIssue{
+					Filepath: filepath,
+					Problem:  "encrypted to ci recipient " + recipient + " without being tagged ci",
+				})
+			}
+		}
+
+		if !ownKey && !decrypt {
+			issues = append(issues, AuditIssue{Filepath: filepath, Problem: "not encrypted to any of your secret keys, you can't decrypt it"})
+		}
+	}
+
+	if !decrypt {
+		return issues, nil
+	}
+
+	// the plaintext is only held in memory and never shown, so files that
+	// require a reason to access aren't prompted for one
+	results, _ := DecryptMany(context.Background(), configPaths(parsed, config), config, DecryptOptions{})
+	for idx, result := range results {
+		if result.Err != nil {
+			issues = append(issues, AuditIssue{Filepath: parsed[idx], Problem: "unable to decrypt: " + strings.TrimSpace(result.Err.Error())})
+		}
+	}
+
+	return issues, nil
+}
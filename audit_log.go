@@ -0,0 +1,101 @@
+package safe
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// AuditEntry: a record of an action taken on a protected file
+type AuditEntry struct {
+	Time     time.Time `json:"time"`
+	Actor    string    `json:"actor"`
+	Action   string    `json:"action"`
+	Filepath string    `json:"filepath"`
+	Reason   string    `json:"reason,omitempty"`
+}
+
+// AuditLogPath: return the path of the repository's local audit log
+func AuditLogPath(config Config) string {
+	return filepath.Join(config.baseDir, ".safe", "audit.log")
+}
+
+// RecordAudit: append an entry to the audit log, attributed to the current
+// user
+func RecordAudit(action, targetFilepath, reason string, config Config) error {
+	relFilepath, err := relativePath(targetFilepath, config)
+	if err != nil {
+		return err
+	}
+
+	return appendAudit(AuditLogPath(config), AuditEntry{
+		Time:     time.Now().UTC(),
+		Actor:    currentUser(config),
+		Action:   action,
+		Filepath: relFilepath,
+		Reason:   reason,
+	})
+}
+
+// appendAudit: append an entry to the audit log at the given path
+func appendAudit(auditLogPath string, entry AuditEntry) error {
+	byts, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(auditLogPath), 0755); err != nil {
+		return err
+	}
+
+	writer, err := os.OpenFile(auditLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+
+	_, err = writer.Write(append(byts, '\n'))
+	return err
+}
+
+// authorizeDecrypt: for files flagged with confirm_decrypt, require the user
+// to give a reason for accessing the file, recording it in the audit log.
+// When anomaly detection is enabled, every access is recorded.
+func authorizeDecrypt(action, targetFilepath string, config Config) error {
+	relFilepath, err := relativePath(targetFilepath, config)
+	if err != nil {
+		return err
+	}
+
+	confirm := config.Metadata[relFilepath].ConfirmDecrypt
+	if !confirm && !config.Anomalies.Enabled() {
+		return nil
+	}
+
+	reason := ""
+	if confirm {
+		if reason, err = promptReason(relFilepath); err != nil {
+			return err
+		}
+	}
+
+	detectAnomalies(relFilepath, config)
+
+	return RecordAudit(action, targetFilepath, reason, config)
+}
+
+// promptReason: ask the user for their reason to access a file
+func promptReason(relFilepath string) (string, error) {
+	reason, err := Prompt("reason for accessing " + relFilepath + ":")
+	if err != nil {
+		return "", err
+	}
+
+	if reason == "" {
+		return "", errors.New("a reason is required to access " + relFilepath)
+	}
+
+	return reason, nil
+}
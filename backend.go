@@ -0,0 +1,261 @@
+package safe
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Backend: an encryption implementation used to protect files
+type Backend interface {
+	Encrypt(filepath string, byts []byte, recipients []string) error
+	Decrypt(filepath string) ([]byte, error)
+}
+
+// DefaultBackend is used when neither the file nor the config specifies one
+const DefaultBackend = "gpg"
+
+var backends = map[string]Backend{
+	"gpg": gpgBackend{},
+	"kms": kmsBackend{},
+}
+
+// RegisterBackend: make a backend available under the given name, so that
+// backends can be implemented in their own packages, outside of safe's core
+func RegisterBackend(name string, backend Backend) {
+	backends[name] = backend
+}
+
+// BackendFor: return the backend that the given file should be encrypted
+// and decrypted with
+func BackendFor(filepath string, config Config) (Backend, error) {
+	name := backendName(filepath, config)
+
+	backend, ok := backends[name]
+	if !ok {
+		return nil, errors.New("unknown backend " + name + " for " + filepath)
+	}
+
+	if _, ok := backend.(gpgBackend); ok {
+		backend = gpgBackend{config: config}
+	}
+
+	return backend, nil
+}
+
+// backendName: return the name of the backend configured for the file
+func backendName(filepath string, config Config) string {
+	name, ok := config.Backends[filepath]
+	if !ok {
+		name = config.Backend
+	}
+
+	if name == "" {
+		name = defaultBackendName(config)
+	}
+
+	return name
+}
+
+// defaultBackendName: return the backend to use when none is configured,
+// which is gpg unless it isn't installed and age has been registered
+func defaultBackendName(config Config) string {
+	if _, err := exec.LookPath(gpgBinaryFor(config)); err == nil {
+		return DefaultBackend
+	}
+
+	if _, ok := backends["age"]; ok {
+		return "age"
+	}
+
+	return DefaultBackend
+}
+
+// gpgBackend: encrypts files as ascii armored gpg messages
+type gpgBackend struct {
+	// config selects the identity to decrypt with, and the team keys to
+	// encrypt with
+	config Config
+}
+
+func (g gpgBackend) Encrypt(filepath string, byts []byte, recipients []string) error {
+	args := []string{"-a", "-e", "--yes", "--output", filepath}
+
+	keyringArgs, err := teamKeyringArgs(g.config)
+	if err != nil {
+		return err
+	}
+	args = append(args, keyringArgs...)
+	for _, recipient := range recipients {
+		args = append(args, gpgRecipientArgs(recipient)...)
+	}
+
+	cmd := gpgCommand(g.config, args...)
+	cmd.Stdin = bytes.NewBuffer(append(byts, '\n'))
+	return runCommand(cmd)
+}
+
+// gpgRecipientArgs: return the gpg arguments to encrypt to a recipient,
+// which is either a key in the keyring or a `file:` path to an exported key
+func gpgRecipientArgs(recipient string) []string {
+	if keyFilepath := strings.TrimPrefix(recipient, "file:"); keyFilepath != recipient {
+		return []string{"--recipient-file", keyFilepath}
+	}
+
+	return []string{"-r", recipient}
+}
+
+func (g gpgBackend) Decrypt(filepath string) ([]byte, error) {
+	args := []string{"-d", filepath}
+	if identity := Identity(g.config); identity != "" {
+		args = append([]string{"--default-key", identity, "--try-secret-key", identity}, args...)
+	}
+
+	cmd := gpgCommand(g.config, args...)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := runCommand(cmd); err != nil {
+		return []byte(nil), err
+	}
+
+	// note: we trim the trailing new line before returning, since it's
+	// added in by the command output
+	return bytes.TrimSuffix(stdout.Bytes(), []byte("\n")), nil
+}
+
+// kmsBackend: encrypts files with an AWS KMS key, using the `aws` cli. The
+// recipient is the KMS key id or alias, and the ciphertext is stored base64
+// encoded.
+type kmsBackend struct{}
+
+func (kmsBackend) Encrypt(filepath string, byts []byte, recipients []string) error {
+	if len(recipients) != 1 {
+		return errors.New("kms backend requires exactly one key id as recipient")
+	}
+
+	cmd := command("aws", "kms", "encrypt",
+		"--key-id", recipients[0],
+		"--plaintext", "fileb:///dev/stdin",
+		"--output", "text",
+		"--query", "CiphertextBlob")
+	cmd.Stdin = bytes.NewBuffer(byts)
+
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := runCommand(cmd); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath, bytes.TrimSpace(stdout.Bytes()), 0644)
+}
+
+func (kmsBackend) Decrypt(filepath string) ([]byte, error) {
+	encoded, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return []byte(nil), err
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil {
+		return []byte(nil), err
+	}
+
+	cmd := command("aws", "kms", "decrypt",
+		"--ciphertext-blob", "fileb:///dev/stdin",
+		"--output", "text",
+		"--query", "Plaintext")
+	cmd.Stdin = bytes.NewBuffer(ciphertext)
+
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := runCommand(cmd); err != nil {
+		return []byte(nil), err
+	}
+
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(stdout.String()))
+}
+
+// invalidRecipientReasons: descriptions of the gpg INV_RECP status codes
+var invalidRecipientReasons = map[string]string{
+	"0":  "no specific reason given",
+	"1":  "key not found",
+	"2":  "ambiguous key specification",
+	"3":  "wrong key usage, no encryption subkey",
+	"4":  "key revoked",
+	"5":  "key expired",
+	"6":  "no CRL known",
+	"7":  "CRL too old",
+	"8":  "policy mismatch",
+	"9":  "not a secret key",
+	"10": "key not trusted",
+	"11": "missing certificate",
+	"12": "missing issuer certificate",
+	"13": "key disabled",
+	"14": "syntax error in key specification",
+}
+
+// probeGPGRecipient: perform a test encryption to a single recipient,
+// returning a descriptive error when gpg is unable to use their key
+func probeGPGRecipient(recipient string, config Config) error {
+	resolved, err := ResolveRecipients([]string{recipient})
+	if err != nil {
+		return err
+	}
+
+	args := []string{"--batch", "--yes", "--auto-key-locate", "local", "--status-fd", "1", "-a", "-e", "--output", os.DevNull}
+	for _, resolvedRecipient := range resolved {
+		args = append(args, gpgRecipientArgs(resolvedRecipient)...)
+	}
+
+	cmd := gpgCommand(config, args...)
+	cmd.Stdin = strings.NewReader("safe probe\n")
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	runErr := runCommand(cmd)
+
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[0] != "[GNUPG:]" {
+			continue
+		}
+
+		if fields[1] != "INV_RECP" && fields[1] != "INV_SGNR" {
+			continue
+		}
+
+		reason, ok := invalidRecipientReasons[fields[2]]
+		if !ok {
+			reason = "unusable key (code " + fields[2] + ")"
+		}
+
+		// gpg reports missing keys without a specific reason, but
+		// explains itself on stderr
+		for _, msg := range strings.Split(stderr.String(), "\n") {
+			if idx := strings.Index(msg, "skipped: "); fields[2] == "0" && idx >= 0 {
+				reason = msg[idx+len("skipped: "):]
+			}
+		}
+
+		return errors.New(recipient + ": " + reason)
+	}
+
+	if runErr != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = runErr.Error()
+		}
+		return errors.New(recipient + ": " + msg)
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package safe
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+// helperOutputEnvVar is set on the commands outputRunner returns, which run
+// TestHelperProcess to write its value to stdout
+const helperOutputEnvVar = "SAFE_TEST_HELPER_OUTPUT"
+
+// outputRunner: runs every command as a helper process that writes output
+type outputRunner struct {
+	output string
+}
+
+func (o outputRunner) Command(name string, args ...string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHelperProcess$")
+	cmd.Env = append(os.Environ(), helperOutputEnvVar+"="+o.output)
+	return cmd
+}
+
+func TestHelperProcess(t *testing.T) {
+	output, ok := os.LookupEnv(helperOutputEnvVar)
+	if !ok {
+		return
+	}
+
+	fmt.Print(output)
+	os.Exit(0)
+}
+
+func TestGPGDecryptTrimsNewline(t *testing.T) {
+	defer SetRunner(LocalRunner{})
+
+	for output, expected := range map[string]string{
+		"a: 1\n":   "a: 1",
+		"a: 1":     "a: 1",
+		"a: 1\n\n": "a: 1\n",
+		"":         "",
+	} {
+		SetRunner(outputRunner{output})
+
+		byts, err := gpgBackend{}.Decrypt("a.yml.gpg")
+		if err != nil {
+			t.Fatalf("expected decrypting %q to succeed, got %v", output, err)
+		}
+
+		if string(byts) != expected {
+			t.Fatalf("expected %q to decrypt to %q, got %q", output, expected, byts)
+		}
+	}
+}
+
+func TestGPGDecryptDryRun(t *testing.T) {
+	dryRun := &DryRunRunner{}
+	SetRunner(dryRun)
+	defer SetRunner(LocalRunner{})
+
+	byts, err := gpgBackend{}.Decrypt("a.yml.gpg")
+	if err != nil {
+		t.Fatalf("expected a dry run decrypt to succeed, got %v", err)
+	}
+
+	if len(byts) != 0 {
+		t.Fatalf("expected a dry run decrypt to be empty, got %q", byts)
+	}
+
+	commands := dryRun.Commands()
+	if len(commands) != 1 {
+		t.Fatalf("expected a single gpg command, got %v", commands)
+	}
+
+	if args := commands[0]; args[len(args)-2] != "-d" || args[len(args)-1] != "a.yml.gpg" {
+		t.Fatalf("expected gpg to decrypt a.yml.gpg, got %v", args)
+	}
+}
@@ -0,0 +1,107 @@
+// Package age registers the age backend with safe. It lives in its own
+// package so that importers of safe only depend on the age library when they
+// import it, eg:
+//
+//	import _ "github.com/jonmorehouse/safe/backends/age"
+package age
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/jonmorehouse/safe"
+)
+
+// AgeIdentityEnvVar points at the age identity file used for decryption
+const AgeIdentityEnvVar = "SAFE_AGE_IDENTITY"
+
+func init() {
+	safe.RegisterBackend("age", ageBackend{})
+}
+
+// ageBackend: encrypts files as ascii armored age messages, using the age
+// library compiled into the binary rather than an external command. The
+// recipients are age public keys.
+type ageBackend struct{}
+
+func (ageBackend) Encrypt(filepath string, byts []byte, recipients []string) error {
+	ageRecipients := make([]age.Recipient, 0, len(recipients))
+	for _, recipient := range recipients {
+		ageRecipient, err := age.ParseX25519Recipient(recipient)
+		if err != nil {
+			return err
+		}
+		ageRecipients = append(ageRecipients, ageRecipient)
+	}
+
+	var ciphertext bytes.Buffer
+	armorWriter := armor.NewWriter(&ciphertext)
+
+	writer, err := age.Encrypt(armorWriter, ageRecipients...)
+	if err != nil {
+		return err
+	}
+
+	if _, err := writer.Write(byts); err != nil {
+		return err
+	}
+
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	if err := armorWriter.Close(); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath, ciphertext.Bytes(), 0644)
+}
+
+func (ageBackend) Decrypt(filepath string) ([]byte, error) {
+	identities, err := ageIdentities()
+	if err != nil {
+		return []byte(nil), err
+	}
+
+	reader, err := os.Open(filepath)
+	if err != nil {
+		return []byte(nil), err
+	}
+	defer reader.Close()
+
+	plaintext, err := age.Decrypt(armor.NewReader(reader), identities...)
+	if err != nil {
+		return []byte(nil), err
+	}
+
+	return ioutil.ReadAll(plaintext)
+}
+
+// ageIdentities: load the user's age identities from SAFE_AGE_IDENTITY, or
+// age.txt next to the user config
+func ageIdentities() ([]age.Identity, error) {
+	identityFilepath := os.Getenv(AgeIdentityEnvVar)
+	if identityFilepath == "" {
+		userConfigPath, err := safe.UserConfigPath()
+		if err != nil {
+			return []age.Identity(nil), err
+		}
+		identityFilepath = filepath.Join(filepath.Dir(userConfigPath), "age.txt")
+	}
+
+	reader, err := os.Open(identityFilepath)
+	if os.IsNotExist(err) {
+		return []age.Identity(nil), errors.New("no age identity found at " + identityFilepath)
+	}
+	if err != nil {
+		return []age.Identity(nil), err
+	}
+	defer reader.Close()
+
+	return age.ParseIdentities(reader)
+}
@@ -0,0 +1,49 @@
+package safe
+
+import (
+	"errors"
+	"os"
+	"strings"
+)
+
+// BatchEnvVar enables batch mode when set, as with SetBatch, eg: in CI
+const BatchEnvVar = "SAFE_BATCH"
+
+// batch guarantees that safe never waits on the user
+var batch = os.Getenv(BatchEnvVar) != ""
+
+// SetBatch: enable or disable batch mode, for running safe from CI and
+// scripts. In batch mode confirmations are answered yes, while anything
+// that needs the user's input, such as an editor, a reason or a passphrase
+// prompt, fails straight away rather than waiting for them.
+func SetBatch(enabled bool) {
+	batch = enabled
+}
+
+// checkInteractive: return an error in batch mode, naming what would have
+// needed the user
+func checkInteractive(what string) error {
+	if batch {
+		return errors.New(what + " needs input, which isn't possible in batch mode")
+	}
+
+	return nil
+}
+
+// batchGPGArgs: return the arguments that stop gpg from prompting in batch
+// mode. A passphrase prompt fails, unless gpg_options in the user config
+// set another pinentry mode, eg: loopback with a --passphrase-file.
+func batchGPGArgs(gpgOptions []string) []string {
+	if !batch {
+		return []string(nil)
+	}
+
+	args := []string{"--batch", "--no-tty"}
+	for _, option := range gpgOptions {
+		if option == "--pinentry-mode" || strings.HasPrefix(option, "--pinentry-mode=") {
+			return args
+		}
+	}
+
+	return append(args, "--pinentry-mode", "error")
+}
@@ -0,0 +1,288 @@
+package safe
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ProtectBundle: protect a directory as a single encrypted tar bundle, eg:
+// certs/ as certs.tar.gpg.asc, for sets of secrets that must move together.
+// The directory is removed once it's encrypted.
+func ProtectBundle(dir string, config Config, opts Options) (Result, error) {
+	dir = filepath.Clean(dir)
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return Result{}, err
+	}
+	if !info.IsDir() {
+		return Result{}, errors.New(dir + " is not a directory")
+	}
+
+	targetFilepath := EnsureSuffix(dir+".tar", config)
+
+	relFilepath, err := relativePath(targetFilepath, config)
+	if err != nil {
+		return Result{}, err
+	}
+
+	if relDir, err := relativePath(dir, config); err != nil || isIgnored(relDir, config) {
+		if err == nil {
+			err = errors.New(dir + " is ignored, refusing to protect it")
+		}
+		return Result{}, err
+	}
+
+	protected, err := IsProtected(targetFilepath, config)
+	if err != nil {
+		return Result{}, err
+	}
+	if protected && !opts.Force {
+		return Result{}, errors.New(targetFilepath + " already protected")
+	}
+
+	result := Result{
+		Written:       []string{targetFilepath},
+		Removed:       []string{dir},
+		ConfigChanged: !protected,
+		Committed:     opts.Commit,
+	}
+
+	if opts.DryRun {
+		return result, nil
+	}
+
+	byts, err := tarDirectory(dir)
+	if err != nil {
+		return Result{}, err
+	}
+
+	metadata := config.Metadata[relFilepath]
+	metadata.Bundle = true
+	setMetadata(relFilepath, metadata, &config)
+
+	if err := Encrypt(targetFilepath, byts, config, false, "protect"); err != nil {
+		return Result{}, err
+	}
+
+	if err := removePlaintextDir(dir, config); err != nil {
+		return Result{}, err
+	}
+
+	if !opts.Commit {
+		return result, nil
+	}
+
+	return result, Commit("protect", dir, append(configFilepaths(config), dir, targetFilepath), config)
+}
+
+// UnpackBundle: decrypt a bundle into a directory, which must not exist
+func UnpackBundle(targetFilepath, dir string, config Config) error {
+	relFilepath, err := relativePath(targetFilepath, config)
+	if err != nil {
+		return err
+	}
+
+	if !config.Metadata[relFilepath].Bundle {
+		return errors.New(targetFilepath + " is not a bundle")
+	}
+
+	if _, err := os.Stat(dir); err == nil {
+		return errors.New(dir + " already exists")
+	}
+
+	if err := authorizeDecrypt("unpack", targetFilepath, config); err != nil {
+		return err
+	}
+
+	byts, err := Decrypt(targetFilepath, config)
+	if err != nil {
+		return err
+	}
+
+	return untarDirectory(byts, dir)
+}
+
+// isBundle: return whether a protected file is a directory bundle
+func isBundle(targetFilepath string, config Config) bool {
+	relFilepath, err := relativePath(targetFilepath, config)
+	return err == nil && config.Metadata[relFilepath].Bundle
+}
+
+// editBundle: unpack a bundle into a temporary workspace, open the editor
+// on it and bundle it back up when anything changed
+func editBundle(targetFilepath string, config Config, commit bool, opts RecipientOptions) error {
+	byts, err := Decrypt(targetFilepath, config)
+	if err != nil {
+		return err
+	}
+
+	workspace, err := ioutil.TempDir(tempDirFor(config), "safe--"+filepath.Base(TrimSuffix(targetFilepath, config)))
+	if err != nil {
+		return err
+	}
+	defer removePlaintextDir(workspace, config)
+
+	dir := filepath.Join(workspace, strings.TrimSuffix(filepath.Base(TrimSuffix(targetFilepath, config)), ".tar"))
+	if err := untarDirectory(byts, dir); err != nil {
+		return err
+	}
+
+	cmd := command(editorFor(config), dir)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	if err := runCommand(cmd); err != nil {
+		return err
+	}
+
+	editedByts, err := tarDirectory(dir)
+	if err != nil {
+		return err
+	}
+
+	if bytes.Equal(byts, editedByts) {
+		logInfo("no changes found ...")
+		return nil
+	}
+
+	if err := EncryptWith(targetFilepath, editedByts, config, commit, "edit", opts); err != nil {
+		return err
+	}
+
+	notify("edit", []string{targetFilepath}, "", config)
+	return nil
+}
+
+// tarDirectory: archive the files in a directory. Entries are sorted and
+// timestamps dropped, so unchanged content always archives the same.
+func tarDirectory(dir string) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := tar.NewWriter(&buf)
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil || relPath == "." {
+			return err
+		}
+
+		header := &tar.Header{
+			Name:    filepath.ToSlash(relPath),
+			Mode:    int64(info.Mode().Perm()),
+			ModTime: time.Unix(0, 0),
+			Format:  tar.FormatPAX,
+		}
+
+		switch {
+		case info.IsDir():
+			header.Typeflag = tar.TypeDir
+			header.Name += "/"
+			return writer.WriteHeader(header)
+		case info.Mode().IsRegular():
+			header.Typeflag = tar.TypeReg
+			header.Size = info.Size()
+		default:
+			return errors.New(path + " is not a regular file, only files and directories can be bundled")
+		}
+
+		byts, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		if err := writer.WriteHeader(header); err != nil {
+			return err
+		}
+
+		_, err = writer.Write(byts)
+		return err
+	})
+	if err != nil {
+		return []byte(nil), err
+	}
+
+	if err := writer.Close(); err != nil {
+		return []byte(nil), err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// untarDirectory: extract an archive made by tarDirectory into a directory,
+// refusing entries that would escape it
+func untarDirectory(byts []byte, dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	reader := tar.NewReader(bytes.NewReader(byts))
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := filepath.FromSlash(header.Name)
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(filepath.Clean(name), ".."+string(filepath.Separator)) {
+			return errors.New("bundle entry " + header.Name + " is outside of the bundle")
+		}
+		path := filepath.Join(dir, name)
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, os.FileMode(header.Mode).Perm()|0700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+				return err
+			}
+
+			writer, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, os.FileMode(header.Mode).Perm())
+			if err != nil {
+				return err
+			}
+
+			if _, err := io.Copy(writer, reader); err != nil {
+				writer.Close()
+				return err
+			}
+
+			if err := writer.Close(); err != nil {
+				return err
+			}
+		default:
+			return errors.New("bundle entry " + header.Name + " is not a file or directory")
+		}
+	}
+}
+
+// removePlaintextDir: remove a plaintext directory, shredding its files
+// when configured
+func removePlaintextDir(dir string, config Config) error {
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+
+		return removePlaintext(path, config)
+	})
+	if err != nil {
+		return err
+	}
+
+	return os.RemoveAll(dir)
+}
@@ -0,0 +1,232 @@
+package safe
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// minSecretLength is the shortest value considered a secret when checking
+// for plaintext leaks, to avoid flagging common short values
+const minSecretLength = 8
+
+// Leak: a secret value found in plaintext outside of its protected file
+type Leak struct {
+	Filepath string
+	Line     int
+
+	// Secret is the protected file the value belongs to
+	Secret string
+}
+
+func (l Leak) String() string {
+	return fmt.Sprintf("%s:%d: contains a value from %s", l.Filepath, l.Line, l.Secret)
+}
+
+// CheckPlaintext: scan the files under dir for any secret value from the
+// protected files, returning each place one was found
+func CheckPlaintext(dir string, config Config) ([]Leak, error) {
+	hashes, err := secretHashes(config)
+	if err != nil {
+		return []Leak(nil), err
+	}
+
+	leaks := make([]Leak, 0)
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if protected, err := IsProtected(path, config); err != nil || protected {
+			return err
+		}
+
+		byts, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		// skip binary files
+		sniff := byts
+		if len(sniff) > 8000 {
+			sniff = sniff[:8000]
+		}
+		if bytes.IndexByte(sniff, 0) >= 0 {
+			return nil
+		}
+
+		for idx, line := range strings.Split(string(byts), "\n") {
+			if secret, ok := lineLeak(line, hashes); ok {
+				leaks = append(leaks, Leak{Filepath: path, Line: idx + 1, Secret: secret})
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return []Leak(nil), err
+	}
+
+	return leaks, nil
+}
+
+// CheckPlaintextRange: scan the lines added in a git revision range, eg:
+// origin/master..HEAD, for any secret value from the protected files
+func CheckPlaintextRange(revRange string, config Config) ([]Leak, error) {
+	hashes, err := secretHashes(config)
+	if err != nil {
+		return []Leak(nil), err
+	}
+
+	diff, err := gitOutput("diff", "--unified=0", "--no-color", "--relative", revRange)
+	if err != nil {
+		return []Leak(nil), err
+	}
+
+	leaks := make([]Leak, 0)
+	var currentFilepath string
+	var lineNumber int
+	scanner := bufio.NewScanner(strings.NewReader(diff))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		switch {
+		case strings.HasPrefix(line, "+++ "):
+			currentFilepath = strings.TrimPrefix(strings.TrimPrefix(line, "+++ "), "b/")
+		case strings.HasPrefix(line, "@@ "):
+			// @@ -a,b +c,d @@
+			fields := strings.Fields(line)
+			if len(fields) < 3 {
+				continue
+			}
+			start := strings.SplitN(strings.TrimPrefix(fields[2], "+"), ",", 2)[0]
+			lineNumber, _ = strconv.Atoi(start)
+		case strings.HasPrefix(line, "+"):
+			if protected, _ := IsProtected(currentFilepath, config); !protected {
+				if secret, ok := lineLeak(line[1:], hashes); ok {
+					leaks = append(leaks, Leak{Filepath: currentFilepath, Line: lineNumber, Secret: secret})
+				}
+			}
+			lineNumber++
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return []Leak(nil), err
+	}
+
+	return leaks, nil
+}
+
+// secretHashes: decrypt every active protected file, returning the hash of
+// each secret value mapped to the file it came from
+func secretHashes(config Config) (map[[sha256.Size]byte]string, error) {
+	protectedFiles, err := ProtectedFiles(config)
+	if err != nil {
+		return nil, err
+	}
+
+	filepaths := make([]string, 0, len(protectedFiles))
+	for _, filepath := range protectedFiles {
+		if !config.Metadata[filepath].Archived {
+			filepaths = append(filepaths, filepath)
+		}
+	}
+
+	results, err := DecryptMany(context.Background(), configPaths(filepaths, config), config, DecryptOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make(map[[sha256.Size]byte]string)
+	for idx, result := range results {
+		for _, value := range secretValues(result.Byts) {
+			if len(value) >= minSecretLength {
+				hashes[sha256.Sum256([]byte(value))] = filepaths[idx]
+			}
+		}
+	}
+
+	return hashes, nil
+}
+
+// secretValues: return the values in a decrypted file. Structured yaml (and
+// json) files yield their leaf values, while anything else yields its lines.
+func secretValues(byts []byte) []string {
+	var parsed interface{}
+	if err := yaml.Unmarshal(byts, &parsed); err == nil {
+		if _, ok := parsed.(map[interface{}]interface{}); ok {
+			return flattenValues(parsed)
+		}
+	}
+
+	values := make([]string, 0)
+	for _, line := range strings.Split(string(byts), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			values = append(values, line)
+		}
+	}
+
+	return values
+}
+
+// flattenValues: return the leaf values of a parsed yaml document
+func flattenValues(value interface{}) []string {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		values := make([]string, 0)
+		for _, nested := range value {
+			values = append(values, flattenValues(nested)...)
+		}
+		return values
+	case []interface{}:
+		values := make([]string, 0)
+		for _, nested := range value {
+			values = append(values, flattenValues(nested)...)
+		}
+		return values
+	case nil:
+		return []string(nil)
+	default:
+		return []string{strings.TrimSpace(fmt.Sprintf("%v", value))}
+	}
+}
+
+// lineLeak: check the line, and the tokens within it, against the secret
+// hashes
+func lineLeak(line string, hashes map[[sha256.Size]byte]string) (string, bool) {
+	candidates := []string{strings.TrimSpace(line)}
+	candidates = append(candidates, strings.Fields(line)...)
+	candidates = append(candidates, strings.FieldsFunc(line, func(r rune) bool {
+		return strings.ContainsRune(" \t\"'`=:,;()[]{}<>", r)
+	})...)
+
+	for _, candidate := range candidates {
+		if len(candidate) < minSecretLength {
+			continue
+		}
+
+		if secret, ok := hashes[sha256.Sum256([]byte(candidate))]; ok {
+			return secret, true
+		}
+	}
+
+	return "", false
+}
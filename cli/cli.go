@@ -0,0 +1,322 @@
+// Package cli implements the safe command line tool on top of the safe
+// package. It's kept apart from safe so that programs embedding safe don't
+// pull in the command line parsing, and so that the binary, in cmd/safe,
+// can choose which backends to compile in.
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jonmorehouse/safe"
+)
+
+// errProblems is returned by commands that report problems, eg: verify, so
+// that safe exits non-zero without printing anything further
+var errProblems = errors.New("problems found")
+
+// command: a subcommand of the cli
+type command struct {
+	usage string
+	run   func(env *env, args []string) error
+
+	// subcommands are run by the first argument, falling back to run when
+	// it doesn't name one
+	subcommands map[string]command
+}
+
+// env: the global flags, and the config they select, shared by every
+// subcommand
+type env struct {
+	configFilepath string
+	profile        string
+	output         string
+	verbose        bool
+	quiet          bool
+	noColor        bool
+	batch          bool
+
+	stdout, stderr io.Writer
+}
+
+// stringsFlag: a flag that may be given more than once, collecting each
+// value
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// changeFlags: the flags of subcommands that change protected files
+type changeFlags struct {
+	dryRun   bool
+	force    bool
+	noCommit bool
+}
+
+// options: return the safe options the flags select
+func (c changeFlags) options() safe.Options {
+	return safe.Options{Commit: !c.noCommit, DryRun: c.dryRun, Force: c.force}
+}
+
+// Run: run the command line with the given arguments, not including the
+// program name, and return the exit code
+func Run(args []string) int {
+	e := &env{stdout: os.Stdout, stderr: os.Stderr}
+	if err := e.run(args); err != nil {
+		if err != errProblems {
+			fmt.Fprintln(e.stderr, "safe:", err)
+		}
+		return 1
+	}
+
+	return 0
+}
+
+// run: parse the global flags given before the subcommand and run it
+func (e *env) run(args []string) error {
+	flags := e.flagSet("safe")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	args = flags.Args()
+
+	if len(args) == 0 {
+		e.usage(commands)
+		return errors.New("no command given")
+	}
+
+	return e.dispatch(commands, args)
+}
+
+// dispatch: run the subcommand named by the first argument
+func (e *env) dispatch(cmds map[string]command, args []string) error {
+	cmd, ok := cmds[args[0]]
+	if !ok {
+		e.usage(cmds)
+		return errors.New("unknown command " + args[0])
+	}
+
+	if len(args) > 1 {
+		if _, ok := cmd.subcommands[args[1]]; ok {
+			return e.dispatch(cmd.subcommands, args[1:])
+		}
+	}
+
+	if cmd.run != nil {
+		return cmd.run(e, args[1:])
+	}
+
+	if len(args) < 2 {
+		e.usage(cmd.subcommands)
+		return errors.New(args[0] + " requires a subcommand")
+	}
+
+	e.usage(cmd.subcommands)
+	return errors.New("unknown command " + args[0] + " " + args[1])
+}
+
+// usage: list the subcommands on stderr
+func (e *env) usage(cmds map[string]command) {
+	names := make([]string, 0, len(cmds))
+	for name := range cmds {
+		if !strings.HasPrefix(name, "__") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(e.stderr, "usage: safe [flags] <command> [args]")
+	for _, name := range names {
+		fmt.Fprintf(e.stderr, "  %-16s %s\n", name, cmds[name].usage)
+	}
+}
+
+// flagSet: return a flag set for a subcommand, with the global flags, which
+// every subcommand accepts, already defined
+func (e *env) flagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(e.stderr)
+
+	flags.StringVar(&e.configFilepath, "config", e.configFilepath, "the config to use, instead of the nearest safe.yml")
+	flags.StringVar(&e.profile, "profile", e.profile, "the profile to select")
+	flags.StringVar(&e.output, "output", e.output, "the output format of read commands, text or json, or the ciphertext path for protect")
+	flags.BoolVar(&e.verbose, "verbose", e.verbose, "show each command as it's run")
+	flags.BoolVar(&e.quiet, "quiet", e.quiet, "hide everything but errors")
+	flags.BoolVar(&e.noColor, "no-color", e.noColor, "never color output")
+	flags.BoolVar(&e.batch, "batch", e.batch, "never prompt, answering confirmations with yes")
+	flags.BoolVar(&e.batch, "yes", e.batch, "the same as --batch")
+
+	return flags
+}
+
+// changeFlagSet: return a flag set for a subcommand that changes protected
+// files
+func (e *env) changeFlagSet(name string, change *changeFlags) *flag.FlagSet {
+	flags := e.flagSet(name)
+	flags.BoolVar(&change.dryRun, "dry-run", false, "report what would change, without changing anything")
+	flags.BoolVar(&change.force, "force", false, "overwrite existing files and skip confirmations")
+	flags.BoolVar(&change.force, "f", false, "the same as --force")
+	flags.BoolVar(&change.noCommit, "no-commit", false, "leave the change uncommitted")
+
+	return flags
+}
+
+// parse: parse the flags, which may come before, after or between the
+// positional arguments, and apply the global flags. Everything after -- is
+// positional.
+func (e *env) parse(flags *flag.FlagSet, args []string, minArgs, maxArgs int) ([]string, error) {
+	positional := make([]string, 0, len(args))
+	for {
+		if err := flags.Parse(args); err != nil {
+			return []string(nil), err
+		}
+
+		rest := flags.Args()
+		if parsed := len(args) - len(rest); parsed > 0 && args[parsed-1] == "--" {
+			positional = append(positional, rest...)
+			break
+		}
+
+		if len(rest) == 0 {
+			break
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+
+	if err := e.apply(); err != nil {
+		return []string(nil), err
+	}
+
+	if len(positional) < minArgs || (maxArgs >= 0 && len(positional) > maxArgs) {
+		return []string(nil), fmt.Errorf("%s: expected %s", flags.Name(), argCount(minArgs, maxArgs))
+	}
+
+	return positional, nil
+}
+
+// argCount: describe the number of arguments a subcommand expects
+func argCount(minArgs, maxArgs int) string {
+	switch {
+	case maxArgs < 0:
+		return fmt.Sprintf("at least %d arguments", minArgs)
+	case minArgs == maxArgs:
+		return fmt.Sprintf("%d arguments", minArgs)
+	}
+
+	return fmt.Sprintf("%d to %d arguments", minArgs, maxArgs)
+}
+
+// apply: apply the global flags to safe
+func (e *env) apply() error {
+	switch {
+	case e.verbose:
+		safe.SetLogLevel(safe.LogVerbose)
+	case e.quiet:
+		safe.SetLogLevel(safe.LogQuiet)
+	}
+
+	if e.noColor {
+		if err := safe.SetColor("never"); err != nil {
+			return err
+		}
+	}
+
+	if e.batch {
+		safe.SetBatch(true)
+	}
+
+	if e.profile != "" {
+		return os.Setenv(safe.ProfileEnvVar, e.profile)
+	}
+
+	return nil
+}
+
+// config: load the config given by --config, or else the nearest one above
+// the working directory. Unlike safe.LoadConfig, the working directory is
+// left alone, so that paths passed to safe stay relative to it.
+func (e *env) config() (safe.Config, error) {
+	configFilepath, err := e.findConfig()
+	if err != nil {
+		return safe.Config{}, err
+	}
+
+	return safe.LoadConfigFrom(configFilepath)
+}
+
+// findConfig: return the path of the config given by --config or
+// SAFE_CONFIG, or else of the nearest one above the working directory
+func (e *env) findConfig() (string, error) {
+	if e.configFilepath != "" {
+		return e.configFilepath, nil
+	}
+
+	if configFilepath := os.Getenv(safe.ConfigEnvVar); configFilepath != "" {
+		return configFilepath, nil
+	}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		for _, name := range []string{"safe.yml", "safe.json"} {
+			configFilepath := filepath.Join(dir, name)
+			if _, err := os.Stat(configFilepath); err == nil {
+				return configFilepath, nil
+			}
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", errors.New("no safe.yml file found")
+		}
+		dir = parent
+	}
+}
+
+// write: write the result of a read command in the selected output format
+func (e *env) write(result interface{}) error {
+	return safe.WriteOutput(e.stdout, e.output, result)
+}
+
+// writeResult: report what a dry run would change. Changes that were made
+// are reported by safe as it makes them.
+func (e *env) writeResult(result safe.Result, change changeFlags) error {
+	if !change.dryRun {
+		return nil
+	}
+
+	if e.output == safe.OutputJSON {
+		return e.write(result)
+	}
+
+	for _, written := range result.Written {
+		fmt.Fprintln(e.stdout, "write", written)
+	}
+	for _, removed := range result.Removed {
+		fmt.Fprintln(e.stdout, "remove", removed)
+	}
+	if result.ConfigChanged {
+		fmt.Fprintln(e.stdout, "update the config")
+	}
+	if result.Committed {
+		fmt.Fprintln(e.stdout, "commit")
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package cli
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		args       []string
+		positional []string
+		dryRun     bool
+	}{
+		{"flags first", []string{"--dry-run", "a.yml"}, []string{"a.yml"}, true},
+		{"flags last", []string{"a.yml", "--dry-run"}, []string{"a.yml"}, true},
+		{"flags between", []string{"a.yml", "--dry-run", "b.yml"}, []string{"a.yml", "b.yml"}, true},
+		{"after --", []string{"a.yml", "--", "--dry-run"}, []string{"a.yml", "--dry-run"}, false},
+	} {
+		e := &env{stdout: ioutil.Discard, stderr: ioutil.Discard}
+		var change changeFlags
+
+		positional, err := e.parse(e.changeFlagSet("remove", &change), test.args, 1, -1)
+		if err != nil {
+			t.Fatalf("%s: expected no error, got %v", test.name, err)
+		}
+
+		if !reflect.DeepEqual(positional, test.positional) {
+			t.Fatalf("%s: expected %v, got %v", test.name, test.positional, positional)
+		}
+
+		if change.dryRun != test.dryRun {
+			t.Fatalf("%s: expected dry run to be %v", test.name, test.dryRun)
+		}
+	}
+}
+
+func TestParseChecksArgCount(t *testing.T) {
+	e := &env{stdout: ioutil.Discard, stderr: ioutil.Discard}
+
+	if _, err := e.parse(e.flagSet("move"), []string{"a.yml"}, 2, 2); err == nil {
+		t.Fatal("expected a missing argument to be rejected")
+	}
+}
+
+func TestDispatchFallsBackToRun(t *testing.T) {
+	var ran []string
+	cmds := map[string]command{
+		"delegate": {
+			run: func(e *env, args []string) error {
+				ran = append([]string{"delegate"}, args...)
+				return nil
+			},
+			subcommands: map[string]command{
+				"expire": {run: func(e *env, args []string) error {
+					ran = []string{"expire"}
+					return nil
+				}},
+			},
+		},
+	}
+
+	for _, test := range []struct {
+		args     []string
+		expected []string
+	}{
+		{[]string{"delegate", "expire"}, []string{"expire"}},
+		{[]string{"delegate", "bob"}, []string{"delegate", "bob"}},
+	} {
+		e := &env{stdout: ioutil.Discard, stderr: ioutil.Discard}
+		if err := e.dispatch(cmds, test.args); err != nil {
+			t.Fatalf("%v: expected no error, got %v", test.args, err)
+		}
+
+		if !reflect.DeepEqual(ran, test.expected) {
+			t.Fatalf("%v: expected %v to run, got %v", test.args, test.expected, ran)
+		}
+	}
+}
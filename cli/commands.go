@@ -0,0 +1,1594 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jonmorehouse/safe"
+)
+
+// commands are the cli's subcommands, as completed by safe.Completion
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"init":            {usage: "create a safe.yml", run: runInit},
+		"edit":            {usage: "create or edit a protected file", run: runEdit},
+		"print":           {usage: "print the plaintext of a protected file", run: runPrint},
+		"prompt-set":      {usage: "set a single value read from the terminal", run: runPromptSet},
+		"diff":            {usage: "diff a protected file against its working copy", run: runDiff},
+		"protect":         {usage: "encrypt a file", run: runProtect},
+		"unprotect":       {usage: "decrypt a file and delete its ciphertext", run: runUnprotect},
+		"unpack":          {usage: "extract a protected bundle", run: runUnpack},
+		"remove":          {usage: "delete a protected file", run: runRemove},
+		"restore":         {usage: "recover a removed file from git history", run: runRestore},
+		"undo":            {usage: "recover the files deleted by the last remove", run: runUndo},
+		"move":            {usage: "move a protected file", run: runMove},
+		"copy":            {usage: "copy a protected file", run: runCopy},
+		"archive":         {usage: "stop reencrypting a protected file", run: runArchive},
+		"convert":         {usage: "convert a protected file to another format", run: runConvert},
+		"reencrypt":       {usage: "reencrypt protected files to their recipients", run: runReencrypt},
+		"adopt":           {usage: "register encrypted files missing from the config", run: runAdopt},
+		"prune":           {usage: "remove config entries for missing files", run: runPrune},
+		"sync":            {usage: "adopt, prune and protect in a single commit", run: runSync},
+		"gc":              {usage: "remove stale temporary files", run: runGC},
+		"status":          {usage: "report whether protected files are up to date", run: runStatus},
+		"verify":          {usage: "report files encrypted to old keys", run: runVerify},
+		"audit":           {usage: "inspect the ciphertext of protected files", run: runAudit},
+		"info":            {usage: "describe a protected file", run: runInfo},
+		"list":            {usage: "list protected files", run: runList},
+		"find":            {usage: "find protected files under a directory", run: runFind},
+		"tree":            {usage: "map protected files", run: runTree},
+		"grep":            {usage: "search the plaintext of protected files", run: runGrep},
+		"history":         {usage: "list the revisions of a protected file", run: runHistory},
+		"blame":           {usage: "annotate a protected file's lines with revisions", run: runBlame},
+		"peek":            {usage: "show the structure of a protected file", run: runPeek},
+		"impact":          {usage: "list what depends on a protected file", run: runImpact},
+		"check-plaintext": {usage: "find committed plaintext secrets", run: runCheckPlaintext},
+		"exec":            {usage: "run a command with decrypted values", run: runExec},
+		"link":            {usage: "share a protected file by a link", run: runLink},
+		"redeem":          {usage: "read a file shared by a link", run: runRedeem},
+		"lock":            {usage: "lock a protected file", run: runLock},
+		"unlock":          {usage: "unlock a protected file", run: runUnlock},
+		"trust":           {usage: "import the team keyring", run: runTrust},
+		"plan":            {usage: "plan a recipient change for review", run: runPlan},
+		"apply":           {usage: "apply a reviewed plan", run: runApply},
+		"shred":           {usage: "overwrite and delete a plaintext file", run: runShred},
+		"watch":           {usage: "reencrypt changed files after merges", run: runWatch},
+		"lint":            {usage: "check safe.yml for problems", run: runLint},
+		"doctor":          {usage: "diagnose the local setup", run: runDoctor},
+		"selftest":        {usage: "check safe works end to end", run: runSelfTest},
+		"completion":      {usage: "generate a shell completion script", run: runCompletion},
+		"__complete":      {usage: "complete protected files", run: runComplete},
+		"recipients": {usage: "manage recipients", subcommands: map[string]command{
+			"list":     {usage: "list the recipients", run: runRecipientsList},
+			"add":      {usage: "add a recipient", run: runRecipientsAdd},
+			"remove":   {usage: "remove a recipient", run: runRecipientsRemove},
+			"rotate":   {usage: "replace a recipient's key", run: runRecipientsRotate},
+			"diff":     {usage: "diff the recipients between revisions", run: runRecipientsDiff},
+			"sync":     {usage: "sync the recipients with an ldap or google group", run: runRecipientsSync},
+			"probe":    {usage: "check every recipient's key can be used", run: runRecipientsProbe},
+			"coverage": {usage: "report who can decrypt each file", run: runRecipientsCoverage},
+			"access":   {usage: "list the files a key can decrypt", run: runRecipientsAccess},
+		}},
+		"delegate": {usage: "grant temporary access", run: runDelegate, subcommands: map[string]command{
+			"expire": {usage: "remove expired delegations", run: runDelegateExpire},
+		}},
+		"keys": {usage: "manage recipient keys", subcommands: map[string]command{
+			"fetch":  {usage: "fetch missing recipient keys", run: runKeysFetch},
+			"expiry": {usage: "report recipient keys close to expiry", run: runKeysExpiry},
+		}},
+		"config": {usage: "manage safe.yml", subcommands: map[string]command{
+			"sign":    {usage: "sign safe.yml", run: runConfigSign},
+			"migrate": {usage: "upgrade safe.yml to the latest version", run: runConfigMigrate},
+		}},
+	}
+}
+
+func runInit(e *env, args []string) error {
+	var recipients stringsFlag
+	flags := e.flagSet("init")
+	flags.Var(&recipients, "recipient", "a recipient of the config, which may be given more than once")
+
+	args, err := e.parse(flags, args, 0, 1)
+	if err != nil {
+		return err
+	}
+
+	dir := "."
+	if len(args) == 1 {
+		dir = args[0]
+	}
+
+	_, err = safe.Init(dir, safe.InitOptions{Recipients: recipients, Commit: true})
+	return err
+}
+
+func runEdit(e *env, args []string) error {
+	args, err := e.parse(e.flagSet("edit"), args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	return safe.Edit(args[0], config, true)
+}
+
+func runPrint(e *env, args []string) error {
+	args, err := e.parse(e.flagSet("print"), args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	return safe.Print(args[0], config)
+}
+
+func runPromptSet(e *env, args []string) error {
+	args, err := e.parse(e.flagSet("prompt-set"), args, 2, 2)
+	if err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	return safe.PromptSet(args[0], args[1], config, true)
+}
+
+func runDiff(e *env, args []string) error {
+	var rev string
+	flags := e.flagSet("diff")
+	flags.StringVar(&rev, "rev", "", "diff between two revisions, given as A..B")
+
+	args, err := e.parse(flags, args, 1, 2)
+	if err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	var diff string
+	switch {
+	case rev != "":
+		diff, err = safe.DiffRevisions(args[0], rev, config)
+	case len(args) == 2 && args[1] == "-":
+		diff, err = safe.Diff(args[0], os.Stdin, config)
+	case len(args) == 2:
+		var working *os.File
+		if working, err = os.Open(args[1]); err != nil {
+			return err
+		}
+		defer working.Close()
+		diff, err = safe.Diff(args[0], working, config)
+	default:
+		diff, err = safe.Diff(args[0], nil, config)
+	}
+	if err != nil {
+		return err
+	}
+
+	if safe.UseColor(os.Stdout) {
+		diff = safe.ColorDiff(diff)
+	}
+	_, err = io.WriteString(e.stdout, diff)
+	return err
+}
+
+func runProtect(e *env, args []string) error {
+	var change changeFlags
+	var output, pattern string
+	var recursive, bundle, gitignore bool
+	flags := e.changeFlagSet("protect", &change)
+	flags.BoolVar(&recursive, "recursive", false, "protect every plaintext file in a directory")
+	flags.StringVar(&pattern, "pattern", "", "only protect files matching the pattern with --recursive")
+	flags.BoolVar(&bundle, "bundle", false, "protect a directory as a single encrypted bundle")
+	flags.BoolVar(&gitignore, "gitignore", false, "add the plaintext path to .gitignore")
+
+	// the global --output names the ciphertext here, rather than the output
+	// format
+	outputFormat := e.output
+	args, err := e.parse(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	output, e.output = e.output, outputFormat
+	if output == outputFormat {
+		output = ""
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	opts := change.options()
+	opts.Output = output
+	opts.Gitignore = gitignore
+
+	var result safe.Result
+	switch {
+	case recursive:
+		result, err = safe.ProtectRecursive(args[0], pattern, config, opts)
+	case bundle:
+		result, err = safe.ProtectBundle(args[0], config, opts)
+	default:
+		result, err = safe.Protect(args[0], config, opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	return e.writeResult(result, change)
+}
+
+func runUnprotect(e *env, args []string) error {
+	var change changeFlags
+	var gitignore bool
+	flags := e.changeFlagSet("unprotect", &change)
+	flags.BoolVar(&gitignore, "gitignore", false, "add the plaintext path to .gitignore")
+
+	args, err := e.parse(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	opts := change.options()
+	opts.Gitignore = gitignore
+
+	result, err := safe.Unprotect(args[0], config, opts)
+	if err != nil {
+		return err
+	}
+
+	return e.writeResult(result, change)
+}
+
+func runUnpack(e *env, args []string) error {
+	args, err := e.parse(e.flagSet("unpack"), args, 1, 2)
+	if err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	// bundles unpack next to themselves by default, eg: certs.tar.gpg.asc
+	// into certs
+	dir := strings.TrimSuffix(safe.TrimSuffix(args[0], config), ".tar")
+	if len(args) == 2 {
+		dir = args[1]
+	}
+
+	return safe.UnpackBundle(args[0], dir, config)
+}
+
+func runRemove(e *env, args []string) error {
+	var change changeFlags
+	args, err := e.parse(e.changeFlagSet("remove", &change), args, 1, -1)
+	if err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	for _, arg := range args {
+		result, err := safe.Remove(arg, config, change.options())
+		if err != nil {
+			return err
+		}
+
+		if err := e.writeResult(result, change); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func runRestore(e *env, args []string) error {
+	var change changeFlags
+	var last bool
+	flags := e.changeFlagSet("restore", &change)
+	flags.BoolVar(&last, "last", false, "restore everything the most recent remove deleted")
+
+	args, err := e.parse(flags, args, 0, 1)
+	if err != nil {
+		return err
+	}
+
+	if last == (len(args) == 1) {
+		return errors.New("restore: expected a file or --last")
+	}
+
+	return e.restore(args, change)
+}
+
+func runUndo(e *env, args []string) error {
+	var change changeFlags
+	args, err := e.parse(e.changeFlagSet("undo", &change), args, 0, 1)
+	if err != nil {
+		return err
+	}
+
+	return e.restore(args, change)
+}
+
+// restore: restore the removed file, or without one everything the most
+// recent remove deleted
+func (e *env) restore(args []string, change changeFlags) error {
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	var result safe.Result
+	if len(args) == 1 {
+		result, err = safe.Restore(args[0], config, change.options())
+	} else {
+		result, err = safe.RestoreLast(config, change.options())
+	}
+	if err != nil {
+		return err
+	}
+
+	return e.writeResult(result, change)
+}
+
+func runMove(e *env, args []string) error {
+	var noCommit bool
+	flags := e.flagSet("move")
+	flags.BoolVar(&noCommit, "no-commit", false, "leave the change uncommitted")
+
+	args, err := e.parse(flags, args, 2, 2)
+	if err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	return safe.Move(args[0], args[1], !noCommit, config)
+}
+
+func runCopy(e *env, args []string) error {
+	var recipients stringsFlag
+	var noCommit bool
+	flags := e.flagSet("copy")
+	flags.Var(&recipients, "recipient", "a recipient of the copy, which may be given more than once")
+	flags.BoolVar(&noCommit, "no-commit", false, "leave the change uncommitted")
+
+	args, err := e.parse(flags, args, 2, 2)
+	if err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	return safe.Copy(args[0], args[1], recipients, !noCommit, config)
+}
+
+func runArchive(e *env, args []string) error {
+	var noCommit bool
+	flags := e.flagSet("archive")
+	flags.BoolVar(&noCommit, "no-commit", false, "leave the change uncommitted")
+
+	args, err := e.parse(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	return safe.Archive(args[0], !noCommit, config)
+}
+
+func runConvert(e *env, args []string) error {
+	var change changeFlags
+	var format string
+	flags := e.changeFlagSet("convert", &change)
+	flags.StringVar(&format, "format", "", "the format to convert to: yml, json, env or toml")
+
+	args, err := e.parse(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	if format == "" {
+		return errors.New("convert: --format is required")
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	result, err := safe.Convert(args[0], format, config, change.options())
+	if err != nil {
+		return err
+	}
+
+	return e.writeResult(result, change)
+}
+
+func runReencrypt(e *env, args []string) error {
+	var change changeFlags
+	var all bool
+	var rev string
+	flags := e.changeFlagSet("reencrypt", &change)
+	flags.BoolVar(&all, "all", false, "reencrypt every protected file")
+	flags.StringVar(&rev, "rev", "", "reencrypt the files whose recipients changed since the revision")
+
+	args, err := e.parse(flags, args, 0, 1)
+	if err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	var result safe.Result
+	switch {
+	case rev != "":
+		result, err = safe.ReencryptChanged(rev, config, change.options())
+	case all:
+		result, err = safe.ReencryptAll(config, change.options())
+	case len(args) == 1:
+		return reencryptFile(args[0], config, change)
+	default:
+		return errors.New("reencrypt: expected a file, --all or --rev")
+	}
+	if err != nil {
+		return err
+	}
+
+	return e.writeResult(result, change)
+}
+
+// reencryptFile: reencrypt a single file to its current recipients
+func reencryptFile(targetFilepath string, config safe.Config, change changeFlags) error {
+	targetFilepath = safe.EnsureSuffix(targetFilepath, config)
+	if change.dryRun {
+		return nil
+	}
+
+	byts, err := safe.Decrypt(targetFilepath, config)
+	if err != nil {
+		return err
+	}
+
+	return safe.Encrypt(targetFilepath, byts, config, !change.noCommit, "reencrypt")
+}
+
+func runAdopt(e *env, args []string) error {
+	var change changeFlags
+	args, err := e.parse(e.changeFlagSet("adopt", &change), args, 0, 1)
+	if err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	dir := "."
+	if len(args) == 1 {
+		dir = args[0]
+	}
+
+	result, err := safe.Adopt(dir, config, change.options())
+	if err != nil {
+		return err
+	}
+
+	e.writeAdopted(result)
+	return e.writeResult(result.Result, change)
+}
+
+// writeAdopted: report the encrypted files that couldn't be adopted
+func (e *env) writeAdopted(result safe.AdoptResult) {
+	for _, undecryptable := range result.Undecryptable {
+		fmt.Fprintln(e.stderr, "unable to decrypt", undecryptable+", not adopting it")
+	}
+	for _, foreign := range result.Foreign {
+		fmt.Fprintln(e.stderr, foreign, "was encrypted by another tool, not adopting it")
+	}
+}
+
+func runPrune(e *env, args []string) error {
+	var change changeFlags
+	if _, err := e.parse(e.changeFlagSet("prune", &change), args, 0, 0); err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	result, err := safe.Prune(config, change.options())
+	if err != nil {
+		return err
+	}
+
+	return e.writeResult(result.Result, change)
+}
+
+func runSync(e *env, args []string) error {
+	var change changeFlags
+	if _, err := e.parse(e.changeFlagSet("sync", &change), args, 0, 0); err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	result, err := safe.Reconcile(config, change.options())
+	if err != nil {
+		return err
+	}
+
+	e.writeAdopted(result.Adopt)
+	return e.writeResult(result.Result, change)
+}
+
+func runGC(e *env, args []string) error {
+	var change changeFlags
+	flags := e.flagSet("gc")
+	flags.BoolVar(&change.dryRun, "dry-run", false, "report what would be removed, without removing anything")
+
+	if _, err := e.parse(flags, args, 0, 0); err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	result, err := safe.GC(config, change.options())
+	if err != nil {
+		return err
+	}
+
+	return e.writeResult(result, change)
+}
+
+func runStatus(e *env, args []string) error {
+	if _, err := e.parse(e.flagSet("status"), args, 0, 0); err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	statuses, err := safe.Status(config)
+	if err != nil {
+		return err
+	}
+
+	return e.write(statuses)
+}
+
+func runVerify(e *env, args []string) error {
+	if _, err := e.parse(e.flagSet("verify"), args, 0, 0); err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	issues, err := safe.Verify(config)
+	if err != nil {
+		return err
+	}
+
+	return e.writeProblems(issues, len(issues))
+}
+
+func runAudit(e *env, args []string) error {
+	var decrypt, strength bool
+	flags := e.flagSet("audit")
+	flags.BoolVar(&decrypt, "decrypt", false, "decrypt every file in memory")
+	flags.BoolVar(&strength, "strength", false, "check the strength of secret values")
+
+	if _, err := e.parse(flags, args, 0, 0); err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	if strength {
+		issues, err := safe.AuditStrength(config)
+		if err != nil {
+			return err
+		}
+		return e.writeProblems(issues, len(issues))
+	}
+
+	issues, err := safe.Audit(config, decrypt)
+	if err != nil {
+		return err
+	}
+
+	return e.writeProblems(issues, len(issues))
+}
+
+// writeProblems: write the problems a command found, failing when there are
+// any
+func (e *env) writeProblems(problems interface{}, count int) error {
+	if err := e.write(problems); err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return errProblems
+	}
+
+	return nil
+}
+
+func runInfo(e *env, args []string) error {
+	args, err := e.parse(e.flagSet("info"), args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	info, err := safe.Info(args[0], config)
+	if err != nil {
+		return err
+	}
+
+	return e.write(info)
+}
+
+func runList(e *env, args []string) error {
+	if _, err := e.parse(e.flagSet("list"), args, 0, 0); err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	files, err := safe.List(config, safe.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	return e.write(files)
+}
+
+func runFind(e *env, args []string) error {
+	var archived bool
+	flags := e.flagSet("find")
+	flags.BoolVar(&archived, "archived", false, "include archived files")
+
+	args, err := e.parse(flags, args, 0, 1)
+	if err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	dir := "."
+	if len(args) == 1 {
+		dir = args[0]
+	}
+
+	files, err := safe.Find(dir, config, archived)
+	if err != nil {
+		return err
+	}
+
+	return e.write(files)
+}
+
+func runTree(e *env, args []string) error {
+	var protectedOnly bool
+	flags := e.flagSet("tree")
+	flags.BoolVar(&protectedOnly, "protected-only", false, "only show protected files")
+
+	if _, err := e.parse(flags, args, 0, 0); err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	tree, err := safe.Tree(config, safe.TreeOptions{ProtectedOnly: protectedOnly, Color: safe.UseColor(os.Stdout)})
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(e.stdout, tree)
+	return err
+}
+
+func runGrep(e *env, args []string) error {
+	var ignoreCase bool
+	flags := e.flagSet("grep")
+	flags.BoolVar(&ignoreCase, "ignore-case", false, "match without regard to case")
+	flags.BoolVar(&ignoreCase, "i", false, "the same as --ignore-case")
+
+	args, err := e.parse(flags, args, 1, 2)
+	if err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	path := ""
+	if len(args) == 2 {
+		path = args[1]
+	}
+
+	matches, err := safe.Grep(args[0], path, config, ignoreCase)
+	if err != nil {
+		return err
+	}
+
+	return e.write(matches)
+}
+
+func runHistory(e *env, args []string) error {
+	args, err := e.parse(e.flagSet("history"), args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	revisions, err := safe.History(args[0], config)
+	if err != nil {
+		return err
+	}
+
+	if e.output == safe.OutputJSON {
+		return e.write(revisions)
+	}
+
+	for _, revision := range revisions {
+		fmt.Fprintf(e.stdout, "%s %s %s %s\n", shortHash(revision.Hash), revision.Date.Format("2006-01-02"), revision.Author, revision.Subject)
+	}
+	return nil
+}
+
+func runBlame(e *env, args []string) error {
+	args, err := e.parse(e.flagSet("blame"), args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	lines, err := safe.Blame(args[0], config)
+	if err != nil {
+		return err
+	}
+
+	if e.output == safe.OutputJSON {
+		return e.write(lines)
+	}
+
+	for _, line := range lines {
+		fmt.Fprintf(e.stdout, "%s %s\n", shortHash(line.Revision.Hash), line.Line)
+	}
+	return nil
+}
+
+// shortHash: abbreviate a commit hash as git does
+func shortHash(hash string) string {
+	if len(hash) > 7 {
+		return hash[:7]
+	}
+
+	return hash
+}
+
+func runPeek(e *env, args []string) error {
+	args, err := e.parse(e.flagSet("peek"), args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	entries, err := safe.Peek(args[0], 0, config)
+	if err != nil {
+		return err
+	}
+
+	return e.write(entries)
+}
+
+func runImpact(e *env, args []string) error {
+	args, err := e.parse(e.flagSet("impact"), args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	consumers, err := safe.Impact(args[0], config)
+	if err != nil {
+		return err
+	}
+
+	return e.write(consumers)
+}
+
+func runCheckPlaintext(e *env, args []string) error {
+	var rev string
+	flags := e.flagSet("check-plaintext")
+	flags.StringVar(&rev, "rev", "", "scan the lines added in a revision range, eg: origin/main..HEAD")
+
+	args, err := e.parse(flags, args, 0, 1)
+	if err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	var leaks []safe.Leak
+	if rev != "" {
+		leaks, err = safe.CheckPlaintextRange(rev, config)
+	} else {
+		dir := "."
+		if len(args) == 1 {
+			dir = args[0]
+		}
+		leaks, err = safe.CheckPlaintext(dir, config)
+	}
+	if err != nil {
+		return err
+	}
+
+	return e.writeProblems(leaks, len(leaks))
+}
+
+func runExec(e *env, args []string) error {
+	var transforms stringsFlag
+	flags := e.flagSet("exec")
+	flags.Var(&transforms, "transform", "convert a value before it's exported, as KEY=transform")
+
+	// everything after the file is the command, flags included
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if _, err := e.parse(flags, nil, 0, 0); err != nil {
+		return err
+	}
+
+	args = flags.Args()
+	if len(args) < 2 {
+		return errors.New("exec: expected a file and a command")
+	}
+
+	parsed, err := safe.ParseTransforms(transforms)
+	if err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	return safe.Exec(args[0], config, args[1:], safe.ExecOptions{Transforms: parsed})
+}
+
+func runLink(e *env, args []string) error {
+	var ttl time.Duration
+	flags := e.flagSet("link")
+	flags.DurationVar(&ttl, "ttl", 15*time.Minute, "how long the link can be redeemed for")
+
+	args, err := e.parse(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	token, err := safe.ExposeLink(args[0], ttl, config)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(e.stdout, token)
+	return err
+}
+
+func runRedeem(e *env, args []string) error {
+	args, err := e.parse(e.flagSet("redeem"), args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	byts, err := safe.Redeem(args[0])
+	if err != nil {
+		return err
+	}
+
+	_, err = e.stdout.Write(byts)
+	return err
+}
+
+func runLock(e *env, args []string) error {
+	var duration time.Duration
+	var noCommit bool
+	flags := e.flagSet("lock")
+	flags.DurationVar(&duration, "duration", time.Hour, "how long to hold the lock")
+	flags.BoolVar(&noCommit, "no-commit", false, "leave the change uncommitted")
+
+	args, err := e.parse(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	return safe.LockFile(args[0], duration, !noCommit, config)
+}
+
+func runUnlock(e *env, args []string) error {
+	var force bool
+	flags := e.flagSet("unlock")
+	flags.BoolVar(&force, "force", false, "release a lock held by another user")
+
+	args, err := e.parse(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	return safe.UnlockFile(args[0], force, true, config)
+}
+
+func runTrust(e *env, args []string) error {
+	var sign bool
+	flags := e.flagSet("trust")
+	flags.BoolVar(&sign, "sign", false, "locally sign the imported keys")
+
+	if _, err := e.parse(flags, args, 0, 0); err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	return safe.Trust(sign, config)
+}
+
+func runPlan(e *env, args []string) error {
+	var noCommit bool
+	flags := e.flagSet("plan")
+	flags.BoolVar(&noCommit, "no-commit", false, "plan the change without a commit")
+
+	// eg: safe plan rotate.json rotate old@example.com new@example.com
+	args, err := e.parse(flags, args, 2, 4)
+	if err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	recipient, newRecipient := "", ""
+	if len(args) > 2 {
+		recipient = args[2]
+	}
+	if len(args) > 3 {
+		newRecipient = args[3]
+	}
+
+	plan, err := safe.MakePlan(args[1], recipient, newRecipient, !noCommit, config)
+	if err != nil {
+		return err
+	}
+
+	if err := safe.WritePlan(plan, args[0]); err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(e.stdout, plan)
+	return err
+}
+
+func runApply(e *env, args []string) error {
+	args, err := e.parse(e.flagSet("apply"), args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	plan, err := safe.LoadPlan(args[0])
+	if err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	_, err = safe.ApplyPlan(plan, config)
+	return err
+}
+
+func runShred(e *env, args []string) error {
+	args, err := e.parse(e.flagSet("shred"), args, 1, -1)
+	if err != nil {
+		return err
+	}
+
+	for _, arg := range args {
+		if err := safe.Shred(arg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func runWatch(e *env, args []string) error {
+	var change changeFlags
+	args, err := e.parse(e.changeFlagSet("watch", &change), args, 0, 1)
+	if err != nil {
+		return err
+	}
+
+	// without a revision the hook is installed, which runs watch with the
+	// revision before each merge
+	if len(args) == 0 {
+		return safe.InstallPostMergeHook()
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	result, err := safe.ReencryptChanged(args[0], config, change.options())
+	if err != nil {
+		return err
+	}
+
+	return e.writeResult(result, change)
+}
+
+func runLint(e *env, args []string) error {
+	if _, err := e.parse(e.flagSet("lint"), args, 0, 0); err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	issues, err := safe.LintConfig(config)
+	if err != nil {
+		return err
+	}
+
+	return e.writeProblems(issues, len(issues))
+}
+
+func runDoctor(e *env, args []string) error {
+	if _, err := e.parse(e.flagSet("doctor"), args, 0, 0); err != nil {
+		return err
+	}
+
+	report := safe.Doctor()
+
+	failed := 0
+	for _, check := range report.Checks {
+		if check.Err != nil {
+			failed++
+		}
+	}
+
+	return e.writeProblems(report.Checks, failed)
+}
+
+func runSelfTest(e *env, args []string) error {
+	var keep bool
+	flags := e.flagSet("selftest")
+	flags.BoolVar(&keep, "keep", false, "keep the temporary directory")
+
+	if _, err := e.parse(flags, args, 0, 0); err != nil {
+		return err
+	}
+
+	report, err := safe.SelfTest(keep)
+	for _, step := range report.Steps {
+		switch {
+		case step.Skipped:
+			fmt.Fprintln(e.stdout, "skip", step.Name)
+		case step.Err != nil:
+			fmt.Fprintf(e.stdout, "FAIL %s: %s\n", step.Name, step.Err)
+		default:
+			fmt.Fprintln(e.stdout, "ok  ", step.Name)
+		}
+	}
+	if keep {
+		fmt.Fprintln(e.stderr, "kept", report.Dir)
+	}
+
+	return err
+}
+
+func runCompletion(e *env, args []string) error {
+	args, err := e.parse(e.flagSet("completion"), args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	script, err := safe.Completion(args[0])
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(e.stdout, script)
+	return err
+}
+
+// runComplete: list the protected files for the completion scripts, run as
+// `safe __complete files <prefix>`
+func runComplete(e *env, args []string) error {
+	if len(args) == 0 || args[0] != "files" {
+		return errors.New("__complete: expected files")
+	}
+
+	prefix := ""
+	if len(args) > 1 {
+		prefix = args[1]
+	}
+
+	files, err := safe.CompleteFiles(prefix)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		fmt.Fprintln(e.stdout, file)
+	}
+	return nil
+}
+
+func runRecipientsList(e *env, args []string) error {
+	if _, err := e.parse(e.flagSet("recipients list"), args, 0, 0); err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	return e.write(safe.ListRecipients(config))
+}
+
+func runRecipientsAdd(e *env, args []string) error {
+	return e.changeRecipient("recipients add", args, safe.AddRecipient)
+}
+
+func runRecipientsRemove(e *env, args []string) error {
+	return e.changeRecipient("recipients remove", args, safe.RemoveRecipient)
+}
+
+// changeRecipient: add or remove a recipient, reencrypting files when asked
+func (e *env) changeRecipient(name string, args []string, change func(string, bool, safe.Config, safe.Options) (safe.Result, error)) error {
+	var flags changeFlags
+	var reencrypt bool
+	flagSet := e.changeFlagSet(name, &flags)
+	flagSet.BoolVar(&reencrypt, "reencrypt", false, "reencrypt the affected files")
+
+	args, err := e.parse(flagSet, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	result, err := change(args[0], reencrypt, config, flags.options())
+	if err != nil {
+		return err
+	}
+
+	return e.writeResult(result, flags)
+}
+
+func runRecipientsRotate(e *env, args []string) error {
+	var change changeFlags
+	args, err := e.parse(e.changeFlagSet("recipients rotate", &change), args, 2, 2)
+	if err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	result, err := safe.RotateRecipient(args[0], args[1], config, change.options())
+	if err != nil {
+		return err
+	}
+
+	return e.writeResult(result, change)
+}
+
+func runRecipientsDiff(e *env, args []string) error {
+	args, err := e.parse(e.flagSet("recipients diff"), args, 1, 2)
+	if err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	head := "HEAD"
+	if len(args) == 2 {
+		head = args[1]
+	}
+
+	changes, err := safe.RecipientsDiff(args[0], head, config)
+	if err != nil {
+		return err
+	}
+
+	return e.write(changes)
+}
+
+func runRecipientsSync(e *env, args []string) error {
+	var change changeFlags
+	var reencrypt bool
+	flags := e.changeFlagSet("recipients sync", &change)
+	flags.BoolVar(&reencrypt, "reencrypt", false, "reencrypt the affected files")
+
+	args, err := e.parse(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	result, err := safe.SyncRecipients(args[0], reencrypt, config, change.options())
+	if err != nil {
+		return err
+	}
+
+	for _, missing := range result.Missing {
+		fmt.Fprintln(e.stderr, "no key found for", missing+", leaving them out")
+	}
+
+	return e.writeResult(result.Result, change)
+}
+
+func runRecipientsProbe(e *env, args []string) error {
+	if _, err := e.parse(e.flagSet("recipients probe"), args, 0, 0); err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, result := range safe.ProbeRecipients(config) {
+		if result.Err != nil {
+			failed++
+			fmt.Fprintf(e.stdout, "FAIL %s: %s\n", result.Recipient, result.Err)
+			continue
+		}
+		fmt.Fprintln(e.stdout, "ok  ", result.Recipient)
+	}
+
+	if failed > 0 {
+		return errProblems
+	}
+	return nil
+}
+
+func runRecipientsCoverage(e *env, args []string) error {
+	if _, err := e.parse(e.flagSet("recipients coverage"), args, 0, 0); err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	coverage, err := safe.RecipientCoverage(config)
+	if err != nil {
+		return err
+	}
+
+	if e.output == safe.OutputJSON {
+		return e.write(coverage)
+	}
+
+	for _, recipient := range coverage {
+		fmt.Fprintf(e.stdout, "%s: %d readable, %d unreadable\n", recipient.Recipient, len(recipient.Readable), len(recipient.Unreadable))
+		for _, unreadable := range recipient.Unreadable {
+			fmt.Fprintln(e.stdout, "  can't read", unreadable)
+		}
+	}
+	return nil
+}
+
+func runRecipientsAccess(e *env, args []string) error {
+	var verify bool
+	flags := e.flagSet("recipients access")
+	flags.BoolVar(&verify, "verify", false, "verify against the ciphertext of gpg files")
+
+	args, err := e.parse(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	accesses, err := safe.TestAccess(args[0], verify, config)
+	if err != nil {
+		return err
+	}
+
+	if e.output == safe.OutputJSON {
+		return e.write(accesses)
+	}
+
+	for _, access := range accesses {
+		if access.Configured || access.Encrypted {
+			fmt.Fprintln(e.stdout, access.Filepath)
+		}
+	}
+	return nil
+}
+
+func runDelegate(e *env, args []string) error {
+	var change changeFlags
+	var files stringsFlag
+	var until string
+	flags := e.changeFlagSet("delegate", &change)
+	flags.Var(&files, "files", "a pattern of the files to delegate, which may be given more than once")
+	flags.StringVar(&until, "until", "", "the date the delegation ends, eg: 2024-12-31")
+
+	args, err := e.parse(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	if len(files) == 0 || until == "" {
+		return errors.New("delegate: --files and --until are required")
+	}
+
+	end, err := time.Parse("2006-01-02", until)
+	if err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	result, err := safe.Delegate(args[0], files, end, config, change.options())
+	if err != nil {
+		return err
+	}
+
+	return e.writeResult(result, change)
+}
+
+func runDelegateExpire(e *env, args []string) error {
+	var change changeFlags
+	if _, err := e.parse(e.changeFlagSet("delegate expire", &change), args, 0, 0); err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	result, err := safe.ExpireDelegations(config, change.options())
+	if err != nil {
+		return err
+	}
+
+	return e.writeResult(result, change)
+}
+
+func runKeysFetch(e *env, args []string) error {
+	if _, err := e.parse(e.flagSet("keys fetch"), args, 0, 0); err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	return safe.FetchMissingKeys(safe.ListRecipients(config), config)
+}
+
+func runKeysExpiry(e *env, args []string) error {
+	if _, err := e.parse(e.flagSet("keys expiry"), args, 0, 0); err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	warnings, err := safe.CheckKeyExpiry(safe.ListRecipients(config), config)
+	if err != nil {
+		return err
+	}
+
+	if e.output == safe.OutputJSON {
+		return e.writeProblems(warnings, len(warnings))
+	}
+
+	for _, warning := range warnings {
+		fmt.Fprintf(e.stdout, "%s: expires %s\n", warning.Recipient, warning.Expires.Format("2006-01-02"))
+	}
+
+	if len(warnings) > 0 {
+		return errProblems
+	}
+	return nil
+}
+
+func runConfigSign(e *env, args []string) error {
+	var signer string
+	var noCommit bool
+	flags := e.flagSet("config sign")
+	flags.StringVar(&signer, "signer", "", "the key to sign with, instead of the default key")
+	flags.BoolVar(&noCommit, "no-commit", false, "leave the signature uncommitted")
+
+	if _, err := e.parse(flags, args, 0, 0); err != nil {
+		return err
+	}
+
+	config, err := e.config()
+	if err != nil {
+		return err
+	}
+
+	return safe.SignConfig(config, signer, !noCommit)
+}
+
+func runConfigMigrate(e *env, args []string) error {
+	var change changeFlags
+	flags := e.flagSet("config migrate")
+	flags.BoolVar(&change.dryRun, "dry-run", false, "report whether the config would change, without changing it")
+
+	if _, err := e.parse(flags, args, 0, 0); err != nil {
+		return err
+	}
+
+	// the config isn't loaded, as an old layout may not load at all
+	configFilepath, err := e.findConfig()
+	if err != nil {
+		return err
+	}
+
+	if configFilepath, err = filepath.Abs(configFilepath); err != nil {
+		return err
+	}
+
+	result, err := safe.MigrateConfig(configFilepath, change.options())
+	if err != nil {
+		return err
+	}
+
+	return e.writeResult(result, change)
+}
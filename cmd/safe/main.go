@@ -0,0 +1,15 @@
+// Command safe is the safe command line tool. It compiles in the age
+// backend, so that release builds work without gpg installed.
+package main
+
+import (
+	"os"
+
+	"github.com/jonmorehouse/safe/cli"
+
+	_ "github.com/jonmorehouse/safe/backends/age"
+)
+
+func main() {
+	os.Exit(cli.Run(os.Args[1:]))
+}
@@ -0,0 +1,93 @@
+package safe
+
+import (
+	"errors"
+	"os"
+	"strings"
+)
+
+// NoColorEnvVar disables color when set, unless color is forced, following
+// https://no-color.org
+const NoColorEnvVar = "NO_COLOR"
+
+// Terminal colors: green for protected and current files, red for stale
+// ones and yellow for warnings
+const (
+	greenColor  = "\x1b[32m"
+	redColor    = "\x1b[31m"
+	yellowColor = "\x1b[33m"
+	resetColor  = "\x1b[0m"
+)
+
+// colorMode is when to color output, set by SetColor, eg: for --no-color,
+// and otherwise by color in the user config
+var colorMode string
+
+// SetColor: set when to color output: auto, always or never. Auto colors
+// output to a terminal, unless NO_COLOR is set.
+func SetColor(mode string) error {
+	if err := checkColorMode(mode); err != nil {
+		return err
+	}
+
+	colorMode = mode
+	return nil
+}
+
+// checkColorMode: return an error unless mode is a known color mode
+func checkColorMode(mode string) error {
+	switch mode {
+	case "", "auto", "always", "never":
+		return nil
+	}
+
+	return errors.New("invalid color " + mode + ", expected auto, always or never")
+}
+
+// UseColor: return whether output written to file should be colored, going
+// by SetColor, then color in the user config, then NO_COLOR and whether the
+// file is a terminal
+func UseColor(file *os.File) bool {
+	mode := colorMode
+	if mode == "" {
+		if userConfig, err := LoadUserConfig(); err == nil {
+			mode = userConfig.Color
+		}
+	}
+
+	switch mode {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+
+	return os.Getenv(NoColorEnvVar) == "" && isTerminal(file)
+}
+
+// colorize: wrap the text in the terminal color
+func colorize(color, text string) string {
+	return color + text + resetColor
+}
+
+// colored: implemented by results with a colored text format
+type colored interface {
+	coloredString() string
+}
+
+// ColorDiff: color the added lines of a diff green and the removed lines
+// red, leaving the file headers plain
+func ColorDiff(diff string) string {
+	lines := strings.Split(diff, "\n")
+	for idx, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "+++") || strings.HasPrefix(line, "---"):
+		case strings.HasPrefix(line, "+"):
+			lines[idx] = colorize(greenColor, line)
+		case strings.HasPrefix(line, "-"):
+			lines[idx] = colorize(redColor, line)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,394 @@
+package safe
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// CompletionShells are the shells Completion generates scripts for
+var CompletionShells = []string{"bash", "zsh", "fish"}
+
+// completionCommand: a subcommand of the cli, with its flags and any
+// subcommands of its own
+type completionCommand struct {
+	name        string
+	description string
+	flags       []string
+	subcommands []completionCommand
+
+	// files completes the first argument from the protected files, rather
+	// than the filesystem
+	files bool
+}
+
+// globalFlags are accepted by every subcommand
+var globalFlags = []string{"--config", "--profile", "--output", "--verbose", "--quiet", "--no-color", "--batch", "--yes", "--help"}
+
+// valueFlags take a value as the following argument
+var valueFlags = []string{"--config", "--profile", "--output", "--pattern", "--rev", "--transform", "--files", "--until", "--recipient", "--format", "--ttl", "--duration"}
+
+// changeFlags are accepted by the subcommands that change protected files
+var changeFlags = []string{"--dry-run", "--force", "--no-commit"}
+
+// completionCommands are the cli's subcommands
+var completionCommands = []completionCommand{
+	{name: "init", description: "create a safe.yml", flags: []string{"--recipient"}},
+	{name: "edit", description: "create or edit a protected file", files: true},
+	{name: "print", description: "print the plaintext of a protected file", files: true},
+	{name: "prompt-set", description: "set a single value read from the terminal", files: true},
+	{name: "diff", description: "diff a protected file against its working copy", flags: []string{"--rev"}, files: true},
+	{name: "protect", description: "encrypt a file", flags: append([]string{"--output", "--recursive", "--pattern", "--bundle", "--gitignore"}, changeFlags...)},
+	{name: "unprotect", description: "decrypt a file and delete its ciphertext", flags: append([]string{"--gitignore"}, changeFlags...), files: true},
+	{name: "unpack", description: "extract a protected bundle", files: true},
+	{name: "remove", description: "delete a protected file", flags: changeFlags, files: true},
+	{name: "restore", description: "recover a removed file from git history", flags: append([]string{"--last"}, changeFlags...)},
+	{name: "undo", description: "recover the files deleted by the last remove", flags: changeFlags},
+	{name: "move", description: "move a protected file", flags: []string{"--no-commit"}, files: true},
+	{name: "copy", description: "copy a protected file", flags: []string{"--recipient", "--no-commit"}, files: true},
+	{name: "archive", description: "stop reencrypting a protected file", flags: []string{"--no-commit"}, files: true},
+	{name: "convert", description: "convert a protected file to another format", flags: append([]string{"--format"}, changeFlags...), files: true},
+	{name: "reencrypt", description: "reencrypt protected files to their recipients", flags: append([]string{"--all", "--rev"}, changeFlags...)},
+	{name: "recipients", description: "manage recipients", subcommands: []completionCommand{
+		{name: "list", description: "list the recipients"},
+		{name: "add", description: "add a recipient", flags: append([]string{"--reencrypt"}, changeFlags...)},
+		{name: "remove", description: "remove a recipient", flags: append([]string{"--reencrypt"}, changeFlags...)},
+		{name: "rotate", description: "replace a recipient's key", flags: changeFlags},
+		{name: "diff", description: "diff the recipients between revisions"},
+		{name: "sync", description: "sync the recipients with an ldap or google group", flags: append([]string{"--reencrypt"}, changeFlags...)},
+		{name: "probe", description: "check every recipient's key can be used"},
+		{name: "coverage", description: "report who can decrypt each file"},
+		{name: "access", description: "list the files a key can decrypt", flags: []string{"--verify"}},
+	}},
+	{name: "adopt", description: "register encrypted files missing from the config", flags: changeFlags},
+	{name: "prune", description: "remove config entries for missing files", flags: changeFlags},
+	{name: "sync", description: "adopt, prune and protect in a single commit", flags: changeFlags},
+	{name: "gc", description: "remove stale temporary files", flags: []string{"--dry-run"}},
+	{name: "status", description: "report whether protected files are up to date"},
+	{name: "verify", description: "report files encrypted to old keys"},
+	{name: "audit", description: "inspect the ciphertext of protected files", flags: []string{"--decrypt", "--strength"}},
+	{name: "info", description: "describe a protected file", files: true},
+	{name: "list", description: "list protected files"},
+	{name: "find", description: "find protected files under a directory", flags: []string{"--archived"}},
+	{name: "tree", description: "map protected files", flags: []string{"--protected-only"}},
+	{name: "grep", description: "search the plaintext of protected files", flags: []string{"--ignore-case"}},
+	{name: "history", description: "list the revisions of a protected file", files: true},
+	{name: "blame", description: "annotate a protected file's lines with revisions", files: true},
+	{name: "peek", description: "show the structure of a protected file", files: true},
+	{name: "impact", description: "list what depends on a protected file", files: true},
+	{name: "check-plaintext", description: "find committed plaintext secrets", flags: []string{"--rev"}},
+	{name: "exec", description: "run a command with decrypted values", flags: []string{"--transform"}, files: true},
+	{name: "delegate", description: "grant temporary access", flags: []string{"--files", "--until"}, subcommands: []completionCommand{
+		{name: "expire", description: "remove expired delegations"},
+	}},
+	{name: "link", description: "share a protected file by a link", flags: []string{"--ttl"}, files: true},
+	{name: "redeem", description: "read a file shared by a link"},
+	{name: "lock", description: "lock a protected file", flags: []string{"--duration", "--no-commit"}, files: true},
+	{name: "unlock", description: "unlock a protected file", flags: []string{"--force"}, files: true},
+	{name: "trust", description: "import the team keyring", flags: []string{"--sign"}},
+	{name: "keys", description: "manage recipient keys", subcommands: []completionCommand{
+		{name: "fetch", description: "fetch missing recipient keys"},
+		{name: "expiry", description: "report recipient keys close to expiry"},
+	}},
+	{name: "config", description: "manage safe.yml", subcommands: []completionCommand{
+		{name: "sign", description: "sign safe.yml"},
+		{name: "migrate", description: "upgrade safe.yml to the latest version", flags: []string{"--dry-run"}},
+	}},
+	{name: "plan", description: "plan a recipient change for review"},
+	{name: "apply", description: "apply a reviewed plan"},
+	{name: "shred", description: "overwrite and delete a plaintext file"},
+	{name: "watch", description: "reencrypt changed files after merges", flags: changeFlags},
+	{name: "lint", description: "check safe.yml for problems"},
+	{name: "doctor", description: "diagnose the local setup"},
+	{name: "selftest", description: "check safe works end to end"},
+	{name: "completion", description: "generate a shell completion script", subcommands: []completionCommand{
+		{name: "bash", description: "generate a bash completion script"},
+		{name: "zsh", description: "generate a zsh completion script"},
+		{name: "fish", description: "generate a fish completion script"},
+	}},
+}
+
+// Completion: generate a script completing the cli's subcommands and flags
+// for the shell, one of CompletionShells, eg: to source from .bashrc. Files
+// passed to subcommands such as edit and print are completed from the
+// protected files by running `safe __complete files <prefix>`, which
+// CompleteFiles implements, falling back to the filesystem.
+func Completion(shell string) (string, error) {
+	var builder strings.Builder
+
+	switch shell {
+	case "bash":
+		bashCompletion(&builder)
+	case "zsh":
+		zshCompletion(&builder)
+	case "fish":
+		fishCompletion(&builder)
+	default:
+		return "", errors.New("unknown shell " + shell + ", expected bash, zsh or fish")
+	}
+
+	return builder.String(), nil
+}
+
+// CompleteFiles: return the protected files of the nearest config starting
+// with prefix, relative to the working directory, to complete the files
+// passed to subcommands. The config is found as with LoadConfig, but the
+// working directory isn't changed.
+func CompleteFiles(prefix string) ([]string, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return []string(nil), err
+	}
+
+	configFilepath := os.Getenv(ConfigEnvVar)
+	for dir := cwd; configFilepath == ""; dir = filepath.Dir(dir) {
+		if found, ok := findConfigFile(dir); ok {
+			configFilepath = found
+		} else if filepath.Dir(dir) == dir {
+			return []string(nil), errors.New("no safe.yml file found")
+		}
+	}
+
+	config, err := LoadConfigFrom(configFilepath)
+	if err != nil {
+		return []string(nil), err
+	}
+
+	relFilepaths, err := ProtectedFiles(config)
+	if err != nil {
+		return []string(nil), err
+	}
+
+	// a leading ./ is kept, as the shell only offers completions starting
+	// with what was typed, and limits them to the working directory
+	dot := ""
+	if strings.HasPrefix(prefix, "./") {
+		dot, prefix = "./", strings.TrimPrefix(prefix, "./")
+	}
+
+	completions := make([]string, 0, len(relFilepaths))
+	for _, relFilepath := range relFilepaths {
+		completion, err := filepath.Rel(cwd, configPath(relFilepath, config))
+		if err != nil {
+			return []string(nil), err
+		}
+
+		outside := strings.HasPrefix(completion, ".."+string(filepath.Separator))
+		if strings.HasPrefix(completion, prefix) && !(dot != "" && outside) {
+			completions = append(completions, dot+completion)
+		}
+	}
+	sort.Strings(completions)
+
+	return completions, nil
+}
+
+// completionWords: return the names of the commands
+func completionWords(commands []completionCommand) string {
+	names := make([]string, 0, len(commands))
+	for _, cmd := range commands {
+		names = append(names, cmd.name)
+	}
+
+	return strings.Join(names, " ")
+}
+
+// completionFlags: return the flags of a command, along with the global
+// flags, sorted
+func completionFlags(flags []string) string {
+	all := uniqueStrings(append(append([]string{}, globalFlags...), flags...))
+	sort.Strings(all)
+
+	return strings.Join(all, " ")
+}
+
+// writeShellArgs: write the shell code that finds the subcommand and its
+// subcommand in words, skipping flags and their values, shared by bash and
+// zsh
+func writeShellArgs(builder *strings.Builder, words string) {
+	fmt.Fprintf(builder, "\tlocal cmd=\"\" sub=\"\" skip=\"\" word\n")
+	fmt.Fprintf(builder, "\tfor word in %s; do\n", words)
+	fmt.Fprintf(builder, "\t\tif [ -n \"$skip\" ]; then skip=\"\"; continue; fi\n")
+	fmt.Fprintf(builder, "\t\tcase \"$word\" in\n")
+	fmt.Fprintf(builder, "\t\t%s) skip=1 ;;\n", strings.Join(valueFlags, "|"))
+	fmt.Fprintf(builder, "\t\t-*) ;;\n")
+	fmt.Fprintf(builder, "\t\t*) if [ -z \"$cmd\" ]; then cmd=\"$word\"; elif [ -z \"$sub\" ]; then sub=\"$word\"; fi ;;\n")
+	fmt.Fprintf(builder, "\t\tesac\n")
+	fmt.Fprintf(builder, "\tdone\n\n")
+}
+
+// bashCompletion: write the bash completion script
+func bashCompletion(builder *strings.Builder) {
+	builder.WriteString("# bash completion for safe, eg: source <(safe completion bash)\n")
+	builder.WriteString("_safe() {\n")
+	builder.WriteString("\tlocal cur=\"${COMP_WORDS[COMP_CWORD]}\"\n")
+	writeShellArgs(builder, "\"${COMP_WORDS[@]:1:COMP_CWORD-1}\"")
+
+	fmt.Fprintf(builder, "\tlocal words=\"\" files=\"\" flags=%q\n", completionFlags(nil))
+	builder.WriteString("\tcase \"$cmd\" in\n")
+	fmt.Fprintf(builder, "\t\"\") words=%q ;;\n", completionWords(completionCommands))
+	for _, cmd := range completionCommands {
+		fmt.Fprintf(builder, "\t%s)\n", cmd.name)
+		fmt.Fprintf(builder, "\t\tflags=%q\n", completionFlags(cmd.flags))
+		if cmd.files {
+			builder.WriteString("\t\tfiles=1\n")
+		}
+		if len(cmd.subcommands) > 0 {
+			builder.WriteString("\t\tcase \"$sub\" in\n")
+			fmt.Fprintf(builder, "\t\t\"\") words=%q ;;\n", completionWords(cmd.subcommands))
+			for _, subcommand := range cmd.subcommands {
+				fmt.Fprintf(builder, "\t\t%s) flags=%q ;;\n", subcommand.name, completionFlags(subcommand.flags))
+			}
+			builder.WriteString("\t\tesac\n")
+		}
+		builder.WriteString("\t\t;;\n")
+	}
+	builder.WriteString("\tesac\n\n")
+
+	builder.WriteString("\tif [[ \"$cur\" == -* ]]; then\n")
+	builder.WriteString("\t\tCOMPREPLY=($(compgen -W \"$flags\" -- \"$cur\"))\n")
+	builder.WriteString("\telif [ -n \"$words\" ]; then\n")
+	builder.WriteString("\t\tCOMPREPLY=($(compgen -W \"$words\" -- \"$cur\"))\n")
+	builder.WriteString("\telif [ -n \"$files\" ] && [ -z \"$sub\" ]; then\n")
+	builder.WriteString("\t\tCOMPREPLY=($(safe __complete files \"$cur\" 2>/dev/null))\n")
+	builder.WriteString("\t\t[ ${#COMPREPLY[@]} -gt 0 ] || COMPREPLY=($(compgen -f -- \"$cur\"))\n")
+	builder.WriteString("\telse\n")
+	builder.WriteString("\t\tCOMPREPLY=($(compgen -f -- \"$cur\"))\n")
+	builder.WriteString("\tfi\n")
+	builder.WriteString("}\n")
+	builder.WriteString("complete -o filenames -F _safe safe\n")
+}
+
+// zshDescribe: format commands for zsh's _describe, as name:description
+func zshDescribe(commands []completionCommand) string {
+	described := make([]string, 0, len(commands))
+	for _, cmd := range commands {
+		described = append(described, shellQuote(cmd.name+":"+cmd.description))
+	}
+
+	return strings.Join(described, " ")
+}
+
+// zshCompletion: write the zsh completion script
+func zshCompletion(builder *strings.Builder) {
+	builder.WriteString("#compdef safe\n")
+	builder.WriteString("# zsh completion for safe, eg: source <(safe completion zsh)\n")
+	builder.WriteString("_safe() {\n")
+	writeShellArgs(builder, "\"${(@)words[2,CURRENT-1]}\"")
+
+	builder.WriteString("\tlocal -a subcommands flags protected\n")
+	builder.WriteString("\tlocal files=\"\"\n")
+	fmt.Fprintf(builder, "\tflags=(%s)\n", completionFlags(nil))
+	builder.WriteString("\tcase \"$cmd\" in\n")
+	fmt.Fprintf(builder, "\t\"\") subcommands=(%s) ;;\n", zshDescribe(completionCommands))
+	for _, cmd := range completionCommands {
+		fmt.Fprintf(builder, "\t%s)\n", cmd.name)
+		fmt.Fprintf(builder, "\t\tflags=(%s)\n", completionFlags(cmd.flags))
+		if cmd.files {
+			builder.WriteString("\t\tfiles=1\n")
+		}
+		if len(cmd.subcommands) > 0 {
+			builder.WriteString("\t\tcase \"$sub\" in\n")
+			fmt.Fprintf(builder, "\t\t\"\") subcommands=(%s) ;;\n", zshDescribe(cmd.subcommands))
+			for _, subcommand := range cmd.subcommands {
+				fmt.Fprintf(builder, "\t\t%s) flags=(%s) ;;\n", subcommand.name, completionFlags(subcommand.flags))
+			}
+			builder.WriteString("\t\tesac\n")
+		}
+		builder.WriteString("\t\t;;\n")
+	}
+	builder.WriteString("\tesac\n\n")
+
+	builder.WriteString("\tif [[ \"$PREFIX\" == -* ]]; then\n")
+	builder.WriteString("\t\tcompadd -- $flags\n")
+	builder.WriteString("\telif (( ${#subcommands} )); then\n")
+	builder.WriteString("\t\t_describe command subcommands\n")
+	builder.WriteString("\telif [[ -n \"$files\" && -z \"$sub\" ]]; then\n")
+	builder.WriteString("\t\tprotected=(${(f)\"$(safe __complete files \"$PREFIX\" 2>/dev/null)\"})\n")
+	builder.WriteString("\t\tif (( ${#protected} )); then compadd -- $protected; else _files; fi\n")
+	builder.WriteString("\telse\n")
+	builder.WriteString("\t\t_files\n")
+	builder.WriteString("\tfi\n")
+	builder.WriteString("}\n")
+	builder.WriteString("compdef _safe safe\n")
+}
+
+// fishCompletion: write the fish completion script
+func fishCompletion(builder *strings.Builder) {
+	builder.WriteString("# fish completion for safe, eg: safe completion fish > ~/.config/fish/completions/safe.fish\n")
+
+	// __safe_args prints the subcommand and its subcommand, skipping flags
+	// and their values, and __safe_using checks them
+	builder.WriteString("function __safe_args\n")
+	builder.WriteString("\tset -l skip\n")
+	builder.WriteString("\tset -l args\n")
+	builder.WriteString("\tfor word in (commandline -opc)[2..-1]\n")
+	builder.WriteString("\t\tif test -n \"$skip\"\n\t\t\tset skip\n\t\t\tcontinue\n\t\tend\n")
+	builder.WriteString("\t\tswitch $word\n")
+	fmt.Fprintf(builder, "\t\tcase %s\n\t\t\tset skip 1\n", strings.Join(valueFlags, " "))
+	builder.WriteString("\t\tcase '-*'\n")
+	builder.WriteString("\t\tcase '*'\n\t\t\tset -a args $word\n")
+	builder.WriteString("\t\tend\n")
+	builder.WriteString("\tend\n")
+	builder.WriteString("\tstring join \\n -- $args[1..2]\n")
+	builder.WriteString("end\n\n")
+
+	builder.WriteString("function __safe_using\n")
+	builder.WriteString("\tset -l args (__safe_args)\n")
+	builder.WriteString("\ttest (count $args) -ge (count $argv); or return 1\n")
+	builder.WriteString("\tfor i in (seq (count $argv))\n")
+	builder.WriteString("\t\ttest \"$args[$i]\" = \"$argv[$i]\"; or return 1\n")
+	builder.WriteString("\tend\n")
+	builder.WriteString("end\n\n")
+
+	builder.WriteString("function __safe_files\n")
+	builder.WriteString("\tset -l files (safe __complete files (commandline -ct) 2>/dev/null)\n")
+	builder.WriteString("\tif test (count $files) -gt 0\n")
+	builder.WriteString("\t\tstring join \\n -- $files\n")
+	builder.WriteString("\telse\n")
+	builder.WriteString("\t\t__fish_complete_path (commandline -ct)\n")
+	builder.WriteString("\tend\n")
+	builder.WriteString("end\n\n")
+
+	builder.WriteString("function __safe_needs\n")
+	builder.WriteString("\ttest (count (__safe_args)) -eq (count $argv); and __safe_using $argv\n")
+	builder.WriteString("end\n\n")
+
+	for _, flag := range globalFlags {
+		fmt.Fprintf(builder, "complete -c safe %s\n", fishFlag(flag))
+	}
+
+	for _, cmd := range completionCommands {
+		fmt.Fprintf(builder, "complete -c safe -f -n __safe_needs -a %s -d %s\n", cmd.name, shellQuote(cmd.description))
+		if cmd.files {
+			fmt.Fprintf(builder, "complete -c safe -f -n '__safe_needs %s' -a '(__safe_files)'\n", cmd.name)
+		}
+		for _, flag := range cmd.flags {
+			fmt.Fprintf(builder, "complete -c safe -n '__safe_using %s' %s\n", cmd.name, fishFlag(flag))
+		}
+
+		for _, subcommand := range cmd.subcommands {
+			fmt.Fprintf(builder, "complete -c safe -f -n '__safe_needs %s' -a %s -d %s\n", cmd.name, subcommand.name, shellQuote(subcommand.description))
+			for _, flag := range subcommand.flags {
+				fmt.Fprintf(builder, "complete -c safe -n '__safe_using %s %s' %s\n", cmd.name, subcommand.name, fishFlag(flag))
+			}
+		}
+	}
+}
+
+// fishFlag: format a flag for fish's complete, requiring a value for the
+// flags that take one
+func fishFlag(flag string) string {
+	if flag == "--output" {
+		return "-l output -x -a 'text json'"
+	}
+
+	if containsString(valueFlags, flag) {
+		return "-l " + strings.TrimPrefix(flag, "--") + " -r"
+	}
+
+	return "-l " + strings.TrimPrefix(flag, "--")
+}
@@ -0,0 +1,239 @@
+package safe
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// ConfigLockName is the lock file, in the .safe directory next to safe.yml,
+// that serializes changes to the config between safe processes
+const ConfigLockName = "config.lock"
+
+// configLockTimeout is how long to wait for another process to release
+// the config lock
+const configLockTimeout = 30 * time.Second
+
+// heldConfigLocks counts the config locks held by this process, so that
+// writes made while updating the config don't wait on themselves
+var (
+	heldConfigLocks   = make(map[string]int)
+	heldConfigLocksMu sync.Mutex
+)
+
+// configSnapshot: the parts of a config, as last read from or written to
+// disk, that concurrent changes are merged with
+type configSnapshot struct {
+	Files       []string
+	Overrides   map[string][]string
+	Metadata    map[string]FileMetadata
+	Encryptions map[string]Encryption
+}
+
+// configLockPath: return the path of the lock file for a config
+func configLockPath(configFilepath string) string {
+	return filepath.Join(filepath.Dir(configFilepath), ".safe", ConfigLockName)
+}
+
+// lockConfig: take an advisory lock on the config, exclusive for writing or
+// shared for reading, returning a function that releases it. Locks already
+// held by this process are reentrant.
+func lockConfig(configFilepath string, exclusive bool) (func() error, error) {
+	lockFilepath := configLockPath(configFilepath)
+
+	heldConfigLocksMu.Lock()
+	if heldConfigLocks[lockFilepath] > 0 {
+		heldConfigLocks[lockFilepath]++
+		heldConfigLocksMu.Unlock()
+		return func() error { return releaseHeldLock(lockFilepath, nil) }, nil
+	}
+	heldConfigLocksMu.Unlock()
+
+	flags := os.O_RDONLY
+	if exclusive {
+		if err := os.MkdirAll(filepath.Dir(lockFilepath), 0755); err != nil {
+			return nil, err
+		}
+		flags = os.O_RDWR | os.O_CREATE
+	}
+
+	file, err := os.OpenFile(lockFilepath, flags, 0644)
+	// nothing has ever written the config with locking, so there's nothing
+	// for a reader to wait on
+	if os.IsNotExist(err) && !exclusive {
+		return func() error { return nil }, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(configLockTimeout)
+	for {
+		locked, err := tryLockFile(file, exclusive)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		if locked {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			file.Close()
+			return nil, errors.New(configFilepath + " is locked by another safe process")
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	heldConfigLocksMu.Lock()
+	heldConfigLocks[lockFilepath]++
+	heldConfigLocksMu.Unlock()
+
+	return func() error { return releaseHeldLock(lockFilepath, file) }, nil
+}
+
+// releaseHeldLock: release a reference to a held lock, unlocking the file
+// once the last reference is released
+func releaseHeldLock(lockFilepath string, file *os.File) error {
+	heldConfigLocksMu.Lock()
+	defer heldConfigLocksMu.Unlock()
+
+	heldConfigLocks[lockFilepath]--
+	if heldConfigLocks[lockFilepath] > 0 || file == nil {
+		return nil
+	}
+
+	delete(heldConfigLocks, lockFilepath)
+	if err := unlockFile(file); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// UpdateConfig: change the config with a read-modify-write, holding the
+// config lock while the latest config is loaded from disk, updated and
+// written back, so that concurrent changes are never lost
+func UpdateConfig(config Config, update func(*Config) error) (Config, error) {
+	unlock, err := lockConfig(config.filepath, true)
+	if err != nil {
+		return Config{}, err
+	}
+	defer unlock()
+
+	latest, err := LoadConfigFrom(config.filepath)
+	if err != nil {
+		return Config{}, err
+	}
+
+	if config.profile != "" && latest.profile != config.profile {
+		if latest, err = WithProfile(config.profile, latest); err != nil {
+			return Config{}, err
+		}
+	}
+
+	if err := update(&latest); err != nil {
+		return Config{}, err
+	}
+
+	if err := WriteConfig(&latest); err != nil {
+		return Config{}, err
+	}
+
+	return latest, nil
+}
+
+// snapshotConfig: copy the files, overrides, metadata and encryption records
+// of a config as written to disk
+func snapshotConfig(config Config) *configSnapshot {
+	snapshot := &configSnapshot{
+		Files:       append([]string{}, config.Files...),
+		Overrides:   make(map[string][]string, len(config.Overrides)),
+		Metadata:    make(map[string]FileMetadata, len(config.Metadata)),
+		Encryptions: make(map[string]Encryption, len(config.encryptions)),
+	}
+
+	for filepath, recipients := range config.Overrides {
+		snapshot.Overrides[filepath] = recipients
+	}
+	for filepath, metadata := range config.Metadata {
+		snapshot.Metadata[filepath] = metadata
+	}
+	for filepath, encryption := range config.encryptions {
+		snapshot.Encryptions[filepath] = encryption
+	}
+
+	return snapshot
+}
+
+// mergeConcurrentChanges: merge the files, overrides, metadata and encryption
+// records that another process changed on disk since the config was loaded
+// into the config, unless the config changed them too
+func mergeConcurrentChanges(config *Config, disk Config) {
+	base := config.base
+	if base == nil {
+		return
+	}
+
+	files := make([]string, 0, len(config.Files))
+	for _, file := range config.Files {
+		// files removed on disk are kept removed, unless added back here
+		if containsString(base.Files, file) && !containsString(disk.Files, file) {
+			continue
+		}
+		files = append(files, file)
+	}
+	for _, file := range disk.Files {
+		if !containsString(base.Files, file) && !containsString(files, file) {
+			files = append(files, file)
+		}
+	}
+	config.Files = files
+
+	if config.Overrides == nil {
+		config.Overrides = make(map[string][]string)
+	}
+	for _, filepath := range unionKeys(base.Overrides, disk.Overrides) {
+		theirs, changed := disk.Overrides[filepath]
+		if reflect.DeepEqual(theirs, base.Overrides[filepath]) {
+			continue
+		}
+
+		if ours := config.Overrides[filepath]; !reflect.DeepEqual(ours, base.Overrides[filepath]) {
+			continue
+		}
+
+		if changed {
+			config.Overrides[filepath] = theirs
+		} else {
+			delete(config.Overrides, filepath)
+		}
+	}
+
+	if config.Metadata == nil {
+		config.Metadata = make(map[string]FileMetadata)
+	}
+	for _, filepath := range unionKeys(base.Metadata, disk.Metadata) {
+		theirs := disk.Metadata[filepath]
+		if reflect.DeepEqual(theirs, base.Metadata[filepath]) || !reflect.DeepEqual(config.Metadata[filepath], base.Metadata[filepath]) {
+			continue
+		}
+
+		setMetadata(filepath, theirs, config)
+	}
+
+	for _, filepath := range unionKeys(base.Encryptions, disk.encryptions) {
+		theirs := disk.encryptions[filepath]
+		if reflect.DeepEqual(theirs, base.Encryptions[filepath]) || !reflect.DeepEqual(config.encryptions[filepath], base.Encryptions[filepath]) {
+			continue
+		}
+
+		setEncryption(filepath, theirs, config)
+	}
+}
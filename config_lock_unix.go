@@ -0,0 +1,29 @@
+//go:build !windows
+
+package safe
+
+import (
+	"os"
+	"syscall"
+)
+
+// tryLockFile: take a flock on the file without blocking, returning whether
+// it was taken
+func tryLockFile(file *os.File, exclusive bool) (bool, error) {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+
+	err := syscall.Flock(int(file.Fd()), how|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// unlockFile: release a flock on the file
+func unlockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,18 @@
+//go:build windows
+
+package safe
+
+import (
+	"os"
+)
+
+// tryLockFile: windows has no flock, so the config isn't locked and
+// concurrent writes are only merged
+func tryLockFile(file *os.File, exclusive bool) (bool, error) {
+	return true, nil
+}
+
+// unlockFile: release a lock on the file, a no-op on windows
+func unlockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,284 @@
+package safe
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// configFileNames are the names a config may have, in order of preference
+var configFileNames = []string{"safe.yml", "safe.json"}
+
+// findConfigFile: return the path of the config file in dir, if any
+func findConfigFile(dir string) (string, bool) {
+	for _, name := range configFileNames {
+		configFilepath := filepath.Join(dir, name)
+		if _, err := os.Stat(configFilepath); err == nil {
+			return configFilepath, true
+		}
+	}
+
+	return "", false
+}
+
+// findConfigFileWith: return the path of the config file in dir, if any, as
+// read with read
+func findConfigFileWith(dir string, read fileReader) (string, bool) {
+	for _, name := range configFileNames {
+		configFilepath := filepath.Join(dir, name)
+		if _, err := read(configFilepath); err == nil {
+			return configFilepath, true
+		}
+	}
+
+	return "", false
+}
+
+// configFormat: return the format of a config file from its extension,
+// which is json or yml
+func configFormat(configFilepath string) string {
+	if filepath.Ext(configFilepath) == ".json" {
+		return "json"
+	}
+
+	return "yml"
+}
+
+// decodeConfig: decode a config in the given format. Strict decoding fails
+// on unknown keys.
+func decodeConfig(byts []byte, format string, strict bool, config *Config) error {
+	// json is a subset of yaml, so both are decoded with the yaml field
+	// names
+	if strict {
+		return yaml.UnmarshalStrict(byts, config)
+	}
+
+	return yaml.Unmarshal(byts, config)
+}
+
+// encodeConfig: encode a config in the given format
+func encodeConfig(config Config, format string) ([]byte, error) {
+	byts, err := yaml.Marshal(config)
+	if err != nil || format == "yml" {
+		return byts, err
+	}
+
+	var data map[string]interface{}
+	if err := yaml.Unmarshal(byts, &data); err != nil {
+		return []byte(nil), err
+	}
+
+	return encodeStructured(stringKeys(data).(map[string]interface{}), format)
+}
+
+// fileReader reads the files a config is merged from, eg: as they are now,
+// or as they were at a past revision
+type fileReader func(filepath string) ([]byte, error)
+
+// loadConfigFile: decode a single config file, without merging its parents
+// or includes
+func loadConfigFile(configFilepath string) (Config, error) {
+	return readConfigFile(configFilepath, ioutil.ReadFile)
+}
+
+// readConfigFile: decode a single config file read with read, without
+// merging its parents or includes
+func readConfigFile(configFilepath string, read fileReader) (Config, error) {
+	byts, err := read(configFilepath)
+	if err != nil {
+		return Config{}, err
+	}
+
+	var config Config
+	if err := decodeConfig(byts, configFormat(configFilepath), false, &config); err != nil {
+		return Config{}, fmt.Errorf("%s: %s", configFilepath, err)
+	}
+
+	config.filepath = configFilepath
+	config.baseDir = filepath.Dir(configFilepath)
+
+	// the metadata map is shared by copies of the config, so that encrypting
+	// several files in turn keeps each file's metadata
+	if config.Metadata == nil {
+		config.Metadata = make(map[string]FileMetadata)
+	}
+	if config.encryptions, err = loadEncryptions(config, read); err != nil {
+		return Config{}, err
+	}
+	config.base = snapshotConfig(config)
+
+	return config, nil
+}
+
+// loadConfigChain: load a safe.yml, merging its includes and the chain of
+// safe.yml files above it, up to the root of its git repository. Every file
+// is read with read.
+func loadConfigChain(configFilepath string, read fileReader) (Config, error) {
+	config, err := readConfigFile(configFilepath, read)
+	if err != nil {
+		return Config{}, err
+	}
+
+	switch config.Merge {
+	case "", "extend", "replace":
+	default:
+		return Config{}, errors.New("invalid merge " + config.Merge + ", expected extend or replace")
+	}
+
+	inherited := Config{Overrides: make(map[string][]string)}
+	config.sources = []string{configFilepath}
+
+	parent, ok, err := loadParentConfig(config.baseDir, read)
+	if err != nil {
+		return Config{}, err
+	}
+	if ok && config.Merge != "replace" {
+		inheritConfig(&inherited, parent, config.baseDir)
+		config.sources = append(config.sources, parent.sources...)
+	}
+
+	// NOTE: includes of included files aren't followed
+	for _, includeFilepath := range includePaths(config) {
+		included, err := readConfigFile(includeFilepath, read)
+		if err != nil {
+			return Config{}, err
+		}
+
+		// overrides in an included file are relative to the including config
+		included.baseDir = config.baseDir
+		inheritConfig(&inherited, included, config.baseDir)
+		config.sources = append(config.sources, includeFilepath)
+	}
+
+	config.inherited = &inherited
+	config.Recipients = mergeRecipients(inherited.Recipients, config.Recipients)
+
+	overrides := make(map[string][]string)
+	for filepath, recipients := range inherited.Overrides {
+		overrides[filepath] = recipients
+	}
+	for filepath, recipients := range config.Overrides {
+		overrides[filepath] = recipients
+	}
+	config.Overrides = overrides
+
+	return config, nil
+}
+
+// includePaths: return the paths of the config's includes
+func includePaths(config Config) []string {
+	includeFilepaths := make([]string, 0, len(config.Include))
+	for _, include := range config.Include {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(config.baseDir, include)
+		}
+		includeFilepaths = append(includeFilepaths, include)
+	}
+
+	return includeFilepaths
+}
+
+// loadParentConfig: load the merged config of the nearest safe.yml above
+// dir, within its git repository. Configs outside of a git repository stand
+// alone.
+func loadParentConfig(dir string, read fileReader) (Config, bool, error) {
+	toplevel, err := gitOutput("-C", dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return Config{}, false, nil
+	}
+
+	toplevel = strings.TrimSpace(toplevel)
+	if toplevel == "" {
+		return Config{}, false, nil
+	}
+
+	for dir != toplevel && strings.HasPrefix(dir, toplevel+"/") {
+		dir = filepath.Dir(dir)
+
+		parentFilepath, ok := findConfigFileWith(dir, read)
+		if !ok {
+			continue
+		}
+
+		parent, err := loadConfigChain(parentFilepath, read)
+		if err != nil {
+			return Config{}, false, err
+		}
+
+		return parent, true, nil
+	}
+
+	return Config{}, false, nil
+}
+
+// inheritConfig: add the recipients and overrides of another config to
+// those inherited, keying overrides relative to baseDir and dropping any
+// outside of it
+func inheritConfig(inherited *Config, config Config, baseDir string) {
+	inherited.Recipients = mergeRecipients(inherited.Recipients, config.Recipients)
+
+	for configFilepath, recipients := range config.Overrides {
+		relFilepath, err := filepath.Rel(baseDir, filepath.Join(config.baseDir, configFilepath))
+		if err != nil || strings.HasPrefix(relFilepath, "..") {
+			continue
+		}
+		inherited.Overrides[relFilepath] = recipients
+	}
+}
+
+// mergeRecipients: append the recipients to the base recipients, skipping
+// duplicates
+func mergeRecipients(base, recipients []string) []string {
+	merged := make([]string, 0, len(base)+len(recipients))
+	for _, recipient := range append(append([]string{}, base...), recipients...) {
+		if !containsString(merged, recipient) {
+			merged = append(merged, recipient)
+		}
+	}
+
+	return merged
+}
+
+// ownConfig: return the config with anything it inherited removed, as
+// written back to its safe.yml
+func ownConfig(config Config) Config {
+	if config.inherited == nil {
+		config.inherited = &Config{}
+	}
+
+	recipients := make([]string, 0, len(config.Recipients))
+	for _, recipient := range config.Recipients {
+		if !containsString(config.inherited.Recipients, recipient) {
+			recipients = append(recipients, recipient)
+		}
+	}
+	config.Recipients = recipients
+
+	overrides := make(map[string][]string)
+	for filepath, recipients := range config.Overrides {
+		// overrides from safe.local.yml are replaced by whatever they
+		// shadowed in safe.yml
+		if config.local != nil {
+			if local, ok := config.local.Overrides[filepath]; ok && reflect.DeepEqual(local, recipients) {
+				shadowed, ok := config.local.shadowed[filepath]
+				if !ok {
+					continue
+				}
+				recipients = shadowed
+			}
+		}
+
+		if inherited, ok := config.inherited.Overrides[filepath]; !ok || !reflect.DeepEqual(inherited, recipients) {
+			overrides[filepath] = recipients
+		}
+	}
+	config.Overrides = overrides
+
+	return config
+}
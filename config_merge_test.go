@@ -0,0 +1,208 @@
+package safe
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeRecipients(t *testing.T) {
+	for _, test := range []struct {
+		name             string
+		base, recipients []string
+		expected         []string
+	}{
+		{"appended", []string{"a"}, []string{"b"}, []string{"a", "b"}},
+		{"duplicates skipped", []string{"a", "b"}, []string{"b", "c"}, []string{"a", "b", "c"}},
+		{"duplicates within the base", []string{"a", "a"}, nil, []string{"a"}},
+		{"empty", nil, nil, []string{}},
+	} {
+		if merged := mergeRecipients(test.base, test.recipients); !reflect.DeepEqual(merged, test.expected) {
+			t.Fatalf("%s: expected %v, got %v", test.name, test.expected, merged)
+		}
+	}
+}
+
+func TestInheritConfig(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		config    Config
+		baseDir   string
+		overrides map[string][]string
+	}{
+		{
+			name:      "same directory",
+			config:    Config{baseDir: "/repo", Overrides: map[string][]string{"a.yml": {"x"}}},
+			baseDir:   "/repo",
+			overrides: map[string][]string{"a.yml": {"x"}},
+		},
+		{
+			name:      "rekeyed relative to a subdirectory",
+			config:    Config{baseDir: "/repo", Overrides: map[string][]string{"sub/a.yml": {"x"}}},
+			baseDir:   "/repo/sub",
+			overrides: map[string][]string{"a.yml": {"x"}},
+		},
+		{
+			name:      "outside of the subdirectory dropped",
+			config:    Config{baseDir: "/repo", Overrides: map[string][]string{"other/a.yml": {"x"}}},
+			baseDir:   "/repo/sub",
+			overrides: map[string][]string{},
+		},
+	} {
+		inherited := Config{Recipients: []string{"a"}, Overrides: make(map[string][]string)}
+		test.config.Recipients = []string{"a", "b"}
+		inheritConfig(&inherited, test.config, test.baseDir)
+
+		if expected := []string{"a", "b"}; !reflect.DeepEqual(inherited.Recipients, expected) {
+			t.Fatalf("%s: expected recipients %v, got %v", test.name, expected, inherited.Recipients)
+		}
+
+		if !reflect.DeepEqual(inherited.Overrides, test.overrides) {
+			t.Fatalf("%s: expected overrides %v, got %v", test.name, test.overrides, inherited.Overrides)
+		}
+	}
+}
+
+func TestOwnConfig(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		config     Config
+		recipients []string
+		overrides  map[string][]string
+	}{
+		{
+			name:       "nothing inherited",
+			config:     Config{Recipients: []string{"a"}, Overrides: map[string][]string{"a.yml": {"x"}}},
+			recipients: []string{"a"},
+			overrides:  map[string][]string{"a.yml": {"x"}},
+		},
+		{
+			name: "inherited recipients and overrides removed",
+			config: Config{
+				Recipients: []string{"a", "b"},
+				Overrides:  map[string][]string{"a.yml": {"x"}, "b.yml": {"y"}},
+				inherited:  &Config{Recipients: []string{"a"}, Overrides: map[string][]string{"a.yml": {"x"}}},
+			},
+			recipients: []string{"b"},
+			overrides:  map[string][]string{"b.yml": {"y"}},
+		},
+		{
+			name: "changed inherited override kept",
+			config: Config{
+				Recipients: []string{"a"},
+				Overrides:  map[string][]string{"a.yml": {"y"}},
+				inherited:  &Config{Overrides: map[string][]string{"a.yml": {"x"}}},
+			},
+			recipients: []string{"a"},
+			overrides:  map[string][]string{"a.yml": {"y"}},
+		},
+		{
+			name: "local override replaced by the one it shadowed",
+			config: Config{
+				Recipients: []string{"a"},
+				Overrides:  map[string][]string{"a.yml": {"me"}, "b.yml": {"me"}},
+				local: &LocalConfig{
+					Overrides: map[string][]string{"a.yml": {"me"}, "b.yml": {"me"}},
+					shadowed:  map[string][]string{"a.yml": {"x"}},
+				},
+			},
+			recipients: []string{"a"},
+			overrides:  map[string][]string{"a.yml": {"x"}},
+		},
+	} {
+		own := ownConfig(test.config)
+
+		if !reflect.DeepEqual(own.Recipients, test.recipients) {
+			t.Fatalf("%s: expected recipients %v, got %v", test.name, test.recipients, own.Recipients)
+		}
+
+		if !reflect.DeepEqual(own.Overrides, test.overrides) {
+			t.Fatalf("%s: expected overrides %v, got %v", test.name, test.overrides, own.Overrides)
+		}
+	}
+}
+
+func TestMergeConcurrentChanges(t *testing.T) {
+	base := Config{
+		Files:     []string{"a.yml", "b.yml"},
+		Overrides: map[string][]string{"a.yml": {"x"}},
+		Metadata:  map[string]FileMetadata{"a.yml": {Consumers: []string{"api"}}},
+	}
+
+	for _, test := range []struct {
+		name   string
+		ours   func(*Config)
+		theirs func(*Config)
+		check  func(Config) bool
+	}{
+		{
+			name:   "their added file kept",
+			ours:   func(c *Config) {},
+			theirs: func(c *Config) { c.Files = append(c.Files, "c.yml") },
+			check:  func(c Config) bool { return reflect.DeepEqual(c.Files, []string{"a.yml", "b.yml", "c.yml"}) },
+		},
+		{
+			name:   "their removed file kept removed",
+			ours:   func(c *Config) {},
+			theirs: func(c *Config) { c.Files = []string{"a.yml"} },
+			check:  func(c Config) bool { return reflect.DeepEqual(c.Files, []string{"a.yml"}) },
+		},
+		{
+			name:   "both added files kept",
+			ours:   func(c *Config) { c.Files = append(c.Files, "d.yml") },
+			theirs: func(c *Config) { c.Files = append(c.Files, "c.yml") },
+			check: func(c Config) bool {
+				return reflect.DeepEqual(c.Files, []string{"a.yml", "b.yml", "d.yml", "c.yml"})
+			},
+		},
+		{
+			name:   "their override change applied",
+			ours:   func(c *Config) {},
+			theirs: func(c *Config) { c.Overrides["a.yml"] = []string{"y"} },
+			check:  func(c Config) bool { return reflect.DeepEqual(c.Overrides["a.yml"], []string{"y"}) },
+		},
+		{
+			name:   "their override removal applied",
+			ours:   func(c *Config) {},
+			theirs: func(c *Config) { delete(c.Overrides, "a.yml") },
+			check:  func(c Config) bool { _, ok := c.Overrides["a.yml"]; return !ok },
+		},
+		{
+			name:   "our override change wins",
+			ours:   func(c *Config) { c.Overrides["a.yml"] = []string{"z"} },
+			theirs: func(c *Config) { c.Overrides["a.yml"] = []string{"y"} },
+			check:  func(c Config) bool { return reflect.DeepEqual(c.Overrides["a.yml"], []string{"z"}) },
+		},
+		{
+			name:   "their metadata change applied",
+			ours:   func(c *Config) {},
+			theirs: func(c *Config) { c.Metadata["b.yml"] = FileMetadata{Archived: true} },
+			check:  func(c Config) bool { return c.Metadata["b.yml"].Archived },
+		},
+		{
+			name:   "our metadata change wins",
+			ours:   func(c *Config) { c.Metadata["a.yml"] = FileMetadata{CI: true} },
+			theirs: func(c *Config) { c.Metadata["a.yml"] = FileMetadata{Archived: true} },
+			check:  func(c Config) bool { return c.Metadata["a.yml"].CI && !c.Metadata["a.yml"].Archived },
+		},
+	} {
+		ours, theirs := copyTestConfig(base), copyTestConfig(base)
+		ours.base = snapshotConfig(base)
+		test.ours(&ours)
+		test.theirs(&theirs)
+
+		mergeConcurrentChanges(&ours, theirs)
+		if !test.check(ours) {
+			t.Fatalf("%s: unexpected merge, got files %v, overrides %v and metadata %v", test.name, ours.Files, ours.Overrides, ours.Metadata)
+		}
+	}
+}
+
+// copyTestConfig: copy the files, overrides and metadata of a config, so
+// that tests can change them independently
+func copyTestConfig(config Config) Config {
+	snapshot := snapshotConfig(config)
+	config.Files = snapshot.Files
+	config.Overrides = snapshot.Overrides
+	config.Metadata = snapshot.Metadata
+	return config
+}
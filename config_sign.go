@@ -0,0 +1,112 @@
+package safe
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// ConfigSignaturePath: return the path of the detached signature of the
+// config
+func ConfigSignaturePath(config Config) string {
+	return signaturePath(config.filepath)
+}
+
+// signaturePath: return the path of the detached signature of a config file
+func signaturePath(configFilepath string) string {
+	return configFilepath + ".sig"
+}
+
+// SignConfig: write detached signatures of the config and its includes by
+// the signer, or the user's default key when empty, so that users who trust
+// the signer can verify they weren't changed by anyone else. The safe.yml
+// files above the config are signed on their own.
+func SignConfig(config Config, signer string, commit bool) error {
+	// the config is written out first, so that what's signed is the config
+	// as safe writes it. Encrypting doesn't change it, as encryptions are
+	// recorded in .safe/encryptions.yml.
+	if err := WriteConfig(&config); err != nil {
+		return err
+	}
+
+	configFilepaths := append([]string{config.filepath}, includePaths(config)...)
+	signedFilepaths := make([]string, 0, 2*len(configFilepaths))
+	for _, configFilepath := range configFilepaths {
+		args := []string{"--batch", "--yes", "--armor", "--detach-sign", "--output", signaturePath(configFilepath)}
+		if signer != "" {
+			args = append(args, "--local-user", signer)
+		}
+		args = append(args, configFilepath)
+
+		cmd := gpgCommand(config, args...)
+		cmd.Stderr = os.Stderr
+		if err := runCommand(cmd); err != nil {
+			return err
+		}
+
+		signedFilepaths = append(signedFilepaths, configFilepath, signaturePath(configFilepath))
+	}
+
+	if !commit {
+		return nil
+	}
+
+	return Commit("sign", "config", signedFilepaths, config)
+}
+
+// VerifyConfig: verify that every file the config was merged from, ie: its
+// safe.yml, its includes and the safe.yml files above it, is signed by one
+// of the user's trusted signers. Configs are only verified when the user
+// has trusted signers, and recipients overridden in safe.local.yml can't be
+// verified at all.
+func VerifyConfig(config Config) error {
+	if len(config.user.TrustedSigners) == 0 {
+		return nil
+	}
+
+	if config.local != nil && len(config.local.Overrides) > 0 {
+		return errors.New(LocalConfigName + " overrides recipients, which can't be verified against your trusted signers")
+	}
+
+	sources := config.sources
+	if len(sources) == 0 {
+		sources = []string{config.filepath}
+	}
+
+	for _, configFilepath := range sources {
+		if err := verifyConfigFile(configFilepath, config); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// verifyConfigFile: verify that a config file is signed by one of the
+// user's trusted signers
+func verifyConfigFile(configFilepath string, config Config) error {
+	if _, err := os.Stat(signaturePath(configFilepath)); os.IsNotExist(err) {
+		return errors.New(configFilepath + " is not signed, run `safe config sign` as a trusted signer")
+	}
+
+	cmd := gpgCommand(config, "--batch", "--status-fd", "1", "--verify", signaturePath(configFilepath), configFilepath)
+
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := runCommand(cmd); err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			return err
+		}
+		return fmt.Errorf("the signature of %s is invalid, it may have been changed since it was signed", configFilepath)
+	}
+
+	for _, signer := range config.user.TrustedSigners {
+		if signedBy(stdout.String(), signer) {
+			return nil
+		}
+	}
+
+	return errors.New(configFilepath + " is not signed by a trusted signer")
+}
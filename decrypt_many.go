@@ -0,0 +1,101 @@
+package safe
+
+import (
+	"context"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DecryptOptions: options for decrypting many files at once
+type DecryptOptions struct {
+	// Concurrency bounds how many files are decrypted at once, defaulting
+	// to the number of CPUs
+	Concurrency int
+
+	// Done, when set, is called with each file as it finishes decrypting,
+	// eg: to report progress. It may be called concurrently.
+	Done func(filepath string)
+}
+
+// DecryptResult: the outcome of decrypting a single file
+type DecryptResult struct {
+	Filepath string
+	Byts     []byte
+	Err      error
+}
+
+// DecryptErrors: the errors from a DecryptMany call, keyed by filepath
+type DecryptErrors map[string]error
+
+func (e DecryptErrors) Error() string {
+	filepaths := make([]string, 0, len(e))
+	for filepath := range e {
+		filepaths = append(filepaths, filepath)
+	}
+	sort.Strings(filepaths)
+
+	msgs := make([]string, 0, len(filepaths))
+	for _, filepath := range filepaths {
+		msgs = append(msgs, filepath+": "+e[filepath].Error())
+	}
+
+	return "failed to decrypt: " + strings.Join(msgs, ", ")
+}
+
+// DecryptMany: decrypt each of the filepaths with bounded concurrency,
+// returning a result per file in the same order. When any file fails, the
+// returned error is a DecryptErrors. Files not yet started when the context
+// is cancelled report the context's error.
+func DecryptMany(ctx context.Context, filepaths []string, config Config, opts DecryptOptions) ([]DecryptResult, error) {
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU()
+	}
+
+	results := make([]DecryptResult, len(filepaths))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for idx, filepath := range filepaths {
+		results[idx].Filepath = filepath
+
+		select {
+		case <-ctx.Done():
+			results[idx].Err = ctx.Err()
+			continue
+		case sem <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func(result *DecryptResult) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := ctx.Err(); err != nil {
+				result.Err = err
+				return
+			}
+
+			result.Byts, result.Err = Decrypt(result.Filepath, config)
+			if opts.Done != nil {
+				opts.Done(result.Filepath)
+			}
+		}(&results[idx])
+	}
+	wg.Wait()
+
+	errs := make(DecryptErrors)
+	for _, result := range results {
+		if result.Err != nil {
+			errs[result.Filepath] = result.Err
+		}
+	}
+
+	if len(errs) > 0 {
+		return results, errs
+	}
+
+	return results, nil
+}
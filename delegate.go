@@ -0,0 +1,148 @@
+package safe
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Delegation: temporary access for a recipient to the files matching
+// Files, until it expires and is revoked by ExpireDelegations
+type Delegation struct {
+	Recipient string `yaml:"recipient"`
+
+	// Files are globs, where a ** segment matches any number of
+	// directories
+	Files []string `yaml:"files"`
+
+	Until time.Time `yaml:"until"`
+}
+
+// Active: return whether the delegation hasn't yet expired
+func (d Delegation) Active() bool {
+	return time.Now().Before(d.Until)
+}
+
+// Matches: return whether the delegation covers the file, relative to the
+// config
+func (d Delegation) Matches(relFilepath string) bool {
+	for _, pattern := range d.Files {
+		if matchPath(pattern, relFilepath) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// delegatedRecipients: add the recipients of active delegations covering
+// the file to its recipients
+func delegatedRecipients(relFilepath string, recipients []string, config Config) []string {
+	delegated := make([]string, 0)
+	for _, delegation := range config.Delegations {
+		if delegation.Active() && delegation.Matches(relFilepath) {
+			delegated = append(delegated, delegation.Recipient)
+		}
+	}
+
+	if len(delegated) == 0 {
+		return recipients
+	}
+
+	return mergeRecipients(recipients, delegated)
+}
+
+// Delegate: grant a recipient access to the files matching the patterns
+// until the given time, reencrypting those files so they can read them
+func Delegate(recipient string, patterns []string, until time.Time, config Config, opts Options) (Result, error) {
+	if len(patterns) == 0 {
+		return Result{}, errors.New("a delegation requires at least one file pattern")
+	}
+
+	if !until.After(time.Now()) {
+		return Result{}, errors.New("a delegation must end in the future, not " + until.Format("2006-01-02"))
+	}
+
+	delegation := Delegation{Recipient: recipient, Files: patterns, Until: until}
+
+	delegations := append(append([]Delegation{}, config.Delegations...), delegation)
+	return updateDelegations("delegate", recipient+" until "+until.Format("2006-01-02"), []Delegation{delegation}, delegations, config, opts)
+}
+
+// ExpireDelegations: remove the delegations that have expired, reencrypting
+// the files they covered so their recipients can no longer read them. This
+// is meant to be run on a schedule.
+func ExpireDelegations(config Config, opts Options) (Result, error) {
+	expired := make([]Delegation, 0)
+	delegations := make([]Delegation, 0, len(config.Delegations))
+	for _, delegation := range config.Delegations {
+		if delegation.Active() {
+			delegations = append(delegations, delegation)
+		} else {
+			expired = append(expired, delegation)
+		}
+	}
+
+	if len(expired) == 0 {
+		return Result{Written: make([]string, 0), Removed: make([]string, 0)}, nil
+	}
+
+	recipients := make([]string, 0, len(expired))
+	for _, delegation := range expired {
+		recipients = append(recipients, delegation.Recipient)
+	}
+
+	return updateDelegations("expire-delegation", strings.Join(uniqueStrings(recipients), ", "), expired, delegations, config, opts)
+}
+
+// updateDelegations: replace the config's delegations, reencrypting the
+// files covered by the changed delegations in a single commit
+func updateDelegations(action, target string, changed, delegations []Delegation, config Config, opts Options) (Result, error) {
+	filepaths, err := ProtectedFiles(config)
+	if err != nil {
+		return Result{}, err
+	}
+
+	affected := make([]string, 0)
+	for _, filepath := range filepaths {
+		if config.Metadata[filepath].Archived {
+			continue
+		}
+
+		for _, delegation := range changed {
+			if delegation.Matches(filepath) {
+				affected = append(affected, filepath)
+				break
+			}
+		}
+	}
+
+	result := Result{Written: configPaths(affected, config), Removed: make([]string, 0), ConfigChanged: true, Committed: opts.Commit}
+	if opts.DryRun {
+		return result, nil
+	}
+
+	// decrypt everything up front, so a failure leaves the config untouched
+	results, err := DecryptMany(context.Background(), result.Written, config, DecryptOptions{})
+	if err != nil {
+		return Result{}, err
+	}
+
+	config.Delegations = delegations
+	if err := WriteConfig(&config); err != nil {
+		return Result{}, err
+	}
+
+	for _, decrypted := range results {
+		if err := Encrypt(decrypted.Filepath, decrypted.Byts, config, false, action); err != nil {
+			return Result{}, err
+		}
+	}
+
+	if !opts.Commit {
+		return result, nil
+	}
+
+	return result, Commit(action, target, append(configFilepaths(config), result.Written...), config)
+}
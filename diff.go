@@ -0,0 +1,153 @@
+package safe
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Diff: diff the committed content of a protected file against a plaintext
+// working copy, to review what a protect or edit would change. The working
+// copy is read from working when given, eg: stdin, or from the plaintext
+// file next to the protected one. Returns an empty diff when nothing
+// changed.
+func Diff(targetFilepath string, working io.Reader, config Config) (string, error) {
+	protected, err := IsProtected(targetFilepath, config)
+	if err != nil {
+		return "", err
+	}
+	if !protected {
+		return "", errors.New(targetFilepath + " is not protected")
+	}
+
+	relFilepath, err := relativePath(targetFilepath, config)
+	if err != nil {
+		return "", err
+	}
+
+	var workingByts []byte
+	if working != nil {
+		workingByts, err = ioutil.ReadAll(working)
+	} else {
+		plaintextFilepath := TrimSuffix(targetFilepath, config)
+		workingByts, err = ioutil.ReadFile(plaintextFilepath)
+		if os.IsNotExist(err) {
+			return "", errors.New("no working copy found at " + plaintextFilepath + ", pipe one to stdin instead")
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if err := authorizeDecrypt("diff", targetFilepath, config); err != nil {
+		return "", err
+	}
+
+	committedByts, err := DecryptRevision("HEAD", relFilepath, config)
+	if err != nil {
+		return "", err
+	}
+
+	return diffPlaintext(filepath.Base(TrimSuffix(relFilepath, config)), "HEAD", committedByts, "working", workingByts, config)
+}
+
+// DiffRevisions: diff the content of a protected file between two git
+// revisions, given as A..B, eg: to review the secrets changed by a pull
+// request. B defaults to HEAD, and a file missing at a revision is diffed
+// as empty.
+func DiffRevisions(targetFilepath, revRange string, config Config) (string, error) {
+	revs := strings.SplitN(revRange, "..", 2)
+	if len(revs) != 2 || revs[0] == "" || strings.HasPrefix(revs[1], ".") {
+		return "", errors.New("invalid revision range " + revRange + ", expected A..B")
+	}
+	if revs[1] == "" {
+		revs[1] = "HEAD"
+	}
+
+	relFilepath, err := relativePath(targetFilepath, config)
+	if err != nil {
+		return "", err
+	}
+
+	if err := authorizeDecrypt("diff", targetFilepath, config); err != nil {
+		return "", err
+	}
+
+	contents := make([][]byte, len(revs))
+	for idx, rev := range revs {
+		// a file added or removed within the range only exists at one end
+		if err := runCommand(command("git", "-C", config.baseDir, "cat-file", "-e", rev+":./"+relFilepath)); err != nil {
+			if _, err := gitOutput("-C", config.baseDir, "rev-parse", "--verify", "--quiet", rev+"^{commit}"); err != nil {
+				return "", errors.New("unknown revision " + rev)
+			}
+			continue
+		}
+
+		if contents[idx], err = DecryptRevision(rev, relFilepath, config); err != nil {
+			return "", err
+		}
+	}
+
+	if contents[0] == nil && contents[1] == nil {
+		return "", errors.New(targetFilepath + " doesn't exist at " + revs[0] + " or " + revs[1])
+	}
+
+	return diffPlaintext(filepath.Base(TrimSuffix(relFilepath, config)), revs[0], contents[0], revs[1], contents[1], config)
+}
+
+// diffPlaintext: return a unified diff of two versions of a file, labelled
+// by where each came from. The plaintext is only written to safe's temp
+// directory, and removed afterwards.
+func diffPlaintext(name, beforeLabel string, before []byte, afterLabel string, after []byte, config Config) (string, error) {
+	if bytes.Equal(before, after) {
+		return "", nil
+	}
+
+	dir, err := ioutil.TempDir(tempDirFor(config), "safe--diff-")
+	if err != nil {
+		return "", err
+	}
+	defer removePlaintextDir(dir, config)
+
+	beforeFilepath := filepath.Join(beforeLabel, name)
+	afterFilepath := filepath.Join(afterLabel, name)
+	for path, byts := range map[string][]byte{beforeFilepath: before, afterFilepath: after} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(path)), 0700); err != nil {
+			return "", err
+		}
+
+		if err := ioutil.WriteFile(filepath.Join(dir, path), byts, 0600); err != nil {
+			return "", err
+		}
+	}
+
+	cmd := command("git", "diff", "--no-index", "--no-color", "--src-prefix=", "--dst-prefix=", beforeFilepath, afterFilepath)
+	cmd.Dir = dir
+
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+
+	// git diff exits with 1 when the files differ
+	if err := runCommand(cmd); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+			return "", err
+		}
+	}
+
+	// the index line holds the git hashes of the plaintext, which could be
+	// used to guess short secrets
+	lines := strings.SplitAfter(stdout.String(), "\n")
+	output := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "index ") {
+			output = append(output, line)
+		}
+	}
+
+	return strings.Join(output, ""), nil
+}
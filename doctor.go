@@ -0,0 +1,334 @@
+package safe
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// DoctorCheck: the outcome of one of doctor's checks
+type DoctorCheck struct {
+	Name   string
+	Detail string
+
+	// Err is why the check failed, if it did, and Remedy how to fix it.
+	// Checks that depend on a failed one are skipped, and have no error.
+	Err     error
+	Remedy  string
+	Skipped bool
+}
+
+// String: format the check as a line of doctor's report, followed by the
+// remedy when it failed
+func (c DoctorCheck) String() string {
+	switch {
+	case c.Skipped:
+		return fmt.Sprintf("skip %s", c.Name)
+	case c.Err != nil && c.Remedy != "":
+		return fmt.Sprintf("FAIL %s: %s\n     %s", c.Name, c.Err, c.Remedy)
+	case c.Err != nil:
+		return fmt.Sprintf("FAIL %s: %s", c.Name, c.Err)
+	case c.Detail != "":
+		return fmt.Sprintf("ok   %s: %s", c.Name, c.Detail)
+	}
+
+	return fmt.Sprintf("ok   %s", c.Name)
+}
+
+// DoctorReport: the checks run by Doctor
+type DoctorReport struct {
+	Checks []DoctorCheck
+}
+
+// Failed: return whether any check failed
+func (r DoctorReport) Failed() bool {
+	for _, check := range r.Checks {
+		if check.Err != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Doctor: diagnose the local setup, checking that gpg and its agent and
+// pinentry work, the user has a secret key that's a recipient, the config
+// loads, every recipient's key is in the keyring, the repository has no
+// uncommitted changes and the temporary directory is safe to decrypt into.
+// Unlike SelfTest, the user's own keyring and config are inspected, and
+// nothing is changed.
+func Doctor() DoctorReport {
+	report := DoctorReport{Checks: make([]DoctorCheck, 0)}
+
+	// gpg may be configured by safe.local.yml, so the config is loaded first
+	config, configErr := LoadConfig()
+
+	gpgCheck := doctorGPG(config)
+	report.Checks = append(report.Checks, gpgCheck)
+	if gpgCheck.Err != nil {
+		for _, name := range []string{"gpg-agent", "pinentry", "secret key"} {
+			report.Checks = append(report.Checks, DoctorCheck{Name: name, Skipped: true})
+		}
+	} else {
+		report.Checks = append(report.Checks, doctorAgent(), doctorPinentry())
+	}
+
+	configCheck := DoctorCheck{Name: "config", Err: configErr}
+	if configErr != nil {
+		configCheck.Remedy = "fix the config, or create one with `safe init`"
+	} else if issues, err := LintConfig(config); err != nil {
+		configCheck.Err = err
+	} else if len(issues) > 0 {
+		configCheck.Err = fmt.Errorf("%d issues in %s", len(issues), config.filepath)
+		configCheck.Remedy = "run `safe lint` for the details"
+	} else {
+		configCheck.Detail = config.filepath
+	}
+
+	if gpgCheck.Err == nil {
+		report.Checks = append(report.Checks, doctorSecretKey(config, configErr == nil))
+	}
+	report.Checks = append(report.Checks, configCheck)
+
+	if configErr != nil {
+		for _, name := range []string{"recipient keys", "vcs", "temp dir"} {
+			report.Checks = append(report.Checks, DoctorCheck{Name: name, Skipped: true})
+		}
+		return report
+	}
+
+	if gpgCheck.Err != nil {
+		report.Checks = append(report.Checks, DoctorCheck{Name: "recipient keys", Skipped: true})
+	} else {
+		report.Checks = append(report.Checks, doctorRecipientKeys(config))
+	}
+
+	report.Checks = append(report.Checks, doctorVCS(config), doctorTempDir(config))
+	return report
+}
+
+// doctorGPG: check that gpg runs, reporting its version
+func doctorGPG(config Config) DoctorCheck {
+	check := DoctorCheck{Name: "gpg"}
+
+	out, err := commandOutput(gpgCommand(config, "--version"))
+	if err != nil {
+		check.Err = fmt.Errorf("unable to run %s: %s", gpgBinaryFor(config), err)
+		check.Remedy = "install GnuPG, or set gpg in safe.local.yml to its path"
+		return check
+	}
+
+	check.Detail = strings.SplitN(string(out), "\n", 2)[0]
+	return check
+}
+
+// doctorAgent: check that the gpg agent is running or can be started
+func doctorAgent() DoctorCheck {
+	check := DoctorCheck{Name: "gpg-agent"}
+
+	out, err := commandOutput(command("gpg-connect-agent", "GETINFO version", "/bye"))
+	if err != nil || !strings.Contains(string(out), "OK") {
+		check.Err = errors.New("unable to connect to the gpg agent")
+		check.Remedy = "start it with `gpgconf --launch gpg-agent`"
+		return check
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, "D ") {
+			check.Detail = "version " + strings.TrimPrefix(line, "D ")
+		}
+	}
+
+	return check
+}
+
+// doctorPinentry: check that the pinentry program gpg prompts for
+// passphrases with is installed
+func doctorPinentry() DoctorCheck {
+	check := DoctorCheck{Name: "pinentry"}
+
+	out, err := commandOutput(command("gpgconf", "--list-components"))
+	if err != nil {
+		check.Err = fmt.Errorf("unable to list gpg components: %s", err)
+		check.Remedy = "check that gpgconf is installed alongside gpg"
+		return check
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 3 || fields[0] != "pinentry" {
+			continue
+		}
+
+		// gpgconf percent escapes colons in paths
+		path, err := url.PathUnescape(fields[2])
+		if err != nil {
+			path = fields[2]
+		}
+
+		if _, err := os.Stat(path); err != nil {
+			check.Err = fmt.Errorf("%s is missing", path)
+			check.Remedy = "install a pinentry, eg: pinentry-curses, or set pinentry-program in gpg-agent.conf"
+			return check
+		}
+
+		check.Detail = path
+		return check
+	}
+
+	check.Err = errors.New("gpg has no pinentry configured")
+	check.Remedy = "install a pinentry, eg: pinentry-curses"
+	return check
+}
+
+// doctorSecretKey: check that the user has a secret key and, when the config
+// loaded, that it's one of the recipients
+func doctorSecretKey(config Config, loaded bool) DoctorCheck {
+	check := DoctorCheck{Name: "secret key"}
+
+	secretKeys, err := secretKeyIDs(config)
+	if err != nil {
+		check.Err = err
+		return check
+	}
+
+	if len(secretKeys) == 0 {
+		check.Err = errors.New("no secret keys in the keyring")
+		check.Remedy = "import your key with `gpg --import`, or create one with `gpg --quick-gen-key <email>`"
+		return check
+	}
+
+	if !loaded {
+		return check
+	}
+
+	for _, recipient := range uniqueStrings(allRecipients(config)) {
+		if !isKeyringRecipient(recipient) {
+			continue
+		}
+
+		keyIDs, err := recipientKeyIDs(recipient, config)
+		if err != nil {
+			check.Err = err
+			return check
+		}
+
+		for _, keyID := range keyIDs {
+			if _, ok := secretKeys[keyID]; ok {
+				check.Detail = "recipient " + recipient
+				return check
+			}
+		}
+	}
+
+	check.Err = errors.New("none of your secret keys is a recipient, so you can't decrypt any files")
+	check.Remedy = "ask a recipient to add you to " + config.filepath + " and reencrypt"
+	return check
+}
+
+// doctorRecipientKeys: check that every recipient's public key is in the
+// keyring
+func doctorRecipientKeys(config Config) DoctorCheck {
+	check := DoctorCheck{Name: "recipient keys"}
+
+	recipients := uniqueStrings(allRecipients(config))
+	if err := CheckMissingKeys(recipients, config); err != nil {
+		// missing keys are reported with how to import each of them
+		check.Err = err
+		return check
+	}
+
+	check.Detail = fmt.Sprintf("%d recipients", len(recipients))
+	return check
+}
+
+// allRecipients: return the recipients of the config, its overrides and its
+// profiles
+func allRecipients(config Config) []string {
+	recipients := append([]string{}, config.Recipients...)
+	for _, overrides := range config.Overrides {
+		recipients = append(recipients, overrides...)
+	}
+	for _, profile := range config.Profiles {
+		recipients = append(recipients, profile.Recipients...)
+		for _, overrides := range profile.Overrides {
+			recipients = append(recipients, overrides...)
+		}
+	}
+
+	return recipients
+}
+
+// doctorVCS: check that the repository has no uncommitted changes, which
+// safe's commits could otherwise sweep up
+func doctorVCS(config Config) DoctorCheck {
+	name := config.VCS
+	if name == "" {
+		name = detectVCS(config.baseDir)
+	}
+	check := DoctorCheck{Name: "vcs", Detail: name}
+
+	var out []byte
+	var err error
+	switch name {
+	case "git":
+		out, err = commandOutput(command("git", "-C", config.baseDir, "status", "--porcelain"))
+	case "hg":
+		out, err = commandOutput(command("hg", "--cwd", config.baseDir, "status"))
+	default:
+		return check
+	}
+
+	if err != nil {
+		check.Err = fmt.Errorf("unable to get the %s status of %s: %s", name, config.baseDir, err)
+		check.Remedy = "check that " + name + " is installed and " + config.baseDir + " is in a repository"
+		return check
+	}
+
+	if changes := strings.TrimSpace(string(out)); changes != "" {
+		check.Err = fmt.Errorf("%d uncommitted changes", len(strings.Split(changes, "\n")))
+		check.Remedy = "commit or stash them, so that safe's commits only include its own changes"
+	}
+
+	return check
+}
+
+// doctorTempDir: check that files can be decrypted into the temporary
+// directory without other users being able to replace them
+func doctorTempDir(config Config) DoctorCheck {
+	dir := tempDirFor(config)
+	check := DoctorCheck{Name: "temp dir", Detail: dir}
+	remedy := "set temp_dir in safe.local.yml to a directory only you can write to"
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		check.Err, check.Remedy = err, remedy
+		return check
+	}
+
+	if !info.IsDir() {
+		check.Err, check.Remedy = errors.New(dir+" isn't a directory"), remedy
+		return check
+	}
+
+	// windows doesn't have unix permission bits
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0002 != 0 && info.Mode()&os.ModeSticky == 0 {
+		check.Err = errors.New(dir + " is writable by everyone without the sticky bit, so others can replace decrypted files")
+		check.Remedy = "run `chmod +t " + dir + "`, or " + remedy
+		return check
+	}
+
+	file, err := ioutil.TempFile(dir, "safe--doctor")
+	if err != nil {
+		check.Err, check.Remedy = err, remedy
+		return check
+	}
+	file.Close()
+	os.Remove(file.Name())
+
+	return check
+}
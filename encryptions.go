@@ -0,0 +1,108 @@
+package safe
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// EncryptionsName is the file, in the .safe directory next to safe.yml, that
+// records each protected file's last encryption. It's kept apart from
+// safe.yml so that encrypting a file doesn't change the config.
+const EncryptionsName = "encryptions.yml"
+
+// Encryption: the record of a protected file's last encryption
+type Encryption struct {
+	// Recipients records the recipients of a one-off encryption that
+	// diverged from the file's configured recipients
+	Recipients []string `yaml:"recipients,omitempty"`
+
+	// Digest is the sha256 digest of the ciphertext as safe wrote it, used
+	// to skip reencrypting files that haven't changed since
+	Digest string `yaml:"digest,omitempty"`
+
+	// EncryptedTo, EncryptedWith and EncryptedAt record the recipients,
+	// backend and time of the encryption, so that Verify can tell when it
+	// predates a change to the file's configuration
+	EncryptedTo   []string  `yaml:"encrypted_to,omitempty"`
+	EncryptedWith string    `yaml:"encrypted_with,omitempty"`
+	EncryptedAt   time.Time `yaml:"encrypted_at,omitempty"`
+}
+
+// EncryptionsPath: return the path of the config's encryption records
+func EncryptionsPath(config Config) string {
+	return filepath.Join(config.baseDir, ".safe", EncryptionsName)
+}
+
+// configFilepaths: return the files safe writes the config to, which are
+// committed along with any change to it
+func configFilepaths(config Config) []string {
+	return []string{config.filepath, EncryptionsPath(config)}
+}
+
+// loadEncryptions: read the encryption records next to the config with
+// read, which are empty when nothing has been encrypted yet
+func loadEncryptions(config Config, read fileReader) (map[string]Encryption, error) {
+	byts, err := read(EncryptionsPath(config))
+	if os.IsNotExist(err) {
+		return make(map[string]Encryption), nil
+	}
+	if err != nil {
+		return map[string]Encryption(nil), err
+	}
+
+	return decodeEncryptions(byts)
+}
+
+// decodeEncryptions: decode encryption records, eg: as of a past revision
+func decodeEncryptions(byts []byte) (map[string]Encryption, error) {
+	encryptions := make(map[string]Encryption)
+	if err := yaml.Unmarshal(byts, &encryptions); err != nil {
+		return map[string]Encryption(nil), err
+	}
+
+	if encryptions == nil {
+		encryptions = make(map[string]Encryption)
+	}
+	return encryptions, nil
+}
+
+// writeEncryptions: write the config's encryption records, removing the
+// file once there are none
+func writeEncryptions(config Config) error {
+	encryptionsFilepath := EncryptionsPath(config)
+	if len(config.encryptions) == 0 {
+		if err := os.Remove(encryptionsFilepath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	byts, err := yaml.Marshal(config.encryptions)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(encryptionsFilepath), 0755); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(encryptionsFilepath, byts, 0644)
+}
+
+// setEncryption: record the last encryption of a file, dropping the record
+// entirely when it's empty
+func setEncryption(relFilepath string, encryption Encryption, config *Config) {
+	if reflect.DeepEqual(encryption, Encryption{}) {
+		delete(config.encryptions, relFilepath)
+		return
+	}
+
+	if config.encryptions == nil {
+		config.encryptions = make(map[string]Encryption)
+	}
+	config.encryptions[relFilepath] = encryption
+}
@@ -0,0 +1,144 @@
+package safe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// BuildEnv: decrypt, parse, merge and transform the values of protected
+// .yml files into the environment Exec runs commands with, as KEY=value
+// pairs sorted by key, so that tools embedding safe can run their own
+// processes with exactly the same environment. The inherited environment
+// comes first, overridden by each source in order and finally by the
+// developer's own values from safe.local.yml.
+func BuildEnv(ctx context.Context, sources []string, config Config, opts ExecOptions) ([]string, error) {
+	if len(sources) == 0 {
+		return []string(nil), errors.New("no files to build the environment from")
+	}
+
+	for _, targetPath := range sources {
+		if err := checkExecSource(targetPath, config, opts); err != nil {
+			return []string(nil), err
+		}
+	}
+
+	transforms := execTransforms(config, opts)
+	for key := range transforms {
+		if err := validateTransform(transforms[key]); err != nil {
+			return []string(nil), err
+		}
+	}
+
+	results, err := DecryptMany(ctx, sources, config, DecryptOptions{})
+	if err != nil {
+		return []string(nil), err
+	}
+
+	env := make(map[string]string)
+	snapshot := make(map[string]EnvSnapshotEntry)
+	setValue := func(key, value, source string) {
+		entry := EnvSnapshotEntry{Key: key, Length: len(value), Source: source}
+		if previous, ok := snapshot[key]; ok {
+			entry.Overrode = previous.Source
+		}
+
+		env[key] = value
+		snapshot[key] = entry
+	}
+
+	for _, pair := range os.Environ() {
+		if parts := strings.SplitN(pair, "=", 2); len(parts) == 2 {
+			setValue(parts[0], parts[1], "environment")
+		}
+	}
+
+	for _, result := range results {
+		values := make(map[string]interface{})
+		if err := yaml.Unmarshal(result.Byts, &values); err != nil {
+			return []string(nil), err
+		}
+
+		for rawKey, rawValue := range values {
+			key := strings.ToUpper(rawKey)
+
+			var value string
+			switch rawValue.(type) {
+			case string:
+				value = rawValue.(string)
+			case []interface{}:
+				items := make([]string, 0, len(rawValue.([]interface{})))
+				for _, item := range rawValue.([]interface{}) {
+					items = append(items, fmt.Sprintf("%v", item))
+				}
+				value = strings.Join(items, ",")
+			case int:
+				value = strconv.Itoa(rawValue.(int))
+			default:
+				value = fmt.Sprintf("%v", rawValue)
+			}
+
+			if transform, ok := transforms[key]; ok {
+				if value, err = applyTransform(key, value, transform); err != nil {
+					return []string(nil), err
+				}
+			}
+
+			setValue(key, value, result.Filepath)
+		}
+	}
+
+	// the developer's own values from safe.local.yml take precedence over
+	// the shared secrets
+	if config.local != nil {
+		for key, value := range config.local.Env {
+			setValue(strings.ToUpper(key), value, LocalConfigName)
+		}
+	}
+
+	if opts.DebugEnv != "" {
+		if err := writeEnvSnapshot(opts.DebugEnv, snapshot); err != nil {
+			return []string(nil), err
+		}
+	}
+
+	pairs := make([]string, 0, len(env))
+	for _, key := range sortedStringKeys(env) {
+		pairs = append(pairs, key+"="+env[key])
+	}
+
+	return pairs, nil
+}
+
+// checkExecSource: return an error unless the file can be used to build an
+// environment
+func checkExecSource(targetPath string, config Config, opts ExecOptions) error {
+	if _, err := IsProtected(targetPath, config); err != nil {
+		return err
+	}
+
+	archived, err := IsArchived(targetPath, config)
+	if err != nil {
+		return err
+	}
+	if archived {
+		return errors.New(targetPath + " is archived, refusing to exec")
+	}
+
+	if !strings.HasSuffix(TrimSuffix(targetPath, config), ".yml") {
+		return errors.New("Only able to exec protected .yml files")
+	}
+
+	if opts.RequireFresh {
+		if err := CheckFresh(targetPath, config); err != nil {
+			return err
+		}
+	}
+
+	return authorizeDecrypt("exec", targetPath, config)
+}
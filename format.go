@@ -0,0 +1,331 @@
+package safe
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// formatExtensions maps the extension of a protected file's plaintext name
+// to the structured format it contains
+var formatExtensions = map[string]string{
+	".yml":  "yml",
+	".yaml": "yml",
+	".json": "json",
+	".env":  "env",
+	".toml": "toml",
+}
+
+// FormatOf: return the structured format of a protected file, based on the
+// extension of its plaintext name, or an empty string if it's unstructured
+func FormatOf(targetFilepath string, config Config) string {
+	return formatExtensions[filepath.Ext(TrimSuffix(targetFilepath, config))]
+}
+
+// contentExtension: return the extension matching the format of a file's
+// content, for files whose name doesn't have one
+func contentExtension(byts []byte) string {
+	trimmed := bytes.TrimSpace(byts)
+
+	if bytes.HasPrefix(trimmed, []byte("-----BEGIN ")) {
+		return ".pem"
+	}
+
+	if (bytes.HasPrefix(trimmed, []byte("{")) || bytes.HasPrefix(trimmed, []byte("["))) && json.Valid(trimmed) {
+		return ".json"
+	}
+
+	return ""
+}
+
+// ValidateContent: check that the content of a file parses as the format
+// given by its name's extension
+func ValidateContent(name string, byts []byte) error {
+	switch filepath.Ext(trimKnownSuffix(name)) {
+	case ".yml", ".yaml":
+		var parsed interface{}
+		return yaml.Unmarshal(byts, &parsed)
+	case ".json":
+		var parsed interface{}
+		return json.Unmarshal(byts, &parsed)
+	case ".env":
+		_, err := parseEnv(byts)
+		return err
+	case ".pem", ".crt", ".key":
+		return validatePEM(byts)
+	default:
+		return nil
+	}
+}
+
+// validatePEM: check that the content is made up of one or more PEM blocks
+func validatePEM(byts []byte) error {
+	rest := bytes.TrimSpace(byts)
+	if len(rest) == 0 {
+		return errors.New("no PEM blocks found")
+	}
+
+	for len(rest) > 0 {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return errors.New("invalid PEM block")
+		}
+		rest = bytes.TrimSpace(rest)
+	}
+
+	return nil
+}
+
+// parseStructured: parse the content of a structured file into a map
+func parseStructured(byts []byte, format string) (map[string]interface{}, error) {
+	switch format {
+	case "yml", "json":
+		var data map[string]interface{}
+		if err := yaml.Unmarshal(byts, &data); err != nil {
+			return nil, err
+		}
+		return stringKeys(data).(map[string]interface{}), nil
+	case "env":
+		return parseEnv(byts)
+	default:
+		return nil, errors.New("unable to parse " + format + " files")
+	}
+}
+
+// encodeStructured: encode a map in the given format
+func encodeStructured(data map[string]interface{}, format string) ([]byte, error) {
+	switch format {
+	case "yml":
+		return yaml.Marshal(data)
+	case "json":
+		byts, err := json.MarshalIndent(data, "", "  ")
+		if err != nil {
+			return []byte(nil), err
+		}
+		return append(byts, '\n'), nil
+	case "env":
+		return encodeEnv(data), nil
+	case "toml":
+		var buf bytes.Buffer
+		encodeTOMLTable(&buf, "", data)
+		return buf.Bytes(), nil
+	default:
+		return []byte(nil), errors.New("unable to encode " + format + " files")
+	}
+}
+
+// stringKeys: convert the map[interface{}]interface{} values produced by
+// yaml into map[string]interface{}, recursively
+func stringKeys(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(value))
+		for key, nested := range value {
+			converted[fmt.Sprintf("%v", key)] = stringKeys(nested)
+		}
+		return converted
+	case map[string]interface{}:
+		converted := make(map[string]interface{}, len(value))
+		for key, nested := range value {
+			converted[key] = stringKeys(nested)
+		}
+		return converted
+	case []interface{}:
+		converted := make([]interface{}, len(value))
+		for idx, nested := range value {
+			converted[idx] = stringKeys(nested)
+		}
+		return converted
+	default:
+		return value
+	}
+}
+
+// sortedKeys: return the keys of the map in order
+func sortedKeys(data map[string]interface{}) []string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// parseEnv: parse KEY=value lines, ignoring comments and `export`
+func parseEnv(byts []byte) (map[string]interface{}, error) {
+	data := make(map[string]interface{})
+	for idx, line := range strings.Split(string(byts), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(strings.TrimPrefix(line, "export "), "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid env line %d", idx+1)
+		}
+
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+			value = value[1 : len(value)-1]
+		}
+
+		data[key] = value
+	}
+
+	return data, nil
+}
+
+// flattenEnv: flatten nested maps into upper cased KEY_NESTED names, as used
+// for environment variables
+func flattenEnv(prefix string, data map[string]interface{}, env map[string]string) {
+	for key, value := range data {
+		name := strings.ToUpper(key)
+		if prefix != "" {
+			name = prefix + "_" + name
+		}
+
+		switch value := value.(type) {
+		case map[string]interface{}:
+			flattenEnv(name, value, env)
+		case []interface{}:
+			values := make([]string, 0, len(value))
+			for _, nested := range value {
+				values = append(values, fmt.Sprintf("%v", nested))
+			}
+			env[name] = strings.Join(values, ",")
+		case nil:
+			env[name] = ""
+		default:
+			env[name] = fmt.Sprintf("%v", value)
+		}
+	}
+}
+
+// encodeEnv: encode the data as KEY=value lines
+func encodeEnv(data map[string]interface{}) []byte {
+	env := make(map[string]string)
+	flattenEnv("", stringKeys(data).(map[string]interface{}), env)
+
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		value := env[name]
+		if strings.ContainsAny(value, " \t\"'#=$\\") {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(&buf, "%s=%s\n", name, value)
+	}
+
+	return buf.Bytes()
+}
+
+// Convert: convert a structured protected file to another format, encrypting
+// it at the path for the new format and carrying its configuration over
+func Convert(targetFilepath, format string, config Config, opts Options) (Result, error) {
+	protected, err := IsProtected(targetFilepath, config)
+	if err != nil {
+		return Result{}, err
+	}
+	if !protected {
+		return Result{}, errors.New(targetFilepath + " is not protected")
+	}
+
+	srcFormat := FormatOf(targetFilepath, config)
+	if srcFormat == "" {
+		return Result{}, errors.New(targetFilepath + " is not a structured file")
+	}
+
+	if srcFormat == format {
+		return Result{}, nil
+	}
+
+	plainFilepath := TrimSuffix(targetFilepath, config)
+	newFilepath := EnsureSuffix(strings.TrimSuffix(plainFilepath, filepath.Ext(plainFilepath))+"."+format, config)
+
+	if protected, err := IsProtected(newFilepath, config); err != nil || (protected && !opts.Force) {
+		if err == nil {
+			err = errors.New(newFilepath + " already protected")
+		}
+		return Result{}, err
+	}
+
+	byts, err := Decrypt(targetFilepath, config)
+	if err != nil {
+		return Result{}, err
+	}
+
+	data, err := parseStructured(byts, srcFormat)
+	if err != nil {
+		return Result{}, err
+	}
+
+	converted, err := encodeStructured(data, format)
+	if err != nil {
+		return Result{}, err
+	}
+
+	result := Result{
+		Written:       []string{newFilepath},
+		Removed:       []string{targetFilepath},
+		ConfigChanged: true,
+		Committed:     opts.Commit,
+	}
+
+	if opts.DryRun {
+		return result, nil
+	}
+
+	relFilepath, err := relativePath(targetFilepath, config)
+	if err != nil {
+		return Result{}, err
+	}
+
+	newRelFilepath, err := relativePath(newFilepath, config)
+	if err != nil {
+		return Result{}, err
+	}
+
+	if recipients, ok := config.Overrides[relFilepath]; ok {
+		config.Overrides[newRelFilepath] = recipients
+	}
+	if backend, ok := config.Backends[relFilepath]; ok {
+		config.Backends[newRelFilepath] = backend
+	}
+	if metadata, ok := config.Metadata[relFilepath]; ok {
+		config.Metadata[newRelFilepath] = metadata
+	}
+	untrack(relFilepath, &config)
+
+	if err := Encrypt(newFilepath, converted, config, false, "convert"); err != nil {
+		return Result{}, err
+	}
+
+	if err := os.Remove(targetFilepath); err != nil {
+		return Result{}, err
+	}
+
+	if !opts.Commit {
+		return result, nil
+	}
+
+	return result, Commit("convert", newFilepath, append([]string{targetFilepath, newFilepath}, configFilepaths(config)...), config)
+}
@@ -0,0 +1,196 @@
+package safe
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// staleTempAge is how old a file left in the temp directory by safe must be
+// before gc removes it, so that files in use by a running edit are kept
+const staleTempAge = 24 * time.Hour
+
+// GCConfig: retention policies for the state safe accumulates over time.
+// Zero values keep everything.
+type GCConfig struct {
+	// AuditLogDays is how many days of entries to keep in .safe/audit.log
+	AuditLogDays int `yaml:"audit_log_days,omitempty"`
+
+	// CacheMB caps the size of safe's user cache, such as fetched remote
+	// keys and team keyrings, evicting the least recently modified files
+	CacheMB int `yaml:"cache_mb,omitempty"`
+}
+
+// GC: prune the audit log and user cache according to the config's
+// retention policies, and remove plaintext that a crashed edit or diff left
+// behind in the temp directory, so that safe's state doesn't grow
+// unboundedly over years of use
+func GC(config Config, opts Options) (Result, error) {
+	result := Result{Written: make([]string, 0), Removed: make([]string, 0)}
+
+	pruned, err := pruneAuditLog(config, opts.DryRun)
+	if err != nil {
+		return Result{}, err
+	}
+	if pruned {
+		result.Written = append(result.Written, AuditLogPath(config))
+	}
+
+	removed, err := removeStaleTempFiles(config, opts.DryRun)
+	if err != nil {
+		return Result{}, err
+	}
+	result.Removed = append(result.Removed, removed...)
+
+	if removed, err = evictCache(config, opts.DryRun); err != nil {
+		return Result{}, err
+	}
+	result.Removed = append(result.Removed, removed...)
+
+	return result, nil
+}
+
+// pruneAuditLog: drop audit log entries older than the retention period,
+// returning whether any were dropped. Lines that can't be parsed are kept.
+func pruneAuditLog(config Config, dryRun bool) (bool, error) {
+	if config.GC.AuditLogDays <= 0 {
+		return false, nil
+	}
+
+	auditLogPath := AuditLogPath(config)
+	byts, err := ioutil.ReadFile(auditLogPath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -config.GC.AuditLogDays)
+
+	var kept bytes.Buffer
+	pruned := false
+	scanner := bufio.NewScanner(bytes.NewReader(byts))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(byts)+1)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err == nil && entry.Time.Before(cutoff) {
+			pruned = true
+			continue
+		}
+
+		kept.Write(scanner.Bytes())
+		kept.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return false, err
+	}
+
+	if !pruned || dryRun {
+		return pruned, nil
+	}
+
+	return true, writeFileAtomic(auditLogPath, kept.Bytes(), 0600)
+}
+
+// removeStaleTempFiles: remove the temporary files and directories safe
+// writes plaintext to that are older than staleTempAge, returning their
+// paths. Files belonging to other users are skipped.
+func removeStaleTempFiles(config Config, dryRun bool) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(tempDirFor(config), "safe--*"))
+	if err != nil {
+		return []string(nil), err
+	}
+
+	removed := make([]string, 0)
+	for _, path := range matches {
+		info, err := os.Lstat(path)
+		if err != nil || time.Since(info.ModTime()) < staleTempAge {
+			continue
+		}
+
+		if !dryRun {
+			if info.IsDir() {
+				err = removePlaintextDir(path, config)
+			} else {
+				err = removePlaintext(path, config)
+			}
+
+			if os.IsPermission(err) {
+				continue
+			}
+			if err != nil {
+				return []string(nil), err
+			}
+		}
+
+		removed = append(removed, path)
+	}
+
+	return removed, nil
+}
+
+// evictCache: remove the least recently modified files from safe's user
+// cache until it fits within the configured size, returning their paths
+func evictCache(config Config, dryRun bool) ([]string, error) {
+	if config.GC.CacheMB <= 0 {
+		return []string(nil), nil
+	}
+
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return []string(nil), err
+	}
+
+	type cachedFile struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+
+	files := make([]cachedFile, 0)
+	var total int64
+	err = filepath.Walk(filepath.Join(cacheDir, "safe"), func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+
+		files = append(files, cachedFile{path: path, size: info.Size(), modTime: info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return []string(nil), err
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+
+	limit := int64(config.GC.CacheMB) * 1024 * 1024
+	removed := make([]string, 0)
+	for _, file := range files {
+		if total <= limit {
+			break
+		}
+
+		if !dryRun {
+			if err := os.Remove(file.path); err != nil {
+				return []string(nil), err
+			}
+		}
+
+		removed = append(removed, file.path)
+		total -= file.size
+	}
+
+	return removed, nil
+}
@@ -0,0 +1,76 @@
+package safe
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// fetchGithubKeys: fetch the gpg keys a github user can encrypt with
+func fetchGithubKeys(username string) ([][]byte, error) {
+	resp, err := http.Get("https://api.github.com/users/" + url.PathEscape(username) + "/gpg_keys")
+	if err != nil {
+		return [][]byte(nil), err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return [][]byte(nil), fmt.Errorf("unable to fetch keys for github user %s: %s", username, resp.Status)
+	}
+
+	rawKeys, err := decodeGithubKeys(resp.Body)
+	if err != nil {
+		return [][]byte(nil), err
+	}
+
+	if len(rawKeys) == 0 {
+		return [][]byte(nil), errors.New("github user " + username + " has no gpg keys that can encrypt")
+	}
+
+	return rawKeys, nil
+}
+
+// githubKey: a gpg key as listed by github's api. The flags of a key's
+// encryption subkeys are listed under its subkeys.
+type githubKey struct {
+	RawKey            string      `json:"raw_key"`
+	CanEncryptComms   bool        `json:"can_encrypt_comms"`
+	CanEncryptStorage bool        `json:"can_encrypt_storage"`
+	Subkeys           []githubKey `json:"subkeys"`
+}
+
+// canEncrypt: return whether the key or any of its subkeys can encrypt
+func (g githubKey) canEncrypt() bool {
+	if g.CanEncryptComms || g.CanEncryptStorage {
+		return true
+	}
+
+	for _, subkey := range g.Subkeys {
+		if subkey.canEncrypt() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// decodeGithubKeys: decode github's list of a user's gpg keys, returning the
+// armored keys that can encrypt
+func decodeGithubKeys(reader io.Reader) ([][]byte, error) {
+	var keys []githubKey
+	if err := json.NewDecoder(reader).Decode(&keys); err != nil {
+		return [][]byte(nil), err
+	}
+
+	rawKeys := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		if key.canEncrypt() {
+			rawKeys = append(rawKeys, []byte(key.RawKey))
+		}
+	}
+
+	return rawKeys, nil
+}
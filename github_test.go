@@ -0,0 +1,45 @@
+package safe
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeGithubKeys(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		body     string
+		expected [][]byte
+	}{
+		{
+			name:     "primary key can encrypt",
+			body:     `[{"raw_key": "a", "can_encrypt_comms": true}]`,
+			expected: [][]byte{[]byte("a")},
+		},
+		{
+			name:     "subkey can encrypt",
+			body:     `[{"raw_key": "a", "can_sign": true, "subkeys": [{"can_encrypt_storage": true}]}]`,
+			expected: [][]byte{[]byte("a")},
+		},
+		{
+			name:     "signing only",
+			body:     `[{"raw_key": "a", "can_sign": true, "subkeys": [{"can_sign": true}]}, {"raw_key": "b", "can_encrypt_comms": true}]`,
+			expected: [][]byte{[]byte("b")},
+		},
+		{
+			name:     "no keys",
+			body:     `[]`,
+			expected: [][]byte{},
+		},
+	} {
+		keys, err := decodeGithubKeys(strings.NewReader(test.body))
+		if err != nil {
+			t.Fatalf("%s: expected decoding to succeed, got %v", test.name, err)
+		}
+
+		if !reflect.DeepEqual(keys, test.expected) {
+			t.Fatalf("%s: expected %q, got %q", test.name, test.expected, keys)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package safe
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Impact: return the consumers affected by rotating a protected file
+func Impact(targetFilepath string, config Config) ([]string, error) {
+	protected, err := IsProtected(targetFilepath, config)
+	if err != nil {
+		return []string(nil), err
+	}
+
+	if !protected {
+		return []string(nil), errors.New(targetFilepath + " is not protected")
+	}
+
+	relFilepath, err := relativePath(targetFilepath, config)
+	if err != nil {
+		return []string(nil), err
+	}
+
+	return uniqueStrings(config.Metadata[relFilepath].Consumers), nil
+}
+
+// DependencyEdge: a consumer that reads a protected file
+type DependencyEdge struct {
+	Filepath string `json:"filepath"`
+	Consumer string `json:"consumer"`
+}
+
+// DependencyGraph: the protected files and the consumers that read them,
+// for planning rotations
+type DependencyGraph struct {
+	Files     []string         `json:"files"`
+	Consumers []string         `json:"consumers"`
+	Edges     []DependencyEdge `json:"edges"`
+}
+
+// Dependencies: build the graph of every active protected file and its
+// consumers
+func Dependencies(config Config) (DependencyGraph, error) {
+	filepaths, err := ProtectedFiles(config)
+	if err != nil {
+		return DependencyGraph{}, err
+	}
+
+	graph := DependencyGraph{
+		Files:     make([]string, 0, len(filepaths)),
+		Consumers: make([]string, 0),
+		Edges:     make([]DependencyEdge, 0),
+	}
+
+	for _, filepath := range filepaths {
+		metadata := config.Metadata[filepath]
+		if metadata.Archived {
+			continue
+		}
+
+		graph.Files = append(graph.Files, filepath)
+		for _, consumer := range uniqueStrings(metadata.Consumers) {
+			graph.Consumers = append(graph.Consumers, consumer)
+			graph.Edges = append(graph.Edges, DependencyEdge{Filepath: filepath, Consumer: consumer})
+		}
+	}
+	graph.Consumers = uniqueStrings(graph.Consumers)
+
+	return graph, nil
+}
+
+// DOT: render the graph in graphviz's dot format
+func (g DependencyGraph) DOT() string {
+	var buf bytes.Buffer
+	buf.WriteString("digraph safe {\n")
+	buf.WriteString("\trankdir=LR;\n")
+
+	for _, filepath := range g.Files {
+		fmt.Fprintf(&buf, "\t%s [shape=box, label=%s];\n", strconv.Quote("file:"+filepath), strconv.Quote(filepath))
+	}
+
+	for _, consumer := range g.Consumers {
+		fmt.Fprintf(&buf, "\t%s [shape=ellipse, label=%s];\n", strconv.Quote("consumer:"+consumer), strconv.Quote(consumer))
+	}
+
+	for _, edge := range g.Edges {
+		fmt.Fprintf(&buf, "\t%s -> %s;\n", strconv.Quote("file:"+edge.Filepath), strconv.Quote("consumer:"+edge.Consumer))
+	}
+
+	buf.WriteString("}\n")
+	return buf.String()
+}
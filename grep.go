@@ -0,0 +1,104 @@
+package safe
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// GrepMatch: a line of a protected file matching a grep pattern
+type GrepMatch struct {
+	Filepath string `json:"filepath"`
+	Line     int    `json:"line"`
+	Text     string `json:"text"`
+}
+
+// String: format the match as file:line:text
+func (m GrepMatch) String() string {
+	return fmt.Sprintf("%s:%d:%s", m.Filepath, m.Line, m.Text)
+}
+
+// Grep: search the decrypted content of the protected files under path, or
+// every protected file when path is empty, for a regular expression. Files
+// are decrypted in parallel, and their plaintext is only ever held in
+// memory. Archived files and bundles aren't searched. Progress is reported
+// on stderr as files are searched.
+func Grep(pattern, path string, config Config, ignoreCase bool) ([]GrepMatch, error) {
+	if ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return []GrepMatch(nil), err
+	}
+
+	relDir := ""
+	if path != "" {
+		if relDir, err = relativePath(path, config); err != nil {
+			return []GrepMatch(nil), err
+		}
+	}
+
+	filepaths, err := ProtectedFiles(config)
+	if err != nil {
+		return []GrepMatch(nil), err
+	}
+
+	targets := make([]string, 0, len(filepaths))
+	for _, relFilepath := range filepaths {
+		if relDir != "" && relDir != "." && relFilepath != relDir && !strings.HasPrefix(relFilepath, relDir+string(filepath.Separator)) {
+			continue
+		}
+
+		metadata := config.Metadata[relFilepath]
+		if metadata.Archived || metadata.Bundle {
+			continue
+		}
+
+		if err := authorizeDecrypt("grep", configPath(relFilepath, config), config); err != nil {
+			return []GrepMatch(nil), err
+		}
+
+		targets = append(targets, configPath(relFilepath, config))
+	}
+
+	progress := newProgress("grep", len(targets))
+	done := func(targetFilepath string) {
+		if relFilepath, err := relativePath(targetFilepath, config); err == nil {
+			targetFilepath = relFilepath
+		}
+		progress.advance(targetFilepath)
+	}
+
+	results, err := DecryptMany(context.Background(), targets, config, DecryptOptions{Done: done})
+	progress.finish()
+	if err != nil {
+		return []GrepMatch(nil), err
+	}
+
+	matches := make([]GrepMatch, 0)
+	for idx, result := range results {
+		relFilepath, err := relativePath(targets[idx], config)
+		if err != nil {
+			return []GrepMatch(nil), err
+		}
+
+		scanner := bufio.NewScanner(bytes.NewReader(result.Byts))
+		scanner.Buffer(make([]byte, 0, 64*1024), len(result.Byts)+1)
+		for line := 1; scanner.Scan(); line++ {
+			if re.Match(scanner.Bytes()) {
+				matches = append(matches, GrepMatch{Filepath: relFilepath, Line: line, Text: scanner.Text()})
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return []GrepMatch(nil), err
+		}
+	}
+
+	return matches, nil
+}
@@ -0,0 +1,262 @@
+package safe
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Revision: a git commit that touched a protected file
+type Revision struct {
+	Hash    string    `json:"hash"`
+	Author  string    `json:"author"`
+	Date    time.Time `json:"date"`
+	Subject string    `json:"subject"`
+
+	// Filepath is the path of the protected file as of this revision
+	Filepath string `json:"filepath"`
+}
+
+// BlameLine: a line of decrypted content, along with the revision that
+// introduced it
+type BlameLine struct {
+	Revision Revision `json:"revision"`
+	Line     string   `json:"line"`
+}
+
+// History: return the revisions touching a protected file, newest first,
+// following it across any renames recorded in its metadata
+func History(targetFilepath string, config Config) ([]Revision, error) {
+	relFilepath, err := relativePath(targetFilepath, config)
+	if err != nil {
+		return []Revision(nil), err
+	}
+
+	filepaths := append([]string{relFilepath}, config.Metadata[relFilepath].RenamedFrom...)
+
+	seen := make(map[string]bool)
+	revisions := make([]Revision, 0)
+	for _, filepath := range filepaths {
+		pathRevisions, err := gitLog(filepath, config)
+		if err != nil {
+			return []Revision(nil), err
+		}
+
+		for _, revision := range pathRevisions {
+			if seen[revision.Hash] {
+				continue
+			}
+			seen[revision.Hash] = true
+			revisions = append(revisions, revision)
+		}
+	}
+
+	sort.SliceStable(revisions, func(i, j int) bool {
+		return revisions[i].Date.After(revisions[j].Date)
+	})
+
+	return revisions, nil
+}
+
+// Blame: attribute each line of a protected file's current content to the
+// revision that introduced it, decrypting each historical version in turn
+func Blame(targetFilepath string, config Config) ([]BlameLine, error) {
+	revisions, err := History(targetFilepath, config)
+	if err != nil {
+		return []BlameLine(nil), err
+	}
+
+	blame := make([]BlameLine, 0)
+	for idx := len(revisions) - 1; idx >= 0; idx-- {
+		revision := revisions[idx]
+
+		byts, err := DecryptRevision(revision.Hash, revision.Filepath, config)
+		if err != nil {
+			// the file doesn't exist at this revision, eg: the old
+			// path in the commit that renamed it
+			continue
+		}
+
+		blame = blameLines(blame, strings.Split(string(byts), "\n"), revision)
+	}
+
+	return blame, nil
+}
+
+// DecryptRevision: decrypt a protected file, relative to the config, as it
+// was at the given git revision
+func DecryptRevision(rev, filepath string, config Config) ([]byte, error) {
+	ciphertext, err := gitOutput("-C", config.baseDir, "show", rev+":./"+filepath)
+	if err != nil {
+		return []byte(nil), err
+	}
+
+	tempFile, err := ioutil.TempFile("", "safe--revision-")
+	if err != nil {
+		return []byte(nil), err
+	}
+	defer os.Remove(tempFile.Name())
+
+	if _, err := tempFile.WriteString(ciphertext); err != nil {
+		tempFile.Close()
+		return []byte(nil), err
+	}
+	if err := tempFile.Close(); err != nil {
+		return []byte(nil), err
+	}
+
+	backend, err := BackendFor(filepath, config)
+	if err != nil {
+		return []byte(nil), err
+	}
+
+	return backend.Decrypt(tempFile.Name())
+}
+
+// gitLog: return the revisions touching a single path relative to the
+// config, following renames that git is able to detect itself
+func gitLog(filepath string, config Config) ([]Revision, error) {
+	cdup, err := gitOutput("-C", config.baseDir, "rev-parse", "--show-cdup")
+	if err != nil {
+		return []Revision(nil), err
+	}
+
+	logOutput, err := gitOutput("-C", config.baseDir, "log", "--follow", "--name-only", "--format=%x00%H%x09%an%x09%ct%x09%s", "--", filepath)
+	if err != nil {
+		return []Revision(nil), err
+	}
+
+	revisions := make([]Revision, 0)
+	for _, entry := range strings.Split(logOutput, "\x00") {
+		lines := strings.Split(strings.TrimSpace(entry), "\n")
+		fields := strings.SplitN(lines[0], "\t", 4)
+		if len(fields) != 4 {
+			continue
+		}
+
+		timestamp, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return []Revision(nil), err
+		}
+
+		revision := Revision{
+			Hash:     fields[0],
+			Author:   fields[1],
+			Date:     time.Unix(timestamp, 0),
+			Subject:  fields[3],
+			Filepath: filepath,
+		}
+
+		// --name-only reports the path at this revision relative to the
+		// repository root, which differs when git followed a rename
+		if name := strings.TrimSpace(lines[len(lines)-1]); len(lines) > 1 && name != "" {
+			revision.Filepath = path.Join(strings.TrimSpace(cdup), name)
+		}
+
+		revisions = append(revisions, revision)
+	}
+
+	return revisions, nil
+}
+
+// gitOutput: run a git command, returning its stdout
+func gitOutput(args ...string) (string, error) {
+	cmd := command("git", args...)
+
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := runCommand(cmd); err != nil {
+		return "", err
+	}
+
+	return stdout.String(), nil
+}
+
+// blameLines: carry forward the attribution of lines that are unchanged
+// between the previous blame and the new content, attributing everything else
+// to the revision
+func blameLines(prev []BlameLine, lines []string, revision Revision) []BlameLine {
+	// longest common subsequence table between the previous and new lines
+	lcs := make([][]int, len(prev)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(lines)+1)
+	}
+
+	for i := len(prev) - 1; i >= 0; i-- {
+		for j := len(lines) - 1; j >= 0; j-- {
+			if prev[i].Line == lines[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	blame := make([]BlameLine, 0, len(lines))
+	i, j := 0, 0
+	for j < len(lines) {
+		switch {
+		case i < len(prev) && prev[i].Line == lines[j]:
+			blame = append(blame, prev[i])
+			i++
+			j++
+		case i < len(prev) && lcs[i+1][j] >= lcs[i][j+1]:
+			i++
+		default:
+			blame = append(blame, BlameLine{Revision: revision, Line: lines[j]})
+			j++
+		}
+	}
+
+	return blame
+}
+
+// CheckFresh: fetch from the config's remote, and return an error unless
+// the local ciphertext matches the remote's default branch, so that stale
+// values from before a rotation aren't used
+func CheckFresh(targetFilepath string, config Config) error {
+	remote := config.Remote
+	if remote == "" {
+		remote = "origin"
+	}
+
+	relFilepath, err := relativePath(targetFilepath, config)
+	if err != nil {
+		return err
+	}
+	relFilepath = filepath.ToSlash(relFilepath)
+
+	if _, err := gitOutput("-C", config.baseDir, "fetch", "--quiet", remote); err != nil {
+		return errors.New("unable to fetch " + remote + " to check freshness of " + targetFilepath)
+	}
+
+	remoteRef := remote + "/HEAD"
+	if _, err := gitOutput("-C", config.baseDir, "rev-parse", "--verify", "--quiet", remoteRef); err != nil {
+		remoteRef = "@{upstream}"
+	}
+
+	remoteHash, err := gitOutput("-C", config.baseDir, "rev-parse", "--verify", "--quiet", remoteRef+":./"+relFilepath)
+	if err != nil {
+		return errors.New(targetFilepath + " does not exist on " + remoteRef)
+	}
+
+	localHash, err := gitOutput("-C", config.baseDir, "hash-object", relFilepath)
+	if err != nil {
+		return err
+	}
+
+	if strings.TrimSpace(localHash) != strings.TrimSpace(remoteHash) {
+		return errors.New(targetFilepath + " differs from " + remoteRef + ", pull before using it")
+	}
+
+	return nil
+}
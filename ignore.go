@@ -0,0 +1,102 @@
+package safe
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// IgnoreFileName is the file, next to safe.yml, listing further ignore
+// patterns one per line
+const IgnoreFileName = ".safeignore"
+
+// loadIgnoreFile: load the patterns in the .safeignore next to the config,
+// if any, skipping blank lines and # comments
+func loadIgnoreFile(baseDir string) ([]string, error) {
+	byts, err := ioutil.ReadFile(filepath.Join(baseDir, IgnoreFileName))
+	if os.IsNotExist(err) {
+		return []string(nil), nil
+	}
+	if err != nil {
+		return []string(nil), err
+	}
+
+	patterns := make([]string, 0)
+	for _, line := range strings.Split(string(byts), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		patterns = append(patterns, line)
+	}
+
+	return patterns, nil
+}
+
+// isIgnored: return whether a path relative to the config, or any directory
+// above it, matches an ignore pattern. Patterns without a slash match a
+// file or directory name anywhere, as in .gitignore, while the others are
+// globs relative to the config.
+func isIgnored(relFilepath string, config Config) bool {
+	patterns := append(append([]string{}, config.Ignore...), config.ignoreFile...)
+	if len(patterns) == 0 {
+		return false
+	}
+
+	segments := strings.Split(filepath.ToSlash(relFilepath), "/")
+	for idx := range segments {
+		path := strings.Join(segments[:idx+1], "/")
+
+		for _, pattern := range patterns {
+			pattern = strings.TrimSuffix(strings.TrimPrefix(pattern, "/"), "/")
+
+			if !strings.Contains(pattern, "/") {
+				if matched, err := filepath.Match(pattern, segments[idx]); err == nil && matched {
+					return true
+				}
+				continue
+			}
+
+			if matchPath(pattern, path) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// walkFiles: call fn with each regular file under dir and its path relative
+// to the config, skipping .git and .safe, ignored paths and the directories
+// of nested configs, which manage the files beneath them
+func walkFiles(dir string, config Config, fn func(path, relPath string) error) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := relativePath(path, config)
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if info.Name() == ".git" || info.Name() == ".safe" || (relPath != "." && isIgnored(relPath, config)) {
+				return filepath.SkipDir
+			}
+
+			if _, ok := findConfigFile(path); ok && relPath != "." {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !info.Mode().IsRegular() || isIgnored(relPath, config) {
+			return nil
+		}
+
+		return fn(path, relPath)
+	})
+}
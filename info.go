@@ -0,0 +1,208 @@
+package safe
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// FileInfo: what safe knows about a protected file
+type FileInfo struct {
+	Filepath string `json:"filepath"`
+
+	// Recipients are the effective recipients, after overrides, profiles,
+	// delegations and any one-off encryption, while EncryptedTo are the
+	// recipients it was last encrypted to
+	Recipients  []string `json:"recipients"`
+	EncryptedTo []string `json:"encrypted_to,omitempty"`
+
+	Backend string `json:"backend"`
+	Size    int64  `json:"size"`
+
+	// LastCommit is the newest commit made by safe touching the file, with
+	// an empty hash when there's none
+	LastCommit Revision `json:"last_commit"`
+
+	// LocalKeys are the secret keys in the local keyring the ciphertext is
+	// encrypted to, which is only known for gpg files
+	LocalKeys []string `json:"local_keys"`
+	Archived  bool     `json:"archived,omitempty"`
+	Bundle    bool     `json:"bundle,omitempty"`
+}
+
+// String: format the info for display
+func (i FileInfo) String() string {
+	lines := []string{
+		"file:        " + i.Filepath,
+		"recipients:  " + strings.Join(i.Recipients, ", "),
+		"backend:     " + i.Backend,
+		fmt.Sprintf("size:        %d bytes", i.Size),
+	}
+
+	if len(i.EncryptedTo) > 0 {
+		lines = append(lines, "encrypted:   "+strings.Join(i.EncryptedTo, ", "))
+	}
+
+	lastCommit := "none"
+	if i.LastCommit.Hash != "" {
+		lastCommit = fmt.Sprintf("%s %s (%s, %s)", i.LastCommit.Hash[:7], i.LastCommit.Subject, i.LastCommit.Author, i.LastCommit.Date.Format("2006-01-02"))
+	}
+	lines = append(lines, "last commit: "+lastCommit)
+
+	localKeys := "none"
+	if i.LocalKeys == nil {
+		localKeys = "unknown"
+	} else if len(i.LocalKeys) > 0 {
+		localKeys = strings.Join(i.LocalKeys, ", ")
+	}
+	lines = append(lines, "local keys:  "+localKeys)
+
+	if i.Archived {
+		lines = append(lines, "archived:    true")
+	}
+	if i.Bundle {
+		lines = append(lines, "bundle:      true")
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Info: describe a protected file without decrypting it
+func Info(targetFilepath string, config Config) (FileInfo, error) {
+	protected, err := IsProtected(targetFilepath, config)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	if !protected {
+		return FileInfo{}, errors.New(targetFilepath + " is not protected")
+	}
+
+	relFilepath, err := relativePath(targetFilepath, config)
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	stat, err := os.Stat(configPath(relFilepath, config))
+	if os.IsNotExist(err) {
+		return FileInfo{}, errors.New(targetFilepath + " not found")
+	}
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	metadata := config.Metadata[relFilepath]
+	info := FileInfo{
+		Filepath:    relFilepath,
+		Recipients:  RecipientsFor(relFilepath, config),
+		EncryptedTo: config.encryptions[relFilepath].EncryptedTo,
+		Backend:     backendName(relFilepath, config),
+		Size:        stat.Size(),
+		Archived:    metadata.Archived,
+		Bundle:      metadata.Bundle,
+	}
+
+	// a one-off encryption overrides the configured recipients until the
+	// file is next encrypted
+	if encryption := config.encryptions[relFilepath]; len(encryption.Recipients) > 0 {
+		info.Recipients = encryption.Recipients
+	}
+
+	revisions, err := History(targetFilepath, config)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	for _, revision := range revisions {
+		if strings.HasPrefix(revision.Subject, "safe: ") {
+			info.LastCommit = revision
+			break
+		}
+	}
+
+	backend, err := BackendFor(relFilepath, config)
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	if _, ok := backend.(gpgBackend); ok {
+		if info.LocalKeys, err = localDecryptionKeys(configPath(relFilepath, config), config); err != nil {
+			return FileInfo{}, err
+		}
+	}
+
+	return info, nil
+}
+
+// localDecryptionKeys: return the user ids of the secret keys in the local
+// keyring that a gpg ciphertext is encrypted to
+func localDecryptionKeys(filepath string, config Config) ([]string, error) {
+	keyIDs, err := ciphertextKeyIDs(filepath, config)
+	if err != nil {
+		return []string(nil), err
+	}
+
+	secretKeys, err := secretKeyIDs(config)
+	if err != nil {
+		return []string(nil), err
+	}
+
+	keys := make([]string, 0)
+	for _, keyID := range keyIDs {
+		userID, ok := secretKeys[keyID]
+		if ok && !containsString(keys, userID) {
+			keys = append(keys, userID)
+		}
+	}
+
+	return keys, nil
+}
+
+// secretKeyIDs: return the ids of the secret keys and subkeys in the local
+// keyring, mapped to the primary user id of their key
+func secretKeyIDs(config Config) (map[string]string, error) {
+	cmd := gpgCommand(config, "--batch", "--with-colons", "--list-secret-keys")
+
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := runCommand(cmd); err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return map[string]string{}, nil
+		}
+		return map[string]string(nil), err
+	}
+
+	keys := make(map[string]string)
+	keyIDs := make([]string, 0)
+	userID := ""
+	flush := func() {
+		for _, keyID := range keyIDs {
+			keys[keyID] = userID
+		}
+		keyIDs, userID = keyIDs[:0], ""
+	}
+
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 10 {
+			continue
+		}
+
+		switch fields[0] {
+		case "sec":
+			flush()
+			keyIDs = append(keyIDs, strings.ToUpper(fields[4]))
+			userID = strings.ToUpper(fields[4])
+		case "ssb":
+			keyIDs = append(keyIDs, strings.ToUpper(fields[4]))
+		case "uid":
+			if len(keyIDs) > 0 && userID == strings.ToUpper(keyIDs[0]) {
+				userID = fields[9]
+			}
+		}
+	}
+	flush()
+
+	return keys, nil
+}
@@ -0,0 +1,245 @@
+package safe
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// preCommitHook is installed by Init to stop plaintext copies of secrets
+// from being committed
+const preCommitHook = `#!/bin/sh
+# installed by safe init: refuse to commit plaintext copies of secrets
+exec safe check-plaintext
+`
+
+// InitOptions: options for creating a new safe.yml
+type InitOptions struct {
+	// Recipients are used as is when set, otherwise the user is prompted
+	// for them, defaulting to their own key
+	Recipients []string
+
+	// InstallHooks installs a git pre-commit hook that checks for
+	// plaintext secrets
+	InstallHooks bool
+
+	// VCS is the version control system to use: git, hg or none. When
+	// unset, a mercurial repository is detected, and otherwise git is used
+	VCS string
+
+	Commit bool
+}
+
+// Init: create a safe.yml in dir, initializing a repository for the version
+// control system if needed, and return the loaded config
+func Init(dir string, opts InitOptions) (Config, error) {
+	if err := os.Chdir(dir); err != nil {
+		return Config{}, err
+	}
+
+	configFilepath, err := filepath.Abs("safe.yml")
+	if err != nil {
+		return Config{}, err
+	}
+
+	if _, err := os.Stat(configFilepath); err == nil {
+		return Config{}, errors.New(configFilepath + " already exists")
+	}
+
+	config := Config{
+		filepath:   configFilepath,
+		baseDir:    filepath.Dir(configFilepath),
+		Version:    CurrentConfigVersion,
+		Recipients: opts.Recipients,
+		Overrides:  make(map[string][]string),
+		Files:      make([]string, 0),
+	}
+
+	if config.user, err = LoadUserConfig(); err != nil {
+		return Config{}, err
+	}
+
+	if len(config.Recipients) == 0 {
+		if config.Recipients, err = promptRecipients(config); err != nil {
+			return Config{}, err
+		}
+	}
+
+	if len(config.Recipients) == 0 {
+		return Config{}, errors.New("Invalid config, no recipients")
+	}
+
+	if err := CheckMissingKeys(config.Recipients, config); err != nil {
+		return Config{}, err
+	}
+
+	vcsName := opts.VCS
+	if vcsName == "" {
+		if vcsName = detectVCS(config.baseDir); vcsName == "none" {
+			vcsName = DefaultVCS
+		}
+	} else {
+		config.VCS = vcsName
+	}
+
+	if _, ok := vcses[vcsName]; !ok {
+		return Config{}, errors.New("unknown vcs " + vcsName)
+	}
+
+	if opts.InstallHooks && vcsName != "git" {
+		return Config{}, errors.New("hooks can only be installed in git repositories")
+	}
+
+	if err := initRepository(vcsName); err != nil {
+		return Config{}, err
+	}
+	config.vcs = vcses[vcsName]
+
+	if err := WriteConfig(&config); err != nil {
+		return Config{}, err
+	}
+
+	gitignoreFilepath, err := ignoreLocalFiles(config.baseDir)
+	if err != nil {
+		return Config{}, err
+	}
+
+	if opts.Commit {
+		if err := Commit("init", "safe.yml", []string{configFilepath, gitignoreFilepath}, config); err != nil {
+			return Config{}, err
+		}
+	}
+
+	if opts.InstallHooks {
+		if err := installPreCommitHook(); err != nil {
+			return Config{}, err
+		}
+	}
+
+	return LoadConfigFrom(configFilepath)
+}
+
+// promptRecipients: ask the user for the recipients to encrypt to,
+// defaulting to those in their user config, or else their own key
+func promptRecipients(config Config) ([]string, error) {
+	defaults := config.user.Recipients
+	if len(defaults) == 0 {
+		ownKeys, err := ownKeyEmails(config)
+		if err != nil {
+			return []string(nil), err
+		}
+
+		if len(ownKeys) > 0 {
+			defaults = ownKeys[:1]
+		}
+	}
+
+	question := "recipients, comma separated:"
+	if len(defaults) > 0 {
+		question = "recipients, comma separated [" + strings.Join(defaults, ", ") + "]:"
+	}
+
+	answer, err := Prompt(question)
+	if err != nil {
+		return []string(nil), err
+	}
+
+	if answer == "" && len(defaults) > 0 {
+		return defaults, nil
+	}
+
+	recipients := make([]string, 0)
+	for _, recipient := range strings.Split(answer, ",") {
+		if recipient = strings.TrimSpace(recipient); recipient != "" {
+			recipients = append(recipients, recipient)
+		}
+	}
+
+	return recipients, nil
+}
+
+// initRepository: initialize a repository in the current directory for git
+// or mercurial, unless it's already in one
+func initRepository(vcsName string) error {
+	switch vcsName {
+	case "git":
+		if err := runCommand(command("git", "rev-parse", "--git-dir")); err != nil {
+			return runCommand(command("git", "init", "--quiet"))
+		}
+	case "hg":
+		if err := runCommand(command("hg", "root")); err != nil {
+			return runCommand(command("hg", "init"))
+		}
+	}
+
+	return nil
+}
+
+// ownKeyEmails: return the email addresses of the user's secret keys,
+// preferring their configured identity
+func ownKeyEmails(config Config) ([]string, error) {
+	cmd := gpgCommand(config, "--batch", "--with-colons", "--list-secret-keys")
+
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := runCommand(cmd); err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return []string(nil), nil
+		}
+		return []string(nil), err
+	}
+
+	emails := make([]string, 0)
+	if config.user.Identity != "" {
+		emails = append(emails, config.user.Identity)
+	}
+
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 10 || fields[0] != "uid" {
+			continue
+		}
+
+		// user ids are usually formatted as: Name (comment) <email>
+		uid := fields[9]
+		if start, end := strings.LastIndex(uid, "<"), strings.LastIndex(uid, ">"); start >= 0 && end > start {
+			uid = uid[start+1 : end]
+		}
+
+		if !containsString(emails, uid) {
+			emails = append(emails, uid)
+		}
+	}
+
+	return emails, nil
+}
+
+// installPreCommitHook: install the pre-commit hook, leaving any existing
+// hook in place
+func installPreCommitHook() error {
+	return installHook("pre-commit", preCommitHook)
+}
+
+// installHook: install a git hook, leaving any existing hook in place
+func installHook(name, script string) error {
+	gitDir, err := gitOutput("rev-parse", "--git-dir")
+	if err != nil {
+		return err
+	}
+
+	hookFilepath := filepath.Join(strings.TrimSpace(gitDir), "hooks", name)
+	if _, err := os.Stat(hookFilepath); err == nil {
+		logWarning(hookFilepath, "already exists, not installing the safe hook")
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(hookFilepath), 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(hookFilepath, []byte(script), 0755)
+}
@@ -0,0 +1,43 @@
+package safe
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// pgpPublicKeyHeader starts each armored key in a keybase key bundle
+const pgpPublicKeyHeader = "-----BEGIN PGP PUBLIC KEY BLOCK-----"
+
+// fetchKeybaseKeys: fetch the pgp keys a keybase user publishes, split into
+// one key each as gpg requires
+func fetchKeybaseKeys(username string) ([][]byte, error) {
+	resp, err := http.Get("https://keybase.io/" + url.PathEscape(username) + "/pgp_keys.asc")
+	if err != nil {
+		return [][]byte(nil), err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return [][]byte(nil), fmt.Errorf("unable to fetch keys for keybase user %s: %s", username, resp.Status)
+	}
+
+	byts, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return [][]byte(nil), err
+	}
+
+	keys := make([][]byte, 0)
+	for _, block := range strings.Split(string(byts), pgpPublicKeyHeader)[1:] {
+		keys = append(keys, []byte(pgpPublicKeyHeader+block))
+	}
+
+	if len(keys) == 0 {
+		return [][]byte(nil), errors.New("keybase user " + username + " has no pgp keys")
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,404 @@
+package safe
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gpgKey: a key from the local gpg keyring, as reported by --with-colons
+type gpgKey struct {
+	Fingerprint  string
+	KeyID        string
+	Validity     string
+	Capabilities string
+	Algorithm    int
+	Bits         int
+	Expires      time.Time
+	UserIDs      []string
+	Subkeys      []gpgKey
+}
+
+// listGPGKeys: return the public keys in the local and team keyrings
+// matching the recipient, returning no keys when there are no matches
+func listGPGKeys(recipient string, config Config) ([]gpgKey, error) {
+	gpgArgs, err := teamKeyringArgs(config)
+	if err != nil {
+		return []gpgKey(nil), err
+	}
+
+	return listGPGKeysWith(gpgArgs, recipient, config)
+}
+
+// listGPGKeysIn: list keys from the keyring in homedir
+func listGPGKeysIn(homedir, recipient string, config Config) ([]gpgKey, error) {
+	return listGPGKeysWith([]string{"--homedir", homedir}, recipient, config)
+}
+
+// listGPGKeysWith: list keys matching the recipient, passing extra options
+// such as --keyring to gpg
+func listGPGKeysWith(gpgArgs []string, recipient string, config Config) ([]gpgKey, error) {
+	args := append(append([]string{}, gpgArgs...), "--batch", "--with-colons", "--fixed-list-mode", "--list-keys", recipient)
+
+	cmd := gpgCommand(config, args...)
+
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	// gpg exits non-zero when nothing matches, though it may still print
+	// trust database records
+	if err := runCommand(cmd); err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			return []gpgKey(nil), err
+		}
+	}
+
+	keys := make([]gpgKey, 0)
+	var current *gpgKey
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 10 {
+			continue
+		}
+
+		switch fields[0] {
+		case "pub":
+			keys = append(keys, parseGPGKey(fields))
+			current = &keys[len(keys)-1]
+		case "sub":
+			if len(keys) == 0 {
+				continue
+			}
+			primary := &keys[len(keys)-1]
+			primary.Subkeys = append(primary.Subkeys, parseGPGKey(fields))
+			current = &primary.Subkeys[len(primary.Subkeys)-1]
+		case "fpr":
+			if current != nil && current.Fingerprint == "" {
+				current.Fingerprint = fields[9]
+			}
+		case "uid":
+			if len(keys) > 0 {
+				keys[len(keys)-1].UserIDs = append(keys[len(keys)-1].UserIDs, fields[9])
+			}
+		}
+	}
+
+	return keys, nil
+}
+
+// parseGPGKey: parse a pub or sub record
+func parseGPGKey(fields []string) gpgKey {
+	key := gpgKey{
+		Validity: fields[1],
+		KeyID:    fields[4],
+	}
+
+	if len(fields) > 11 {
+		key.Capabilities = fields[11]
+	}
+
+	key.Bits, _ = strconv.Atoi(fields[2])
+	key.Algorithm, _ = strconv.Atoi(fields[3])
+
+	if timestamp, err := strconv.ParseInt(fields[6], 10, 64); err == nil {
+		key.Expires = time.Unix(timestamp, 0)
+	}
+
+	return key
+}
+
+// normalizeFingerprint: strip the formatting commonly used when writing out
+// fingerprints, so they can be compared
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToUpper(strings.Replace(strings.TrimPrefix(fingerprint, "0x"), " ", "", -1))
+}
+
+// FetchMissingKeys: fetch the key for each recipient that isn't in the
+// local keyring via WKD, or the configured keyserver, importing it after
+// confirming its fingerprint
+func FetchMissingKeys(recipients []string, config Config) error {
+	for _, recipient := range recipients {
+		if !isKeyringRecipient(recipient) {
+			continue
+		}
+
+		keys, err := listGPGKeys(recipient, config)
+		if err != nil {
+			return err
+		}
+
+		if len(keys) > 0 {
+			continue
+		}
+
+		if err := FetchKey(recipient, config); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MissingKeysError: the recipients whose keys aren't available to encrypt to
+type MissingKeysError struct {
+	Recipients []string
+}
+
+func (e MissingKeysError) Error() string {
+	lines := []string{"no key found for:"}
+	for _, recipient := range e.Recipients {
+		lines = append(lines, "  "+recipient+": "+missingKeyHint(recipient))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// missingKeyHint: describe how to obtain the key for a recipient
+func missingKeyHint(recipient string) string {
+	if normalized := normalizeFingerprint(recipient); isHex(normalized) && len(normalized) >= 16 {
+		return "import it with `gpg --recv-keys " + normalized + "`"
+	}
+
+	if strings.Contains(recipient, "@") {
+		return "import it with `gpg --locate-keys " + recipient + "`, or set fetch_keys in safe.yml"
+	}
+
+	return "ask them for their public key and import it with `gpg --import`"
+}
+
+// CheckMissingKeys: resolve every keyring recipient against the local and
+// team keyrings, returning a MissingKeysError listing the keys that aren't
+// available
+func CheckMissingKeys(recipients []string, config Config) error {
+	gpgArgs, err := teamKeyringArgs(config)
+	if err != nil {
+		return err
+	}
+
+	missing := make([]string, 0)
+	for _, recipient := range recipients {
+		if !isKeyringRecipient(recipient) {
+			continue
+		}
+
+		keys, err := listGPGKeysWith(gpgArgs, recipient, config)
+		if err != nil {
+			return err
+		}
+
+		if len(keys) == 0 {
+			missing = append(missing, recipient)
+		}
+	}
+
+	if len(missing) > 0 {
+		return MissingKeysError{Recipients: missing}
+	}
+
+	return nil
+}
+
+// FetchKey: fetch a recipient's key into a temporary keyring, and import it
+// into the local keyring once its fingerprint has been confirmed
+func FetchKey(recipient string, config Config) error {
+	homedir, err := ioutil.TempDir("", "safe--gnupg-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(homedir)
+
+	mechanisms := "clear,nodefault,wkd"
+	args := []string{"--homedir", homedir, "--batch"}
+	if config.Keyserver != "" {
+		mechanisms += ",keyserver"
+		args = append(args, "--keyserver", config.Keyserver)
+	}
+	args = append(args, "--auto-key-locate", mechanisms, "--locate-keys", recipient)
+
+	if err := runCommand(gpgCommand(config, args...)); err != nil {
+		return errors.New("unable to fetch key for " + recipient)
+	}
+
+	keys, err := listGPGKeysIn(homedir, recipient, config)
+	if err != nil {
+		return err
+	}
+
+	if len(keys) == 0 {
+		return errors.New("unable to fetch key for " + recipient)
+	}
+
+	for _, key := range keys {
+		ok, err := Confirm(fmt.Sprintf("import key %s (%s) for %s?", key.Fingerprint, strings.Join(key.UserIDs, ", "), recipient))
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			return errors.New("key for " + recipient + " not imported")
+		}
+
+		exportCmd := gpgCommand(config, "--homedir", homedir, "--batch", "--armor", "--export", key.Fingerprint)
+		var exported bytes.Buffer
+		exportCmd.Stdout = &exported
+		if err := runCommand(exportCmd); err != nil {
+			return err
+		}
+
+		importCmd := gpgCommand(config, "--batch", "--import")
+		importCmd.Stdin = &exported
+		if err := runCommand(importCmd); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ciphertextKeyIDs: return the ids of the keys a gpg ciphertext is
+// encrypted to, without decrypting it
+func ciphertextKeyIDs(filepath string, config Config) ([]string, error) {
+	cmd := gpgCommand(config, "--batch", "--list-only", "--list-packets", filepath)
+
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	runErr := runCommand(cmd)
+
+	keyIDs := make([]string, 0)
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		if !strings.HasPrefix(line, ":pubkey enc packet:") {
+			continue
+		}
+
+		if idx := strings.Index(line, "keyid "); idx >= 0 {
+			keyIDs = append(keyIDs, strings.ToUpper(strings.TrimSpace(line[idx+len("keyid "):])))
+		}
+	}
+
+	if len(keyIDs) == 0 && runErr != nil {
+		return []string(nil), runErr
+	}
+
+	return keyIDs, nil
+}
+
+// recipientKeyIDs: return the ids of the primary key and subkeys in the
+// local keyring for a recipient
+func recipientKeyIDs(recipient string, config Config) ([]string, error) {
+	keys, err := listGPGKeys(recipient, config)
+	if err != nil {
+		return []string(nil), err
+	}
+
+	keyIDs := make([]string, 0)
+	for _, key := range keys {
+		keyIDs = append(keyIDs, key.KeyID)
+		for _, subkey := range key.Subkeys {
+			keyIDs = append(keyIDs, subkey.KeyID)
+		}
+	}
+
+	// a key id or fingerprint identifies a key, even when it's missing from
+	// the local keyring
+	if normalized := normalizeFingerprint(recipient); isHex(normalized) && len(normalized) >= 16 {
+		keyIDs = append(keyIDs, normalized[len(normalized)-16:])
+	}
+
+	return keyIDs, nil
+}
+
+// isHex: return whether the value only contains hex digits
+func isHex(value string) bool {
+	for _, r := range value {
+		if !strings.ContainsRune("0123456789ABCDEFabcdef", r) {
+			return false
+		}
+	}
+
+	return value != ""
+}
+
+// DefaultExpiryWarningDays is how soon before a recipient's key expires
+// that encrypting to it warns
+const DefaultExpiryWarningDays = 30
+
+// ExpiryWarning: a recipient whose key has expired, or will expire soon
+type ExpiryWarning struct {
+	Recipient string
+	Expires   time.Time
+}
+
+func (w ExpiryWarning) Error() string {
+	if w.Expired() {
+		return fmt.Sprintf("key for %s expired on %s", w.Recipient, w.Expires.Format("2006-01-02"))
+	}
+
+	return fmt.Sprintf("key for %s expires on %s", w.Recipient, w.Expires.Format("2006-01-02"))
+}
+
+// Expired: return whether the key has already expired
+func (w ExpiryWarning) Expired() bool {
+	return !w.Expires.After(time.Now())
+}
+
+// encryptionExpiry: return when a key stops being usable for encryption,
+// which is the later of its encryption subkeys' expiry, bounded by the
+// primary key's. A zero time means it never expires.
+func encryptionExpiry(key gpgKey) time.Time {
+	var expires time.Time
+	found := false
+	for _, candidate := range append([]gpgKey{key}, key.Subkeys...) {
+		// lower case capabilities are the key's own, rather than those of
+		// its subkeys
+		if !strings.Contains(candidate.Capabilities, "e") {
+			continue
+		}
+
+		if !found || (!expires.IsZero() && (candidate.Expires.IsZero() || candidate.Expires.After(expires))) {
+			expires = candidate.Expires
+		}
+		found = true
+	}
+
+	if !key.Expires.IsZero() && (expires.IsZero() || key.Expires.Before(expires)) {
+		expires = key.Expires
+	}
+
+	return expires
+}
+
+// CheckKeyExpiry: return a warning for each keyring recipient whose key has
+// expired, or expires within the config's warning window
+func CheckKeyExpiry(recipients []string, config Config) ([]ExpiryWarning, error) {
+	days := config.ExpiryWarningDays
+	if days == 0 {
+		days = DefaultExpiryWarningDays
+	}
+	threshold := time.Now().AddDate(0, 0, days)
+
+	warnings := make([]ExpiryWarning, 0)
+	for _, recipient := range recipients {
+		if !isKeyringRecipient(recipient) {
+			continue
+		}
+
+		keys, err := listGPGKeys(recipient, config)
+		if err != nil {
+			return []ExpiryWarning(nil), err
+		}
+
+		for _, key := range keys {
+			expires := encryptionExpiry(key)
+			if !expires.IsZero() && expires.Before(threshold) {
+				warnings = append(warnings, ExpiryWarning{Recipient: recipient, Expires: expires})
+			}
+		}
+	}
+
+	return warnings, nil
+}
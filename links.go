@@ -0,0 +1,235 @@
+package safe
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// link: a one-time link to a decrypted file, stored encrypted with a key
+// that only exists in the link's token
+type link struct {
+	Filepath  string    `json:"filepath"`
+	ExposedBy string    `json:"exposed_by"`
+	Expires   time.Time `json:"expires"`
+
+	// AuditLog is where redeeming the link is recorded
+	AuditLog string `json:"audit_log"`
+
+	// Ciphertext is prefixed with its nonce
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// linksDir: return the directory links are shared through, which every
+// user on the host can read and write
+func linksDir() string {
+	return filepath.Join(os.TempDir(), "safe-links")
+}
+
+// ExposeLink: decrypt a protected file into a one-time link that expires
+// after ttl, returning the token another user on the same host can pass to
+// Redeem to receive the decrypted content
+func ExposeLink(targetFilepath string, ttl time.Duration, config Config) (string, error) {
+	protected, err := IsProtected(targetFilepath, config)
+	if err != nil {
+		return "", err
+	}
+
+	if !protected {
+		return "", errors.New(targetFilepath + " is not protected")
+	}
+
+	relFilepath, err := relativePath(targetFilepath, config)
+	if err != nil {
+		return "", err
+	}
+
+	reason := ""
+	if config.Metadata[relFilepath].ConfirmDecrypt {
+		if reason, err = promptReason(relFilepath); err != nil {
+			return "", err
+		}
+	}
+
+	byts, err := Decrypt(targetFilepath, config)
+	if err != nil {
+		return "", err
+	}
+
+	id := make([]byte, 16)
+	key := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+
+	ciphertext, err := sealLink(key, byts)
+	if err != nil {
+		return "", err
+	}
+
+	linkByts, err := json.Marshal(link{
+		Filepath:   relFilepath,
+		ExposedBy:  currentUser(config),
+		Expires:    time.Now().Add(ttl).UTC(),
+		AuditLog:   AuditLogPath(config),
+		Ciphertext: ciphertext,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// NOTE: the directory isn't sticky, so that whoever redeems a link can
+	// remove it
+	if err := os.Mkdir(linksDir(), 0777); err == nil {
+		if err := os.Chmod(linksDir(), 0777); err != nil {
+			return "", err
+		}
+	} else if !os.IsExist(err) {
+		return "", err
+	}
+
+	pruneLinks()
+
+	if err := ioutil.WriteFile(filepath.Join(linksDir(), hex.EncodeToString(id)), linkByts, 0644); err != nil {
+		return "", err
+	}
+
+	if err := RecordAudit("expose-link", targetFilepath, reason, config); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(id) + "." + base64.RawURLEncoding.EncodeToString(key), nil
+}
+
+// Redeem: return the decrypted content of a one-time link, removing it so
+// it can't be redeemed again
+func Redeem(token string) ([]byte, error) {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 || !isHex(parts[0]) {
+		return []byte(nil), errors.New("invalid link token")
+	}
+
+	key, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return []byte(nil), errors.New("invalid link token")
+	}
+
+	// renaming the link claims it, so only one redemption can succeed
+	linkFilepath := filepath.Join(linksDir(), parts[0])
+	claimedFilepath := linkFilepath + ".redeemed-" + strconv.Itoa(os.Getpid())
+	if err := os.Rename(linkFilepath, claimedFilepath); err != nil {
+		if os.IsNotExist(err) {
+			return []byte(nil), errors.New("link has expired or was already redeemed")
+		}
+		return []byte(nil), err
+	}
+	defer os.Remove(claimedFilepath)
+
+	linkByts, err := ioutil.ReadFile(claimedFilepath)
+	if err != nil {
+		return []byte(nil), err
+	}
+
+	var l link
+	if err := json.Unmarshal(linkByts, &l); err != nil {
+		return []byte(nil), err
+	}
+
+	if time.Now().After(l.Expires) {
+		return []byte(nil), errors.New("link has expired or was already redeemed")
+	}
+
+	byts, err := openLink(key, l.Ciphertext)
+	if err != nil {
+		return []byte(nil), err
+	}
+
+	// the audit log belongs to whoever exposed the link, so recording the
+	// redemption is best effort
+	err = appendAudit(l.AuditLog, AuditEntry{
+		Time:     time.Now().UTC(),
+		Actor:    currentUser(Config{}),
+		Action:   "redeem-link",
+		Filepath: l.Filepath,
+		Reason:   "exposed by " + l.ExposedBy,
+	})
+	if err != nil {
+		logWarning("unable to record redemption:", err)
+	}
+
+	return byts, nil
+}
+
+// pruneLinks: remove expired links, ignoring any that can't be read
+func pruneLinks() {
+	infos, err := ioutil.ReadDir(linksDir())
+	if err != nil {
+		return
+	}
+
+	for _, info := range infos {
+		linkFilepath := filepath.Join(linksDir(), info.Name())
+
+		byts, err := ioutil.ReadFile(linkFilepath)
+		if err != nil {
+			continue
+		}
+
+		var l link
+		if err := json.Unmarshal(byts, &l); err != nil || time.Now().After(l.Expires) {
+			os.Remove(linkFilepath)
+		}
+	}
+}
+
+// sealLink: encrypt the content of a link with AES-GCM
+func sealLink(key, byts []byte) ([]byte, error) {
+	aead, err := linkCipher(key)
+	if err != nil {
+		return []byte(nil), err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return []byte(nil), err
+	}
+
+	return aead.Seal(nonce, nonce, byts, nil), nil
+}
+
+// openLink: decrypt the content of a link
+func openLink(key, ciphertext []byte) ([]byte, error) {
+	aead, err := linkCipher(key)
+	if err != nil {
+		return []byte(nil), err
+	}
+
+	if len(ciphertext) < aead.NonceSize() {
+		return []byte(nil), errors.New("invalid link")
+	}
+
+	return aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], nil)
+}
+
+// linkCipher: return the AES-GCM cipher for a link's key
+func linkCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
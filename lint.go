@@ -0,0 +1,112 @@
+package safe
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// LintIssue: a problem found in safe.yml
+type LintIssue struct {
+	Rule    string `json:"rule"`
+	Entry   string `json:"entry"`
+	Problem string `json:"problem"`
+}
+
+func (i LintIssue) String() string {
+	return fmt.Sprintf("%s: %s (%s)", i.Entry, i.Problem, i.Rule)
+}
+
+// LintConfig: validate safe.yml, reporting unknown keys, duplicate, missing
+// or unsuffixed file entries, configuration for untracked files and
+// recipients whose keys aren't in the keyring
+func LintConfig(config Config) ([]LintIssue, error) {
+	issues := make([]LintIssue, 0)
+
+	byts, err := ioutil.ReadFile(config.filepath)
+	if err != nil {
+		return []LintIssue(nil), err
+	}
+
+	if err := decodeConfig(byts, configFormat(config.filepath), true, &Config{}); err != nil {
+		typeErr, ok := err.(*yaml.TypeError)
+		if !ok {
+			return []LintIssue(nil), err
+		}
+
+		for _, msg := range typeErr.Errors {
+			issues = append(issues, LintIssue{Rule: "unknown-key", Entry: filepath.Base(config.filepath), Problem: msg})
+		}
+	}
+
+	own := ownConfig(config)
+
+	seen := make(map[string]bool)
+	for _, file := range own.Files {
+		if seen[file] {
+			issues = append(issues, LintIssue{Rule: "duplicate-file", Entry: file, Problem: "listed more than once in files"})
+			continue
+		}
+		seen[file] = true
+
+		if !strings.HasSuffix(file, Suffix(config)) {
+			issues = append(issues, LintIssue{Rule: "missing-suffix", Entry: file, Problem: "doesn't end with " + Suffix(config)})
+		}
+
+		if isPattern(file) {
+			continue
+		}
+
+		if _, err := os.Stat(filepath.Join(config.baseDir, file)); os.IsNotExist(err) {
+			issues = append(issues, LintIssue{Rule: "missing-file", Entry: file, Problem: "doesn't exist"})
+		}
+	}
+
+	for file := range own.Overrides {
+		if protected, err := IsProtected(configPath(file, config), config); err != nil || !protected {
+			issues = append(issues, LintIssue{Rule: "untracked-override", Entry: file, Problem: "has overrides but isn't in files"})
+		}
+	}
+
+	for file := range own.Backends {
+		if protected, err := IsProtected(configPath(file, config), config); err != nil || !protected {
+			issues = append(issues, LintIssue{Rule: "untracked-backend", Entry: file, Problem: "has a backend but isn't in files"})
+		}
+	}
+
+	recipients := make([]string, 0)
+	backendName := config.Backend
+	if backendName == "" {
+		backendName = defaultBackendName(config)
+	}
+	if backendName == "gpg" {
+		recipients = append(recipients, config.Recipients...)
+	}
+
+	for file, overrides := range config.Overrides {
+		backend, err := BackendFor(file, config)
+		if err != nil {
+			issues = append(issues, LintIssue{Rule: "unknown-backend", Entry: file, Problem: err.Error()})
+			continue
+		}
+
+		if _, ok := backend.(gpgBackend); ok {
+			recipients = append(recipients, overrides...)
+		}
+	}
+
+	err = CheckMissingKeys(uniqueStrings(recipients), config)
+	if missingErr, ok := err.(MissingKeysError); ok {
+		for _, recipient := range missingErr.Recipients {
+			issues = append(issues, LintIssue{Rule: "missing-key", Entry: recipient, Problem: "no key in the keyring, " + missingKeyHint(recipient)})
+		}
+	} else if err != nil {
+		return []LintIssue(nil), err
+	}
+
+	return issues, nil
+}
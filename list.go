@@ -0,0 +1,86 @@
+package safe
+
+import (
+	"errors"
+	"path/filepath"
+)
+
+// ListOptions: how List reports the paths of protected files. Paths are
+// relative to the config by default.
+type ListOptions struct {
+	// Absolute reports absolute paths
+	Absolute bool
+
+	// RelativeTo reports paths relative to the directory, eg: an editor's
+	// workspace
+	RelativeTo string
+
+	// IncludeArchived lists archived files too
+	IncludeArchived bool
+}
+
+// ListedFile: a protected file, as reported by List
+type ListedFile struct {
+	Path       string   `json:"path"`
+	Backend    string   `json:"backend"`
+	Recipients []string `json:"recipients"`
+	Archived   bool     `json:"archived,omitempty"`
+}
+
+// String: format the file as its path
+func (f ListedFile) String() string {
+	return f.Path
+}
+
+// List: return every protected file, expanding glob patterns, so scripts
+// and editor plugins don't need to parse safe.yml themselves
+func List(config Config, opts ListOptions) ([]ListedFile, error) {
+	if opts.Absolute && opts.RelativeTo != "" {
+		return []ListedFile(nil), errors.New("absolute and relative-to can't be used together")
+	}
+
+	relativeTo := ""
+	if opts.RelativeTo != "" {
+		var err error
+		if relativeTo, err = filepath.Abs(opts.RelativeTo); err != nil {
+			return []ListedFile(nil), err
+		}
+	}
+
+	filepaths, err := ProtectedFiles(config)
+	if err != nil {
+		return []ListedFile(nil), err
+	}
+
+	files := make([]ListedFile, 0, len(filepaths))
+	for _, relFilepath := range filepaths {
+		metadata := config.Metadata[relFilepath]
+		if metadata.Archived && !opts.IncludeArchived {
+			continue
+		}
+
+		path := relFilepath
+		switch {
+		case opts.Absolute:
+			path = configPath(relFilepath, config)
+		case relativeTo != "":
+			if path, err = filepath.Rel(relativeTo, configPath(relFilepath, config)); err != nil {
+				return []ListedFile(nil), err
+			}
+		}
+
+		recipients := RecipientsFor(relFilepath, config)
+		if encryption := config.encryptions[relFilepath]; len(encryption.Recipients) > 0 {
+			recipients = encryption.Recipients
+		}
+
+		files = append(files, ListedFile{
+			Path:       path,
+			Backend:    backendName(relFilepath, config),
+			Recipients: recipients,
+			Archived:   metadata.Archived,
+		})
+	}
+
+	return files, nil
+}
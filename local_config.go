@@ -0,0 +1,153 @@
+package safe
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// LocalConfigName is the gitignored file, next to safe.yml, that holds a
+// user's own settings for the repository
+const LocalConfigName = "safe.local.yml"
+
+// LocalConfig: per-user settings for a single repository, merged over its
+// safe.yml but never written back to it
+type LocalConfig struct {
+	// Editor is used by edit in place of $EDITOR
+	Editor string `yaml:"editor,omitempty"`
+
+	// TempDir is where files are decrypted to for editing, defaulting to
+	// /tmp
+	TempDir string `yaml:"temp_dir,omitempty"`
+
+	// GPG is the path of the gpg binary to use
+	GPG string `yaml:"gpg,omitempty"`
+
+	// Overrides are the user's own recipients for their scratch files,
+	// taking precedence over those in safe.yml
+	Overrides map[string][]string `yaml:"overrides,omitempty"`
+
+	// Env holds local values, eg: a localhost database url, that exec
+	// exports in place of the decrypted ones
+	Env map[string]string `yaml:"env,omitempty"`
+
+	// shadowed holds the safe.yml overrides replaced by Overrides, so they
+	// can be written back
+	shadowed map[string][]string
+}
+
+// loadLocalConfig: load the safe.local.yml next to the config, if any
+func loadLocalConfig(baseDir string) (LocalConfig, bool, error) {
+	localFilepath := filepath.Join(baseDir, LocalConfigName)
+
+	byts, err := ioutil.ReadFile(localFilepath)
+	if os.IsNotExist(err) {
+		return LocalConfig{}, false, nil
+	}
+	if err != nil {
+		return LocalConfig{}, false, err
+	}
+
+	var local LocalConfig
+	if err := yaml.UnmarshalStrict(byts, &local); err != nil {
+		return LocalConfig{}, false, err
+	}
+
+	// the local config is personal, so committing it is almost certainly a
+	// mistake
+	if out, err := gitOutput("-C", baseDir, "ls-files", LocalConfigName); err == nil && strings.TrimSpace(out) != "" {
+		logWarning(localFilepath, "is tracked by git, it should be gitignored")
+	}
+
+	return local, true, nil
+}
+
+// applyLocalConfig: merge the local config over the config
+func applyLocalConfig(local LocalConfig, config *Config) {
+	if local.GPG != "" {
+		config.gpgBinary = local.GPG
+	}
+
+	local.shadowed = make(map[string][]string)
+	if config.Overrides == nil {
+		config.Overrides = make(map[string][]string)
+	}
+
+	for file, recipients := range local.Overrides {
+		if shadowed, ok := config.Overrides[file]; ok {
+			local.shadowed[file] = shadowed
+		}
+		config.Overrides[file] = recipients
+	}
+
+	config.local = &local
+}
+
+// editorFor: return the editor to edit files with
+func editorFor(config Config) string {
+	if config.local != nil && config.local.Editor != "" {
+		return config.local.Editor
+	}
+
+	if config.user.Editor != "" {
+		return config.user.Editor
+	}
+
+	if editor := os.Getenv("EDITOR"); editor != "" {
+		return editor
+	}
+
+	return "vim"
+}
+
+// tempDirFor: return the directory to decrypt files into for editing
+func tempDirFor(config Config) string {
+	if config.local != nil && config.local.TempDir != "" {
+		return config.local.TempDir
+	}
+
+	return "/tmp"
+}
+
+// ignoreLocalFiles: add safe.local.yml and the config lock to the
+// .gitignore in dir, returning the path of the .gitignore
+func ignoreLocalFiles(dir string) (string, error) {
+	return addToGitignore(dir, []string{LocalConfigName, ".safe/" + ConfigLockName})
+}
+
+// addToGitignore: add paths relative to dir to the .gitignore in dir,
+// unless already listed, returning the path of the .gitignore
+func addToGitignore(dir string, names []string) (string, error) {
+	gitignoreFilepath := filepath.Join(dir, ".gitignore")
+
+	byts, err := ioutil.ReadFile(gitignoreFilepath)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	for _, name := range names {
+		name = filepath.ToSlash(name)
+
+		ignored := false
+		for _, line := range strings.Split(string(byts), "\n") {
+			if strings.TrimSpace(line) == name || strings.TrimSpace(line) == "/"+name {
+				ignored = true
+				break
+			}
+		}
+
+		if ignored {
+			continue
+		}
+
+		if len(byts) > 0 && byts[len(byts)-1] != '\n' {
+			byts = append(byts, '\n')
+		}
+		byts = append(byts, []byte("/"+name+"\n")...)
+	}
+
+	return gitignoreFilepath, ioutil.WriteFile(gitignoreFilepath, byts, 0644)
+}
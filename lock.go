@@ -0,0 +1,167 @@
+package safe
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Lock: an advisory lock on a protected file, committed to git so that
+// other users see it before editing
+type Lock struct {
+	Holder  string    `yaml:"holder"`
+	Expires time.Time `yaml:"expires"`
+}
+
+// Active: return whether the lock has not yet expired
+func (l Lock) Active() bool {
+	return time.Now().Before(l.Expires)
+}
+
+// lockPath: return the path of the lock file for a protected file
+func lockPath(targetFilepath string, config Config) (string, error) {
+	relFilepath, err := relativePath(targetFilepath, config)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(config.baseDir, ".safe", "locks", relFilepath+".lock"), nil
+}
+
+// currentUser: return the name used to attribute actions to the current
+// user, preferring their gpg identity and then their git email
+func currentUser(config Config) string {
+	if identity := Identity(config); identity != "" {
+		return identity
+	}
+
+	if email, err := gitOutput("config", "user.email"); err == nil && strings.TrimSpace(email) != "" {
+		return strings.TrimSpace(email)
+	}
+
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+
+	return "unknown"
+}
+
+// GetLock: return the lock for a protected file, or nil if it isn't locked
+func GetLock(targetFilepath string, config Config) (*Lock, error) {
+	lockFilepath, err := lockPath(targetFilepath, config)
+	if err != nil {
+		return nil, err
+	}
+
+	byts, err := ioutil.ReadFile(lockFilepath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var lock Lock
+	if err := yaml.Unmarshal(byts, &lock); err != nil {
+		return nil, err
+	}
+
+	return &lock, nil
+}
+
+// CheckLock: return an error if the protected file is locked by someone
+// other than the current user
+func CheckLock(targetFilepath string, config Config) error {
+	lock, err := GetLock(targetFilepath, config)
+	if err != nil {
+		return err
+	}
+
+	if lock == nil || !lock.Active() || lock.Holder == currentUser(config) {
+		return nil
+	}
+
+	return fmt.Errorf("%s is locked by %s until %s", targetFilepath, lock.Holder, lock.Expires.Format(time.RFC3339))
+}
+
+// LockFile: take an advisory lock on a protected file for the duration
+func LockFile(targetFilepath string, duration time.Duration, commit bool, config Config) error {
+	protected, err := IsProtected(targetFilepath, config)
+	if err != nil {
+		return err
+	}
+
+	if !protected {
+		return errors.New(targetFilepath + " is not protected")
+	}
+
+	if err := CheckLock(targetFilepath, config); err != nil {
+		return err
+	}
+
+	lockFilepath, err := lockPath(targetFilepath, config)
+	if err != nil {
+		return err
+	}
+
+	byts, err := yaml.Marshal(Lock{
+		Holder:  currentUser(config),
+		Expires: time.Now().Add(duration).UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(lockFilepath), 0755); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(lockFilepath, byts, 0644); err != nil {
+		return err
+	}
+
+	if !commit {
+		return nil
+	}
+
+	return Commit("lock", targetFilepath, []string{lockFilepath}, config)
+}
+
+// UnlockFile: release an advisory lock on a protected file. Locks held by
+// other users can only be released when forced.
+func UnlockFile(targetFilepath string, force, commit bool, config Config) error {
+	lock, err := GetLock(targetFilepath, config)
+	if err != nil {
+		return err
+	}
+
+	if lock == nil {
+		return errors.New(targetFilepath + " is not locked")
+	}
+
+	if !force && lock.Active() && lock.Holder != currentUser(config) {
+		return errors.New(targetFilepath + " is locked by " + lock.Holder)
+	}
+
+	lockFilepath, err := lockPath(targetFilepath, config)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(lockFilepath); err != nil {
+		return err
+	}
+
+	if !commit {
+		return nil
+	}
+
+	return Commit("unlock", targetFilepath, []string{lockFilepath}, config)
+}
@@ -0,0 +1,101 @@
+package safe
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// LogLevel: how much safe reports on stderr as it works
+type LogLevel int
+
+const (
+	// LogQuiet reports nothing, as errors are returned rather than logged
+	LogQuiet LogLevel = iota
+
+	// LogNormal reports warnings and what safe did, eg: git's commit summary
+	LogNormal
+
+	// LogVerbose also reports each command safe runs, such as gpg and git
+	LogVerbose
+)
+
+// logLevel and logOutput control the diagnostics safe writes. They're kept
+// off stdout, which only carries results, eg: the plaintext from Print.
+var (
+	logLevel            = LogNormal
+	logOutput io.Writer = os.Stderr
+)
+
+// SetLogLevel: set how much safe reports, eg: LogVerbose for --verbose or
+// LogQuiet for --quiet
+func SetLogLevel(level LogLevel) {
+	logLevel = level
+}
+
+// SetLogOutput: replace stderr as the destination for safe's diagnostics
+func SetLogOutput(w io.Writer) {
+	logOutput = w
+}
+
+// logWriter: return the log output when diagnostics of the level are
+// reported, otherwise a writer that discards them. Diagnostics are written
+// above any progress bar.
+func logWriter(level LogLevel) io.Writer {
+	if logLevel < level {
+		return ioutil.Discard
+	}
+
+	if activeProgress != nil {
+		return progressWriter{activeProgress}
+	}
+
+	return logOutput
+}
+
+// logInfo: report what safe did, unless quiet
+func logInfo(v ...interface{}) {
+	fmt.Fprintln(logWriter(LogNormal), v...)
+}
+
+// logWarning: report something the user should look at that didn't stop
+// safe, unless quiet
+func logWarning(v ...interface{}) {
+	prefix := "warning:"
+	if file, ok := logOutput.(*os.File); ok && logLevel >= LogNormal && UseColor(file) {
+		prefix = colorize(yellowColor, prefix)
+	}
+
+	logInfo(append([]interface{}{prefix}, v...)...)
+}
+
+// logCommand: report a command as it's created, when verbose. Passphrases
+// passed through gpg_options are redacted.
+func logCommand(name string, args []string) {
+	if logLevel < LogVerbose {
+		return
+	}
+
+	words := []string{"+", name}
+	redact := false
+	for _, arg := range args {
+		word := arg
+		if arg == "" || strings.ContainsAny(arg, " \t\n'\"\\$`*?;&|<>()") {
+			word = shellQuote(arg)
+		}
+
+		switch {
+		case redact:
+			word = "<redacted>"
+		case strings.HasPrefix(arg, "--passphrase="):
+			word = "--passphrase=<redacted>"
+		}
+
+		redact = arg == "--passphrase"
+		words = append(words, word)
+	}
+
+	fmt.Fprintln(logWriter(LogVerbose), strings.Join(words, " "))
+}
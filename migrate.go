@@ -0,0 +1,159 @@
+package safe
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// CurrentConfigVersion is the latest config layout
+const CurrentConfigVersion = 3
+
+// configMigrations upgrade a raw config, along with the raw encryption
+// records kept next to it, from one version to the next, where the first
+// migration upgrades configs without a version, ie: version 1
+var configMigrations = []func(data, encryptions map[string]interface{}) error{
+	migrateVersionKey,
+	migrateEncryptions,
+}
+
+// encryptionFields are the metadata fields that version 3 moved from
+// safe.yml to .safe/encryptions.yml. The hash of the plaintext that version
+// 2 recorded is dropped rather than moved, as it can be brute forced.
+var encryptionFields = []string{"recipients", "encrypted_to", "encrypted_with", "encrypted_at"}
+
+// migrateVersionKey: version 2 kept the version 1 layout and only added the
+// version key, which MigrateConfig sets once every migration has run
+func migrateVersionKey(data, encryptions map[string]interface{}) error {
+	return nil
+}
+
+// migrateEncryptions: move the record of each file's last encryption out of
+// its metadata, so that encrypting no longer rewrites safe.yml
+func migrateEncryptions(data, encryptions map[string]interface{}) error {
+	metadata, ok := data["metadata"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	for file, value := range metadata {
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("invalid metadata for %s", file)
+		}
+
+		delete(fields, "hash")
+
+		encryption := make(map[string]interface{})
+		for _, field := range encryptionFields {
+			if fieldValue, ok := fields[field]; ok {
+				encryption[field] = fieldValue
+				delete(fields, field)
+			}
+		}
+
+		if len(encryption) > 0 {
+			encryptions[file] = encryption
+		}
+		if len(fields) == 0 {
+			delete(metadata, file)
+		}
+	}
+
+	if len(metadata) == 0 {
+		delete(data, "metadata")
+	}
+
+	return nil
+}
+
+// MigrateConfig: upgrade a config file to the current layout in place,
+// writing it back in the format it was read in
+func MigrateConfig(configFilepath string, opts Options) (Result, error) {
+	byts, err := ioutil.ReadFile(configFilepath)
+	if err != nil {
+		return Result{}, err
+	}
+
+	format := configFormat(configFilepath)
+	data, err := parseStructured(byts, format)
+	if err != nil {
+		return Result{}, err
+	}
+
+	version := 1
+	if value, ok := data["version"]; ok {
+		if version, ok = value.(int); !ok {
+			return Result{}, fmt.Errorf("invalid version %v", value)
+		}
+	}
+
+	if version > CurrentConfigVersion {
+		return Result{}, fmt.Errorf("%s is version %d, but this version of safe only supports up to %d", configFilepath, version, CurrentConfigVersion)
+	}
+
+	if version == CurrentConfigVersion {
+		return Result{}, nil
+	}
+
+	encryptionsFilepath := EncryptionsPath(Config{baseDir: filepath.Dir(configFilepath)})
+	encryptions := make(map[string]interface{})
+	if byts, err := ioutil.ReadFile(encryptionsFilepath); err == nil {
+		if err := yaml.Unmarshal(byts, &encryptions); err != nil {
+			return Result{}, err
+		}
+		encryptions = stringKeys(encryptions).(map[string]interface{})
+	} else if !os.IsNotExist(err) {
+		return Result{}, err
+	}
+
+	for _, migration := range configMigrations[version-1:] {
+		if err := migration(data, encryptions); err != nil {
+			return Result{}, err
+		}
+	}
+	data["version"] = CurrentConfigVersion
+
+	result := Result{ConfigChanged: true, Committed: opts.Commit}
+	if opts.DryRun {
+		return result, nil
+	}
+
+	migrated, err := encodeStructured(data, format)
+	if err != nil {
+		return Result{}, err
+	}
+
+	if err := writeFileAtomic(configFilepath, migrated, 0644); err != nil {
+		return Result{}, err
+	}
+
+	if len(encryptions) > 0 {
+		encryptionByts, err := yaml.Marshal(encryptions)
+		if err != nil {
+			return Result{}, err
+		}
+
+		if err := os.MkdirAll(filepath.Dir(encryptionsFilepath), 0755); err != nil {
+			return Result{}, err
+		}
+
+		if err := writeFileAtomic(encryptionsFilepath, encryptionByts, 0644); err != nil {
+			return Result{}, err
+		}
+	}
+
+	if !opts.Commit {
+		return result, nil
+	}
+
+	config, err := LoadConfigFrom(configFilepath)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return result, Commit("migrate", filepath.Base(configFilepath), configFilepaths(config), config)
+}
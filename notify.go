@@ -0,0 +1,115 @@
+package safe
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// NotifyConfig: where to announce changes to secrets, such as a security
+// channel. Notifications name the files and who changed them, never their
+// values.
+type NotifyConfig struct {
+	// Slack is an incoming webhook url
+	Slack string `yaml:"slack,omitempty"`
+
+	// Webhook is a url that each NotifyEvent is posted to as json
+	Webhook string `yaml:"webhook,omitempty"`
+
+	// Email is a command that sends the notification it's given on stdin,
+	// eg: [mail, -s, secret changed, security@123.com]
+	Email []string `yaml:"email,omitempty"`
+}
+
+// NotifyEvent: a change to protected files, as sent to the notify hooks
+type NotifyEvent struct {
+	Time   time.Time `json:"time"`
+	Actor  string    `json:"actor"`
+	Action string    `json:"action"`
+	Files  []string  `json:"files"`
+
+	// Detail describes recipient changes, eg: the recipient revoked
+	Detail string `json:"detail,omitempty"`
+}
+
+// notifyTimeout bounds each webhook request, so an unreachable endpoint
+// doesn't hold up the command
+const notifyTimeout = 10 * time.Second
+
+// String: describe the event for humans
+func (e NotifyEvent) String() string {
+	message := fmt.Sprintf("%s ran %s on %s", e.Actor, e.Action, strings.Join(e.Files, ", "))
+	if e.Detail != "" {
+		message += " (" + e.Detail + ")"
+	}
+
+	return message
+}
+
+// notify: send an event to each configured hook. The change has already
+// been made, so failures are logged as warnings rather than returned.
+func notify(action string, filepaths []string, detail string, config Config) {
+	hooks := config.Notify
+	if hooks.Slack == "" && hooks.Webhook == "" && len(hooks.Email) == 0 {
+		return
+	}
+
+	event := NotifyEvent{
+		Time:   time.Now().UTC(),
+		Actor:  currentUser(config),
+		Action: action,
+		Files:  make([]string, 0, len(filepaths)),
+		Detail: detail,
+	}
+
+	for _, filepath := range filepaths {
+		if relFilepath, err := relativePath(filepath, config); err == nil {
+			filepath = relFilepath
+		}
+		event.Files = append(event.Files, filepath)
+	}
+
+	if hooks.Slack != "" {
+		if err := postJSON(hooks.Slack, map[string]string{"text": "safe: " + event.String()}); err != nil {
+			logWarning("unable to notify slack:", err)
+		}
+	}
+
+	if hooks.Webhook != "" {
+		if err := postJSON(hooks.Webhook, event); err != nil {
+			logWarning("unable to notify webhook:", err)
+		}
+	}
+
+	if len(hooks.Email) > 0 {
+		cmd := command(hooks.Email[0], hooks.Email[1:]...)
+		cmd.Stdin = strings.NewReader(event.String() + "\n")
+		if err := runCommand(cmd); err != nil {
+			logWarning("unable to send notification email:", err)
+		}
+	}
+}
+
+// postJSON: post a value as json, returning an error for non 2xx responses
+func postJSON(url string, value interface{}) error {
+	byts, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	client := http.Client{Timeout: notifyTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(byts))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,234 @@
+package safe
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// OrgPolicy: organization wide rules, shared across repositories as a gpg
+// signed yaml file and referenced by each safe.yml
+type OrgPolicy struct {
+	// MinKeyBits is the smallest RSA, DSA or Elgamal key recipients may
+	// have. Elliptic curve keys are always accepted.
+	MinKeyBits int `yaml:"min_key_bits,omitempty"`
+
+	// AdminRecipients must be recipients of every protected file
+	AdminRecipients []string `yaml:"admin_recipients,omitempty"`
+
+	// ForbiddenPlaintextExtensions can only be committed when protected,
+	// eg: .pem
+	ForbiddenPlaintextExtensions []string `yaml:"forbidden_plaintext_extensions,omitempty"`
+
+	// RotationDays is the longest a protected file may go without being
+	// re-encrypted
+	RotationDays int `yaml:"rotation_days,omitempty"`
+}
+
+// weakKeyAlgorithms are the gpg algorithm ids whose key size is checked
+// against MinKeyBits: RSA, Elgamal and DSA
+var weakKeyAlgorithms = map[int]bool{1: true, 2: true, 3: true, 16: true, 17: true, 20: true}
+
+// LoadOrgPolicy: load an org policy, verifying that it was signed by the
+// key with the given fingerprint
+func LoadOrgPolicy(policyFilepath, signer string, config Config) (OrgPolicy, error) {
+	if signer == "" {
+		return OrgPolicy{}, errors.New("org_policy_signer is required to verify " + policyFilepath)
+	}
+
+	byts, err := ioutil.ReadFile(policyFilepath)
+	if err != nil {
+		return OrgPolicy{}, err
+	}
+
+	cmd := gpgCommand(config, "--batch", "--status-fd", "2", "--decrypt")
+	cmd.Stdin = bytes.NewReader(byts)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := runCommand(cmd); err != nil {
+		return OrgPolicy{}, fmt.Errorf("unable to verify the signature of %s: %s", policyFilepath, err)
+	}
+
+	if !signedBy(stderr.String(), signer) {
+		return OrgPolicy{}, fmt.Errorf("%s is not signed by %s", policyFilepath, signer)
+	}
+
+	var policy OrgPolicy
+	if err := yaml.UnmarshalStrict(stdout.Bytes(), &policy); err != nil {
+		return OrgPolicy{}, fmt.Errorf("%s: %s", policyFilepath, err)
+	}
+
+	return policy, nil
+}
+
+// signedBy: return whether gpg's status output reports a good signature by
+// the fingerprint, either of the signing key or of its primary key
+func signedBy(status, fingerprint string) bool {
+	fingerprint = normalizeFingerprint(fingerprint)
+
+	for _, line := range strings.Split(status, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[0] != "[GNUPG:]" || fields[1] != "VALIDSIG" {
+			continue
+		}
+
+		// VALIDSIG <fingerprint> ... <primary key fingerprint>
+		if normalizeFingerprint(fields[2]) == fingerprint || normalizeFingerprint(fields[len(fields)-1]) == fingerprint {
+			return true
+		}
+	}
+
+	return false
+}
+
+// loadConfigOrgPolicy: load the org policy referenced by the config, if any
+func loadConfigOrgPolicy(config Config) (*OrgPolicy, error) {
+	if config.OrgPolicy == "" {
+		return nil, nil
+	}
+
+	policyFilepath := config.OrgPolicy
+	if !filepath.IsAbs(policyFilepath) {
+		policyFilepath = filepath.Join(config.baseDir, policyFilepath)
+	}
+
+	policy, err := LoadOrgPolicy(policyFilepath, config.OrgPolicySigner, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &policy, nil
+}
+
+// checkKeySizes: return an error if any recipient has a key smaller than
+// the org policy allows
+func checkKeySizes(recipients []string, config Config) error {
+	if config.orgPolicy == nil || config.orgPolicy.MinKeyBits == 0 {
+		return nil
+	}
+
+	for _, recipient := range recipients {
+		bits, err := weakestKeyBits(recipient, config)
+		if err != nil {
+			return err
+		}
+
+		if bits > 0 && bits < config.orgPolicy.MinKeyBits {
+			return fmt.Errorf("key for %s is %d bits, the org policy requires at least %d", recipient, bits, config.orgPolicy.MinKeyBits)
+		}
+	}
+
+	return nil
+}
+
+// weakestKeyBits: return the size of the recipient's smallest RSA, DSA or
+// Elgamal key, or 0 if it has none
+func weakestKeyBits(recipient string, config Config) (int, error) {
+	if !isKeyringRecipient(recipient) {
+		return 0, nil
+	}
+
+	keys, err := listGPGKeys(recipient, config)
+	if err != nil {
+		return 0, err
+	}
+
+	weakest := 0
+	for _, key := range keys {
+		for _, candidate := range append([]gpgKey{key}, key.Subkeys...) {
+			// revoked and expired keys can't be encrypted to
+			if candidate.Validity == "r" || candidate.Validity == "e" || !weakKeyAlgorithms[candidate.Algorithm] {
+				continue
+			}
+
+			if weakest == 0 || candidate.Bits < weakest {
+				weakest = candidate.Bits
+			}
+		}
+	}
+
+	return weakest, nil
+}
+
+// orgPolicyViolations: check the protected files against the org policy's
+// key size, forbidden plaintext and rotation rules
+func orgPolicyViolations(filepaths []string, config Config) ([]PolicyViolation, error) {
+	violations := make([]PolicyViolation, 0)
+	policy := config.orgPolicy
+
+	weakKeys := make(map[string]int)
+	for _, file := range filepaths {
+		for _, recipient := range RecipientsFor(file, config) {
+			if policy.MinKeyBits == 0 {
+				break
+			}
+
+			bits, ok := weakKeys[recipient]
+			if !ok {
+				var err error
+				if bits, err = weakestKeyBits(recipient, config); err != nil {
+					return []PolicyViolation(nil), err
+				}
+				weakKeys[recipient] = bits
+			}
+
+			if bits > 0 && bits < policy.MinKeyBits {
+				violations = append(violations, PolicyViolation{
+					Filepath: file,
+					Problem:  fmt.Sprintf("%s has a %d bit key, at least %d is required", recipient, bits, policy.MinKeyBits),
+				})
+			}
+		}
+
+		if policy.RotationDays == 0 {
+			continue
+		}
+
+		revisions, err := History(filepath.Join(config.baseDir, file), config)
+		if err != nil {
+			return []PolicyViolation(nil), err
+		}
+
+		// uncommitted files were only just encrypted
+		if len(revisions) == 0 {
+			continue
+		}
+
+		if age := time.Since(revisions[0].Date); age > time.Duration(policy.RotationDays)*24*time.Hour {
+			violations = append(violations, PolicyViolation{
+				Filepath: file,
+				Problem:  fmt.Sprintf("last rotated %d days ago, it must be rotated every %d days", int(age.Hours()/24), policy.RotationDays),
+			})
+		}
+	}
+
+	if len(policy.ForbiddenPlaintextExtensions) == 0 {
+		return violations, nil
+	}
+
+	tracked, err := gitOutput("-C", config.baseDir, "ls-files")
+	if err != nil {
+		return []PolicyViolation(nil), err
+	}
+
+	for _, file := range strings.Split(strings.TrimSpace(tracked), "\n") {
+		if file == "" || !containsString(policy.ForbiddenPlaintextExtensions, filepath.Ext(file)) {
+			continue
+		}
+
+		violations = append(violations, PolicyViolation{
+			Filepath: file,
+			Problem:  filepath.Ext(file) + " files must not be committed in plaintext",
+		})
+	}
+
+	return violations, nil
+}
@@ -0,0 +1,67 @@
+package safe
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+)
+
+// Output formats for the results of read commands
+const (
+	OutputText = "text"
+	OutputJSON = "json"
+)
+
+// WriteOutput: write the result of a read command, such as List, Status,
+// Verify, Audit, Info or Find, to w. Json is indented, with empty results
+// written as [], while text writes each element of a slice on its own line,
+// formatted by its String method when it has one. Text written to a file is
+// colored when UseColor, eg: stale files from Status in red.
+func WriteOutput(w io.Writer, format string, result interface{}) error {
+	switch format {
+	case OutputJSON:
+		value := reflect.ValueOf(result)
+		if value.Kind() == reflect.Slice && value.IsNil() {
+			result = []interface{}{}
+		}
+
+		byts, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintln(w, string(byts))
+		return err
+	case OutputText, "":
+		file, ok := w.(*os.File)
+		color := ok && UseColor(file)
+
+		value := reflect.ValueOf(result)
+		if value.Kind() != reflect.Slice {
+			_, err := fmt.Fprintln(w, textOutput(result, color))
+			return err
+		}
+
+		for idx := 0; idx < value.Len(); idx++ {
+			if _, err := fmt.Fprintln(w, textOutput(value.Index(idx).Interface(), color)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return errors.New("unknown output format " + format + ", expected text or json")
+}
+
+// textOutput: return the result to write as text, in color when it has a
+// colored format
+func textOutput(result interface{}, color bool) interface{} {
+	if c, ok := result.(colored); ok && color {
+		return c.coloredString()
+	}
+
+	return result
+}
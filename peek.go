@@ -0,0 +1,115 @@
+package safe
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// DefaultPeekChars is how many characters peek shows from each end of a
+// value
+const DefaultPeekChars = 4
+
+// minPeekLength is the shortest value that peek partially reveals
+const minPeekLength = 12
+
+// peekEscaper keeps previews of multiline values on a single line
+var peekEscaper = strings.NewReplacer("\n", "\\n", "\r", "\\r", "\t", "\\t")
+
+// PeekEntry: a partially revealed value, enough to recognize it without
+// disclosing it
+type PeekEntry struct {
+	// Key is the flattened key of the value, or empty for an unstructured
+	// file's whole content
+	Key string `json:"key,omitempty"`
+
+	Preview  string `json:"preview"`
+	Length   int    `json:"length"`
+	Checksum string `json:"checksum"`
+}
+
+// String: format the entry for display
+func (e PeekEntry) String() string {
+	line := fmt.Sprintf("%s (%d chars, sha256 %s)", e.Preview, e.Length, e.Checksum)
+	if e.Key != "" {
+		line = e.Key + ": " + line
+	}
+
+	return line
+}
+
+// Peek: show the first and last chars characters of each value in a
+// protected file, with its length and a checksum, to confirm which value it
+// holds without revealing it. At most a quarter of a value is shown from
+// each end, and short values stay hidden.
+func Peek(targetFilepath string, chars int, config Config) ([]PeekEntry, error) {
+	protected, err := IsProtected(targetFilepath, config)
+	if err != nil {
+		return []PeekEntry(nil), err
+	}
+	if !protected {
+		return []PeekEntry(nil), errors.New(targetFilepath + " is not protected")
+	}
+
+	if chars <= 0 {
+		chars = DefaultPeekChars
+	}
+
+	if err := authorizeDecrypt("peek", targetFilepath, config); err != nil {
+		return []PeekEntry(nil), err
+	}
+
+	byts, err := Decrypt(targetFilepath, config)
+	if os.IsNotExist(err) {
+		return []PeekEntry(nil), errors.New(targetFilepath + " not found")
+	}
+	if err != nil {
+		return []PeekEntry(nil), err
+	}
+
+	format := FormatOf(targetFilepath, config)
+	if format == "" {
+		return []PeekEntry{peekValue("", string(byts), chars)}, nil
+	}
+
+	data, err := parseStructured(byts, format)
+	if err != nil {
+		return []PeekEntry(nil), err
+	}
+
+	env := make(map[string]string)
+	flattenEnv("", data, env)
+
+	entries := make([]PeekEntry, 0, len(env))
+	for _, key := range sortedStringKeys(env) {
+		entries = append(entries, peekValue(key, env[key], chars))
+	}
+
+	return entries, nil
+}
+
+// peekValue: partially reveal a value
+func peekValue(key, value string, chars int) PeekEntry {
+	runes := []rune(value)
+	if max := len(runes) / 4; chars > max {
+		chars = max
+	}
+
+	// values too short to partially reveal, such as pins, are hidden
+	preview := strings.Repeat("*", 8)
+	if len(runes) >= minPeekLength && chars > 0 {
+		preview = peekEscaper.Replace(string(runes[:chars])) + "..." + peekEscaper.Replace(string(runes[len(runes)-chars:]))
+	}
+
+	sum := sha256.Sum256([]byte(value))
+
+	return PeekEntry{
+		Key:      key,
+		Preview:  preview,
+		Length:   len(runes),
+		Checksum: hex.EncodeToString(sum[:])[:12],
+	}
+}
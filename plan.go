@@ -0,0 +1,326 @@
+package safe
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// Operations that can be planned
+const (
+	PlanReencrypt = "reencrypt"
+	PlanRotate    = "rotate"
+	PlanGrant     = "grant"
+	PlanRevoke    = "revoke"
+)
+
+// Plan: the files a bulk recipient change will reencrypt and the commit it
+// will make, recorded so that it can be reviewed and then applied exactly
+type Plan struct {
+	Operation string `json:"operation"`
+
+	// Recipient is granted or revoked, or replaced by NewRecipient when
+	// rotating
+	Recipient    string `json:"recipient,omitempty"`
+	NewRecipient string `json:"new_recipient,omitempty"`
+
+	// ConfigHash is the sha256 of safe.yml when the plan was made. A plan
+	// can only be applied to the config it was made against.
+	ConfigHash string `json:"config_hash"`
+
+	Files   []PlannedFile `json:"files"`
+	Commits []string      `json:"commits"`
+}
+
+// PlannedFile: a file a plan will reencrypt, with its recipients before and
+// after
+type PlannedFile struct {
+	Filepath string   `json:"filepath"`
+	Before   []string `json:"recipients_before"`
+	After    []string `json:"recipients_after"`
+
+	// CiphertextHash is the sha256 of the file when the plan was made
+	CiphertextHash string `json:"ciphertext_hash"`
+}
+
+// MakePlan: plan a reencrypt, rotate, grant or revoke without changing
+// anything. Rotating replaces recipient with newRecipient, which is unused
+// otherwise.
+func MakePlan(operation, recipient, newRecipient string, commit bool, config Config) (Plan, error) {
+	planned, err := plannedConfig(operation, recipient, newRecipient, config)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	configHash, err := fileHash(config.filepath)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	plan := Plan{
+		Operation:    operation,
+		Recipient:    recipient,
+		NewRecipient: newRecipient,
+		ConfigHash:   configHash,
+		Files:        make([]PlannedFile, 0),
+		Commits:      make([]string, 0),
+	}
+
+	filepaths, err := ProtectedFiles(config)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	for _, filepath := range filepaths {
+		if config.Metadata[filepath].Archived {
+			continue
+		}
+
+		file, changed, err := planFile(filepath, operation, config, planned)
+		if err != nil {
+			return Plan{}, err
+		}
+
+		if changed {
+			plan.Files = append(plan.Files, file)
+		}
+	}
+
+	if !commit {
+		return plan, nil
+	}
+
+	switch operation {
+	case PlanReencrypt:
+		if len(plan.Files) > 0 {
+			plan.Commits = append(plan.Commits, commitMessage("reencrypt", fmt.Sprintf("%d files", len(plan.Files))))
+		}
+	case PlanRotate:
+		plan.Commits = append(plan.Commits, commitMessage("rotate-recipient", recipient+" to "+newRecipient))
+	case PlanGrant:
+		plan.Commits = append(plan.Commits, commitMessage("add-recipient", recipient))
+	case PlanRevoke:
+		plan.Commits = append(plan.Commits, commitMessage("remove-recipient", recipient))
+	}
+
+	return plan, nil
+}
+
+// plannedConfig: return the config as it will be after the operation
+func plannedConfig(operation, recipient, newRecipient string, config Config) (Config, error) {
+	switch operation {
+	case PlanReencrypt:
+		return config, nil
+	case PlanRotate:
+		if newRecipient == "" {
+			return config, errors.New("rotate requires a new recipient")
+		}
+		return withRotatedRecipient(recipient, newRecipient, config)
+	case PlanGrant:
+		return withRecipient(recipient, config)
+	case PlanRevoke:
+		return withoutRecipient(recipient, config)
+	}
+
+	return config, errors.New("unknown operation " + operation + ", expected reencrypt, rotate, grant or revoke")
+}
+
+// planFile: return the planned change to a file, and whether the operation
+// changes it at all. Reencrypting only touches files whose recorded
+// encryption is out of date, while the other operations touch the files
+// whose recipients they change.
+func planFile(filepath, operation string, config, planned Config) (PlannedFile, bool, error) {
+	file := PlannedFile{
+		Filepath: filepath,
+		Before:   RecipientsFor(filepath, config),
+		After:    RecipientsFor(filepath, planned),
+	}
+
+	// a one-off encryption is kept until the file is next encrypted
+	if encryption := config.encryptions[filepath]; len(encryption.Recipients) > 0 {
+		file.Before, file.After = encryption.Recipients, encryption.Recipients
+	}
+
+	if operation == PlanReencrypt {
+		file.Before = config.encryptions[filepath].EncryptedTo
+
+		stale, err := staleEncryption(filepath, file.After, config)
+		if err != nil || !stale {
+			return PlannedFile{}, false, err
+		}
+	} else if len(diffRecipients("", file.Before, file.After)) == 0 {
+		return PlannedFile{}, false, nil
+	}
+
+	ciphertextHash, err := fileHash(configPath(filepath, config))
+	if err != nil {
+		return PlannedFile{}, false, err
+	}
+	file.CiphertextHash = ciphertextHash
+
+	return file, true, nil
+}
+
+// staleEncryption: return whether a file's recorded encryption differs from
+// its current recipients and backend, or a gpg ciphertext isn't encrypted
+// to its recipients' current keys
+func staleEncryption(filepath string, recipients []string, config Config) (bool, error) {
+	encryption := config.encryptions[filepath]
+	if encryption.EncryptedAt.IsZero() || len(diffRecipients("", encryption.EncryptedTo, recipients)) > 0 {
+		return true, nil
+	}
+
+	if encryption.EncryptedWith != "" && encryption.EncryptedWith != backendName(filepath, config) {
+		return true, nil
+	}
+
+	backend, err := BackendFor(filepath, config)
+	if err != nil {
+		return false, err
+	}
+
+	if _, ok := backend.(gpgBackend); !ok {
+		return false, nil
+	}
+
+	current, err := encryptedToRecipients(configPath(filepath, config), recipients, config)
+	return !current, err
+}
+
+// ApplyPlan: execute a plan exactly, refusing when safe.yml or any planned
+// file has changed since the plan was made
+func ApplyPlan(plan Plan, config Config) (Result, error) {
+	configHash, err := fileHash(config.filepath)
+	if err != nil {
+		return Result{}, err
+	}
+
+	if configHash != plan.ConfigHash {
+		return Result{}, errors.New(config.filepath + " has changed since the plan was made, make a new plan")
+	}
+
+	planned, err := plannedConfig(plan.Operation, plan.Recipient, plan.NewRecipient, config)
+	if err != nil {
+		return Result{}, err
+	}
+
+	filepaths := make([]string, 0, len(plan.Files))
+	for _, file := range plan.Files {
+		ciphertextHash, err := fileHash(configPath(file.Filepath, config))
+		if err != nil {
+			return Result{}, err
+		}
+
+		if ciphertextHash != file.CiphertextHash {
+			return Result{}, errors.New(file.Filepath + " has changed since the plan was made, make a new plan")
+		}
+
+		// a plan edited by hand can't be trusted to describe what it does
+		after := RecipientsFor(file.Filepath, planned)
+		if encryption := config.encryptions[file.Filepath]; len(encryption.Recipients) > 0 {
+			after = encryption.Recipients
+		}
+		if len(diffRecipients("", after, file.After)) > 0 {
+			return Result{}, fmt.Errorf("plan for %s doesn't match %s, make a new plan", file.Filepath, plan.Operation)
+		}
+
+		filepaths = append(filepaths, file.Filepath)
+	}
+
+	// decrypt everything up front, so a failure leaves the config untouched
+	results, err := DecryptMany(context.Background(), configPaths(filepaths, config), config, DecryptOptions{})
+	if err != nil {
+		return Result{}, err
+	}
+
+	result := Result{Written: configPaths(filepaths, config), Removed: make([]string, 0)}
+	if plan.Operation != PlanReencrypt {
+		if err := WriteConfig(&planned); err != nil {
+			return Result{}, err
+		}
+		result.ConfigChanged = true
+	}
+
+	for _, decrypted := range results {
+		if err := Encrypt(decrypted.Filepath, decrypted.Byts, planned, false, plan.Operation); err != nil {
+			return Result{}, err
+		}
+	}
+
+	if len(plan.Commits) == 0 {
+		return result, nil
+	}
+
+	// everything lands in a single commit, as with the operations themselves
+	gitFilepaths, err := absolutePaths(append(configFilepaths(config), result.Written...))
+	if err != nil {
+		return result, err
+	}
+
+	if err := configVCS(config).Commit(config.baseDir, plan.Commits[0], gitFilepaths); err != nil {
+		return result, err
+	}
+	result.Committed = true
+
+	return result, nil
+}
+
+// LoadPlan: read a plan written by WritePlan
+func LoadPlan(planFilepath string) (Plan, error) {
+	byts, err := ioutil.ReadFile(planFilepath)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	var plan Plan
+	if err := json.Unmarshal(byts, &plan); err != nil {
+		return Plan{}, fmt.Errorf("%s: %s", planFilepath, err)
+	}
+
+	return plan, nil
+}
+
+// WritePlan: write a plan as json, for review and a later ApplyPlan
+func WritePlan(plan Plan, planFilepath string) error {
+	byts, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(planFilepath, append(byts, '\n'), 0644)
+}
+
+// commitMessage: return the message Commit uses for an action
+func commitMessage(action, filepath string) string {
+	return fmt.Sprintf("safe: %s %s", action, trimKnownSuffix(filepath))
+}
+
+// fileHash: return the hex encoded sha256 of a file
+func fileHash(filepath string) (string, error) {
+	byts, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(byts)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// String: describe the plan for review
+func (p Plan) String() string {
+	lines := make([]string, 0, len(p.Files)+len(p.Commits)+1)
+	lines = append(lines, fmt.Sprintf("%s will reencrypt %d files", p.Operation, len(p.Files)))
+	for _, file := range p.Files {
+		lines = append(lines, fmt.Sprintf("  %s: %s -> %s", file.Filepath, strings.Join(file.Before, ", "), strings.Join(file.After, ", ")))
+	}
+	for _, message := range p.Commits {
+		lines = append(lines, "  commit: "+message)
+	}
+
+	return strings.Join(lines, "\n")
+}
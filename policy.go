@@ -0,0 +1,153 @@
+package safe
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Policy: a rule that every protected file matching Path must satisfy
+type Policy struct {
+	// Path is a glob, where a trailing `/**` matches everything beneath a
+	// directory
+	Path string `yaml:"path"`
+
+	RequiredRecipients []string `yaml:"required_recipients,omitempty"`
+}
+
+// PolicyViolation: a protected file that doesn't satisfy a policy
+type PolicyViolation struct {
+	Filepath string
+	Policy   Policy
+	Missing  []string
+
+	// Problem describes a violation of the org policy, which has no Policy
+	Problem string
+}
+
+func (v PolicyViolation) Error() string {
+	if v.Problem != "" {
+		return fmt.Sprintf("%s violates the org policy: %s", v.Filepath, v.Problem)
+	}
+
+	return fmt.Sprintf("%s violates policy for %s: missing required recipients %s", v.Filepath, v.Policy.Path, strings.Join(v.Missing, ", "))
+}
+
+// matchPath: return whether the relative path matches the pattern, where a
+// ** segment matches any number of directories
+func matchPath(pattern, path string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(path, "/"))
+}
+
+// matchSegments: match each segment of a path against the pattern's
+func matchSegments(patterns, segments []string) bool {
+	if len(patterns) == 0 {
+		return len(segments) == 0
+	}
+
+	if patterns[0] == "**" {
+		for idx := 0; idx <= len(segments); idx++ {
+			if matchSegments(patterns[1:], segments[idx:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(segments) == 0 {
+		return false
+	}
+
+	matched, err := filepath.Match(patterns[0], segments[0])
+	return err == nil && matched && matchSegments(patterns[1:], segments[1:])
+}
+
+// isPattern: return whether a Files entry is a glob pattern rather than a
+// path
+func isPattern(entry string) bool {
+	return strings.ContainsAny(entry, "*?[")
+}
+
+// CheckPolicies: return the policy violations for encrypting the file to
+// the recipients
+func CheckPolicies(targetFilepath string, recipients []string, config Config) ([]PolicyViolation, error) {
+	relFilepath, err := relativePath(targetFilepath, config)
+	if err != nil {
+		return []PolicyViolation(nil), err
+	}
+
+	violations := make([]PolicyViolation, 0)
+	for _, policy := range config.Policies {
+		if !matchPath(policy.Path, relFilepath) {
+			continue
+		}
+
+		missing := make([]string, 0)
+		for _, required := range policy.RequiredRecipients {
+			if !containsString(recipients, required) {
+				missing = append(missing, required)
+			}
+		}
+
+		if len(missing) > 0 {
+			violations = append(violations, PolicyViolation{
+				Filepath: relFilepath,
+				Policy:   policy,
+				Missing:  missing,
+			})
+		}
+	}
+
+	if config.orgPolicy != nil {
+		missing := make([]string, 0)
+		for _, admin := range config.orgPolicy.AdminRecipients {
+			if !containsString(recipients, admin) {
+				missing = append(missing, admin)
+			}
+		}
+
+		if len(missing) > 0 {
+			violations = append(violations, PolicyViolation{
+				Filepath: relFilepath,
+				Missing:  missing,
+				Problem:  "missing admin recipients " + strings.Join(missing, ", "),
+			})
+		}
+	}
+
+	return violations, nil
+}
+
+// PolicyCheck: check every active protected file against the config's
+// policies and the org policy
+func PolicyCheck(config Config) ([]PolicyViolation, error) {
+	filepaths, err := ProtectedFiles(config)
+	if err != nil {
+		return []PolicyViolation(nil), err
+	}
+
+	active := make([]string, 0, len(filepaths))
+	violations := make([]PolicyViolation, 0)
+	for _, filepath := range filepaths {
+		if config.Metadata[filepath].Archived {
+			continue
+		}
+		active = append(active, filepath)
+
+		fileViolations, err := CheckPolicies(configPath(filepath, config), RecipientsFor(filepath, config), config)
+		if err != nil {
+			return []PolicyViolation(nil), err
+		}
+		violations = append(violations, fileViolations...)
+	}
+
+	if config.orgPolicy != nil {
+		orgViolations, err := orgPolicyViolations(active, config)
+		if err != nil {
+			return []PolicyViolation(nil), err
+		}
+		violations = append(violations, orgViolations...)
+	}
+
+	return violations, nil
+}
@@ -0,0 +1,31 @@
+package safe
+
+import (
+	"errors"
+)
+
+// ProfileEnvVar selects a profile, unless one is selected explicitly
+const ProfileEnvVar = "SAFE_PROFILE"
+
+// Profile: a named set of recipients and overrides, eg: a stricter set for
+// prod secrets than for dev ones. A profile without recipients falls back to
+// the top level recipients.
+type Profile struct {
+	Recipients []string            `yaml:"recipients,omitempty"`
+	Overrides  map[string][]string `yaml:"overrides,omitempty"`
+}
+
+// WithProfile: return the config with the named profile selected
+func WithProfile(name string, config Config) (Config, error) {
+	if _, ok := config.Profiles[name]; !ok {
+		return Config{}, errors.New("unknown profile " + name)
+	}
+
+	config.profile = name
+	return config, nil
+}
+
+// ActiveProfile: return the name of the selected profile, if any
+func ActiveProfile(config Config) string {
+	return config.profile
+}
@@ -0,0 +1,101 @@
+package safe
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// progressBarWidth is the number of cells in a progress bar
+const progressBarWidth = 20
+
+// activeProgress is the progress bar drawn on the terminal, if any, which
+// other diagnostics are written around
+var activeProgress *progress
+
+// progress: reports how far a bulk operation has got over its files, as a
+// bar on terminals and otherwise a line per file, eg: for CI logs
+type progress struct {
+	mu      sync.Mutex
+	action  string
+	total   int
+	done    int
+	current string
+	bar     bool
+}
+
+// newProgress: start reporting the progress of an action over total files,
+// which must be finished once the files are done
+func newProgress(action string, total int) *progress {
+	p := &progress{action: action, total: total}
+
+	if file, ok := logOutput.(*os.File); ok && logLevel >= LogNormal && isTerminal(file) && activeProgress == nil {
+		p.bar = true
+		activeProgress = p
+	}
+
+	return p
+}
+
+// advance: report that another file is done, or with files done one at a
+// time, being worked on. It may be called concurrently.
+func (p *progress) advance(filepath string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.done++
+	p.current = filepath
+
+	if !p.bar {
+		fmt.Fprintf(logWriter(LogNormal), "%s %d/%d: %s\n", p.action, p.done, p.total, filepath)
+		return
+	}
+
+	p.render()
+}
+
+// render: draw the bar over the current line, called with the lock held
+func (p *progress) render() {
+	filled := progressBarWidth
+	if p.total > 0 {
+		filled = progressBarWidth * p.done / p.total
+	}
+
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+	fmt.Fprintf(logOutput, "\r\x1b[K%s [%s] %d/%d %s", p.action, bar, p.done, p.total, p.current)
+}
+
+// finish: stop reporting progress, clearing the bar
+func (p *progress) finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.bar {
+		return
+	}
+
+	fmt.Fprint(logOutput, "\r\x1b[K")
+	p.bar = false
+	activeProgress = nil
+}
+
+// progressWriter: writes diagnostics above the progress bar, redrawing it
+// after each complete line
+type progressWriter struct {
+	p *progress
+}
+
+func (w progressWriter) Write(byts []byte) (int, error) {
+	w.p.mu.Lock()
+	defer w.p.mu.Unlock()
+
+	fmt.Fprint(logOutput, "\r\x1b[K")
+	n, err := logOutput.Write(byts)
+	if err == nil && w.p.bar && bytes.HasSuffix(byts, []byte("\n")) {
+		w.p.render()
+	}
+
+	return n, err
+}
@@ -0,0 +1,126 @@
+package safe
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// stdin is shared between prompts, so that buffered input isn't lost
+var stdin = bufio.NewReader(os.Stdin)
+
+// Confirm: ask the user a yes/no question on the terminal, defaulting to no.
+// In batch mode the answer is always yes.
+func Confirm(question string) (bool, error) {
+	if batch {
+		return true, nil
+	}
+
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+
+	answer, err := stdin.ReadString('\n')
+	if err != nil && answer == "" {
+		return false, err
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// confirmDestructive: ask the user to confirm an operation that deletes
+// data, unless forced, in batch mode or not attached to a terminal, eg: in
+// scripts
+func confirmDestructive(question string, opts Options) (bool, error) {
+	if opts.Force || batch || !isTerminal(os.Stdin) {
+		return true, nil
+	}
+
+	return Confirm(question)
+}
+
+// Prompt: ask the user a question on the terminal, returning their answer
+func Prompt(question string) (string, error) {
+	if err := checkInteractive(strings.TrimSuffix(question, ":")); err != nil {
+		return "", err
+	}
+
+	fmt.Fprintf(os.Stderr, "%s ", question)
+
+	answer, err := stdin.ReadString('\n')
+	if err != nil && answer == "" {
+		return "", err
+	}
+
+	return strings.TrimSpace(answer), nil
+}
+
+// PromptSecret: ask the user for a secret on the terminal, without echoing
+// it, and asking again to confirm it. Input that isn't from a terminal,
+// such as a pipe, is read as is.
+func PromptSecret(question string) (string, error) {
+	if !isTerminal(os.Stdin) {
+		answer, err := stdin.ReadString('\n')
+		if err != nil && answer == "" {
+			return "", err
+		}
+
+		return strings.TrimRight(answer, "\r\n"), nil
+	}
+
+	if err := checkInteractive(strings.TrimSuffix(question, ":")); err != nil {
+		return "", err
+	}
+
+	secret, err := readSecret(question)
+	if err != nil {
+		return "", err
+	}
+
+	confirmation, err := readSecret("Confirm " + strings.ToLower(question[:1]) + question[1:])
+	if err != nil {
+		return "", err
+	}
+
+	if secret != confirmation {
+		return "", errors.New("the values entered don't match")
+	}
+
+	return secret, nil
+}
+
+// readSecret: read a line from the terminal with echo disabled
+func readSecret(question string) (string, error) {
+	fmt.Fprintf(os.Stderr, "%s ", question)
+
+	restoreEcho, err := disableEcho(os.Stdin)
+	if err != nil {
+		return "", err
+	}
+
+	answer, err := stdin.ReadString('\n')
+	restoreErr := restoreEcho()
+
+	// the newline typed by the user wasn't echoed
+	fmt.Fprintln(os.Stderr)
+
+	if err != nil && answer == "" {
+		return "", err
+	}
+	if restoreErr != nil {
+		return "", restoreErr
+	}
+
+	return strings.TrimRight(answer, "\r\n"), nil
+}
+
+// isTerminal: return whether the file is a terminal
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,98 @@
+package safe
+
+import (
+	"errors"
+	"os"
+	"strings"
+)
+
+// PromptSet: set a single key in a structured protected file to a value
+// read from the terminal with echo disabled, so that the secret never ends
+// up in shell history, the clipboard or an editor's swap files. Nested keys
+// of yml and json files are separated by dots, eg: db.password. The
+// file is created if it isn't protected yet.
+func PromptSet(targetFilepath, key string, config Config, commit bool) error {
+	format := FormatOf(targetFilepath, config)
+	if format == "" || format == "toml" {
+		return errors.New(targetFilepath + " isn't a structured file, only yml, json and env files have keys")
+	}
+
+	if key == "" {
+		return errors.New("no key given")
+	}
+
+	if err := CheckLock(targetFilepath, config); err != nil {
+		return err
+	}
+
+	if err := authorizeDecrypt("edit", targetFilepath, config); err != nil {
+		return err
+	}
+
+	data := make(map[string]interface{})
+	byts, err := Decrypt(targetFilepath, config)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if data, err = parseStructured(byts, format); err != nil {
+			return err
+		}
+	}
+
+	value, err := PromptSecret("Value for " + key + ":")
+	if err != nil {
+		return err
+	}
+	if value == "" {
+		return errors.New("no value entered for " + key)
+	}
+
+	path := []string{key}
+	if format != "env" {
+		path = strings.Split(key, ".")
+	}
+
+	if err := setNestedValue(data, path, value); err != nil {
+		return err
+	}
+
+	if byts, err = encodeStructured(data, format); err != nil {
+		return err
+	}
+
+	if err := Encrypt(targetFilepath, byts, config, commit, "set"); err != nil {
+		return err
+	}
+
+	notify("set", []string{targetFilepath}, key, config)
+	return nil
+}
+
+// setNestedValue: set the value at a path of keys, creating intermediate
+// maps as needed
+func setNestedValue(data map[string]interface{}, path []string, value string) error {
+	for i, key := range path {
+		if key == "" {
+			return errors.New("invalid key " + strings.Join(path, "."))
+		}
+
+		if i == len(path)-1 {
+			data[key] = value
+			return nil
+		}
+
+		switch nested := data[key].(type) {
+		case map[string]interface{}:
+			data = nested
+		case nil:
+			created := make(map[string]interface{})
+			data[key] = created
+			data = created
+		default:
+			return errors.New(strings.Join(path[:i+1], ".") + " isn't a map, unable to set " + strings.Join(path, "."))
+		}
+	}
+
+	return nil
+}
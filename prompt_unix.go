@@ -0,0 +1,29 @@
+//go:build !windows
+
+package safe
+
+import (
+	"os"
+	"os/exec"
+)
+
+// disableEcho: stop the terminal echoing what's typed, returning a function
+// that turns echo back on
+func disableEcho(file *os.File) (func() error, error) {
+	if err := stty(file, "-echo"); err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		return stty(file, "echo")
+	}, nil
+}
+
+// stty: change the settings of the terminal. stty is always run on this
+// machine, rather than by the runner, as it has to act on the local
+// terminal the user is typing into.
+func stty(terminal *os.File, arg string) error {
+	cmd := exec.Command("stty", arg)
+	cmd.Stdin = terminal
+	return cmd.Run()
+}
@@ -0,0 +1,35 @@
+//go:build windows
+
+package safe
+
+import (
+	"os"
+	"syscall"
+)
+
+// enableEchoInput is the console mode flag that echoes typed characters
+const enableEchoInput = 0x0004
+
+var setConsoleMode = syscall.NewLazyDLL("kernel32.dll").NewProc("SetConsoleMode")
+
+// disableEcho: stop the console echoing what's typed, returning a function
+// that restores its previous mode
+func disableEcho(file *os.File) (func() error, error) {
+	handle := syscall.Handle(file.Fd())
+
+	var mode uint32
+	if err := syscall.GetConsoleMode(handle, &mode); err != nil {
+		return nil, err
+	}
+
+	if ok, _, err := setConsoleMode.Call(uintptr(handle), uintptr(mode&^enableEchoInput)); ok == 0 {
+		return nil, err
+	}
+
+	return func() error {
+		if ok, _, err := setConsoleMode.Call(uintptr(handle), uintptr(mode)); ok == 0 {
+			return err
+		}
+		return nil
+	}, nil
+}
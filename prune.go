@@ -0,0 +1,112 @@
+package safe
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// PruneResult: the stale entries removed, or in a dry run that would be
+// removed, from the config by Prune
+type PruneResult struct {
+	Result
+
+	// Files are entries whose ciphertext no longer exists
+	Files []string
+
+	// Overrides are overrides, including those of profiles, and backends
+	// for files that aren't protected
+	Overrides []string
+}
+
+// Prune: remove the files whose ciphertext no longer exists from the config,
+// along with the overrides and backends of files that aren't protected, as
+// are left behind when files are deleted or moved without safe. Patterns in
+// files are kept, as they may match files in future.
+func Prune(config Config, opts Options) (PruneResult, error) {
+	result := PruneResult{Files: make([]string, 0), Overrides: make([]string, 0)}
+
+	own := ownConfig(config)
+	for _, file := range own.Files {
+		if isPattern(file) || containsString(result.Files, file) {
+			continue
+		}
+
+		if _, err := os.Stat(configPath(file, config)); os.IsNotExist(err) {
+			result.Files = append(result.Files, file)
+		}
+	}
+
+	pruned := config
+	pruned.Files = make([]string, 0, len(config.Files))
+	for _, file := range config.Files {
+		if !containsString(result.Files, file) {
+			pruned.Files = append(pruned.Files, file)
+		}
+	}
+
+	orphaned := func(file string) (bool, error) {
+		protected, err := IsProtected(configPath(file, pruned), pruned)
+		return !protected, err
+	}
+
+	entries := make([]string, 0, len(own.Overrides)+len(config.Backends))
+	for file := range own.Overrides {
+		entries = append(entries, file)
+	}
+	for file := range config.Backends {
+		entries = append(entries, file)
+	}
+	for _, profile := range config.Profiles {
+		for file := range profile.Overrides {
+			entries = append(entries, file)
+		}
+	}
+
+	for _, file := range uniqueStrings(entries) {
+		isOrphan, err := orphaned(file)
+		if err != nil {
+			return PruneResult{}, err
+		}
+
+		if isOrphan {
+			result.Overrides = append(result.Overrides, file)
+		}
+	}
+	sort.Strings(result.Overrides)
+
+	if len(result.Files) == 0 && len(result.Overrides) == 0 {
+		return result, nil
+	}
+
+	result.ConfigChanged = true
+	result.Committed = opts.Commit
+	if opts.DryRun {
+		return result, nil
+	}
+
+	for _, file := range result.Files {
+		untrack(file, &config)
+	}
+
+	for _, file := range result.Overrides {
+		delete(config.Overrides, file)
+		delete(config.Backends, file)
+		for _, profile := range config.Profiles {
+			delete(profile.Overrides, file)
+		}
+	}
+
+	if err := WriteConfig(&config); err != nil {
+		return PruneResult{}, err
+	}
+
+	if opts.Commit {
+		detail := fmt.Sprintf("%d files and %d overrides", len(result.Files), len(result.Overrides))
+		if err := Commit("prune", detail, configFilepaths(config), config); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
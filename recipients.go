@@ -0,0 +1,749 @@
+package safe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ListRecipients: return the default recipients that files are encrypted to
+func ListRecipients(config Config) []string {
+	recipients := make([]string, len(config.Recipients))
+	copy(recipients, config.Recipients)
+	return recipients
+}
+
+// AddRecipient: add a default recipient to the config, optionally
+// reencrypting all files so the recipient can read them
+func AddRecipient(recipient string, reencrypt bool, config Config, opts Options) (Result, error) {
+	config, err := withRecipient(recipient, config)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return updateRecipients("add-recipient", recipient, reencrypt, config, opts)
+}
+
+// withRecipient: return the config with a default recipient added
+func withRecipient(recipient string, config Config) (Config, error) {
+	if containsString(config.Recipients, recipient) {
+		return config, errors.New(recipient + " is already a recipient")
+	}
+
+	config.Recipients = append(ListRecipients(config), recipient)
+	return config, nil
+}
+
+// RemoveRecipient: remove a default recipient from the config, optionally
+// reencrypting all files so the recipient can no longer read them
+func RemoveRecipient(recipient string, reencrypt bool, config Config, opts Options) (Result, error) {
+	revoked, err := withoutRecipient(recipient, config)
+	if err != nil {
+		return Result{}, err
+	}
+
+	affected, err := filesWithRecipient(recipient, config)
+	if err != nil {
+		return Result{}, err
+	}
+
+	result, err := updateRecipients("remove-recipient", recipient, reencrypt, revoked, opts)
+	if err != nil || opts.DryRun {
+		return result, err
+	}
+
+	notify("revoke", configPaths(revokedFiles(recipient, affected, revoked), config), "revoked "+recipient, config)
+	return result, nil
+}
+
+// withoutRecipient: return the config with a recipient removed from the
+// default recipients, the overrides, and those of each profile
+func withoutRecipient(recipient string, config Config) (Config, error) {
+	recipients, removed := removeString(config.Recipients, recipient)
+	if removed && len(recipients) == 0 {
+		return config, errors.New("unable to remove the last recipient")
+	}
+	if removed {
+		config.Recipients = recipients
+	}
+
+	overrides, removedFromOverrides, err := withoutOverrideRecipient(config.Overrides, recipient)
+	if err != nil {
+		return config, err
+	}
+	removed = removed || removedFromOverrides
+
+	profiles := make(map[string]Profile, len(config.Profiles))
+	for name, profile := range config.Profiles {
+		profileRecipients, removedFromProfile := removeString(profile.Recipients, recipient)
+		if removedFromProfile && len(profileRecipients) == 0 {
+			return config, errors.New("unable to remove the last recipient of profile " + name)
+		}
+		if removedFromProfile {
+			profile.Recipients = profileRecipients
+		}
+
+		profileOverrides, removedFromProfileOverrides, err := withoutOverrideRecipient(profile.Overrides, recipient)
+		if err != nil {
+			return config, errors.New(err.Error() + " in profile " + name)
+		}
+		profile.Overrides = profileOverrides
+
+		removed = removed || removedFromProfile || removedFromProfileOverrides
+		profiles[name] = profile
+	}
+
+	if !removed {
+		return config, errors.New(recipient + " is not a recipient")
+	}
+
+	config.Overrides = overrides
+	if config.Profiles != nil {
+		config.Profiles = profiles
+	}
+	return config, nil
+}
+
+// withoutOverrideRecipient: return a copy of the overrides with a recipient
+// removed, and whether any override listed them
+func withoutOverrideRecipient(overrides map[string][]string, recipient string) (map[string][]string, bool, error) {
+	if overrides == nil {
+		return overrides, false, nil
+	}
+
+	removed := false
+	remaining := make(map[string][]string, len(overrides))
+	for filepath, recipients := range overrides {
+		overrideRecipients, removedFromOverride := removeString(recipients, recipient)
+		if removedFromOverride && len(overrideRecipients) == 0 {
+			return overrides, false, errors.New("unable to remove the last recipient of " + filepath)
+		}
+
+		removed = removed || removedFromOverride
+		if removedFromOverride {
+			remaining[filepath] = overrideRecipients
+		} else {
+			remaining[filepath] = recipients
+		}
+	}
+
+	return remaining, removed, nil
+}
+
+// removeString: return the values without the value, and whether it was
+// there to remove
+func removeString(values []string, value string) ([]string, bool) {
+	remaining := make([]string, 0, len(values))
+	for _, existing := range values {
+		if existing != value {
+			remaining = append(remaining, existing)
+		}
+	}
+
+	return remaining, len(remaining) != len(values)
+}
+
+// updateRecipients: write a recipient change to disk, reencrypting and
+// committing everything in a single commit when requested
+func updateRecipients(action, recipient string, reencrypt bool, config Config, opts Options) (Result, error) {
+	result := Result{Written: make([]string, 0), Removed: make([]string, 0), ConfigChanged: true, Committed: opts.Commit}
+
+	// a dry run lists the files that the changed config would reencrypt
+	if opts.DryRun {
+		if !reencrypt {
+			return result, nil
+		}
+
+		reencrypted, err := ReencryptAll(config, Options{DryRun: true})
+		result.Written = reencrypted.Written
+		return result, err
+	}
+
+	if err := WriteConfig(&config); err != nil {
+		return Result{}, err
+	}
+
+	if reencrypt {
+		// NOTE: the reencrypted files aren't committed individually, so that
+		// the config change and all of them land in a single commit
+		reencrypted, err := ReencryptAll(config, Options{})
+		if err != nil {
+			return Result{}, err
+		}
+		result.Written = reencrypted.Written
+	}
+
+	if !opts.Commit {
+		return result, nil
+	}
+
+	return result, Commit(action, recipient, append(configFilepaths(config), result.Written...), config)
+}
+
+// RecipientsFor: return the effective recipients for a file, taking
+// overrides, the selected profile and active delegations into account
+func RecipientsFor(filepath string, config Config) []string {
+	return delegatedRecipients(filepath, configuredRecipients(filepath, config), config)
+}
+
+// configuredRecipients: return the recipients configured for a file, taking
+// overrides and the selected profile into account
+func configuredRecipients(filepath string, config Config) []string {
+	if profile, ok := config.Profiles[config.profile]; ok {
+		if recipients, ok := profile.Overrides[filepath]; ok {
+			return recipients
+		}
+
+		if len(profile.Recipients) > 0 {
+			return profile.Recipients
+		}
+	}
+
+	if recipients, ok := config.Overrides[filepath]; ok {
+		return recipients
+	}
+
+	return config.Recipients
+}
+
+// RotateRecipient: replace a recipient with another everywhere in the
+// config, including overrides, and reencrypt every affected file in a single
+// commit
+func RotateRecipient(oldRecipient, newRecipient string, config Config, opts Options) (Result, error) {
+	affected, err := filesWithRecipient(oldRecipient, config)
+	if err != nil {
+		return Result{}, err
+	}
+
+	rotated, err := withRotatedRecipient(oldRecipient, newRecipient, config)
+	if err != nil {
+		return Result{}, err
+	}
+
+	result := Result{
+		Written:       configPaths(affected, config),
+		Removed:       make([]string, 0),
+		ConfigChanged: true,
+		Committed:     opts.Commit,
+	}
+	if opts.DryRun {
+		return result, nil
+	}
+
+	// decrypt everything up front, so a failure leaves the config untouched
+	results, err := DecryptMany(context.Background(), configPaths(affected, config), config, DecryptOptions{})
+	if err != nil {
+		return Result{}, err
+	}
+
+	config = rotated
+	if err := WriteConfig(&config); err != nil {
+		return Result{}, err
+	}
+
+	for _, decrypted := range results {
+		if err := Encrypt(decrypted.Filepath, decrypted.Byts, config, false, "rotate-recipient"); err != nil {
+			return Result{}, err
+		}
+	}
+
+	if opts.Commit {
+		if err := Commit("rotate-recipient", oldRecipient+" to "+newRecipient, append(configFilepaths(config), result.Written...), config); err != nil {
+			return result, err
+		}
+	}
+
+	notify("rotate", result.Written, "rotated "+oldRecipient+" to "+newRecipient, config)
+	return result, nil
+}
+
+// filesWithRecipient: return the active protected files that the recipient
+// is a recipient of
+func filesWithRecipient(recipient string, config Config) ([]string, error) {
+	filepaths, err := ProtectedFiles(config)
+	if err != nil {
+		return []string(nil), err
+	}
+
+	affected := make([]string, 0)
+	for _, filepath := range filepaths {
+		if config.Metadata[filepath].Archived {
+			continue
+		}
+
+		if containsString(RecipientsFor(filepath, config), recipient) {
+			affected = append(affected, filepath)
+		}
+	}
+
+	return affected, nil
+}
+
+// revokedFiles: return the files, of those encrypted to the recipient, that
+// no longer list them in the changed config
+func revokedFiles(recipient string, filepaths []string, config Config) []string {
+	revoked := make([]string, 0, len(filepaths))
+	for _, filepath := range filepaths {
+		if !containsString(RecipientsFor(filepath, config), recipient) {
+			revoked = append(revoked, filepath)
+		}
+	}
+
+	return revoked
+}
+
+// withRotatedRecipient: return the config with a recipient replaced by
+// another everywhere, including overrides and profiles
+func withRotatedRecipient(oldRecipient, newRecipient string, config Config) (Config, error) {
+	inConfig := containsString(config.Recipients, oldRecipient)
+	for _, recipients := range config.Overrides {
+		inConfig = inConfig || containsString(recipients, oldRecipient)
+	}
+	for _, profile := range config.Profiles {
+		inConfig = inConfig || containsString(profile.Recipients, oldRecipient)
+		for _, recipients := range profile.Overrides {
+			inConfig = inConfig || containsString(recipients, oldRecipient)
+		}
+	}
+
+	if !inConfig {
+		return config, errors.New(oldRecipient + " is not a recipient")
+	}
+
+	config.Recipients = replaceString(config.Recipients, oldRecipient, newRecipient)
+	config.Overrides = withReplacedOverrides(config.Overrides, oldRecipient, newRecipient)
+
+	if config.Profiles != nil {
+		profiles := make(map[string]Profile, len(config.Profiles))
+		for name, profile := range config.Profiles {
+			if profile.Recipients != nil {
+				profile.Recipients = replaceString(profile.Recipients, oldRecipient, newRecipient)
+			}
+			if profile.Overrides != nil {
+				profile.Overrides = withReplacedOverrides(profile.Overrides, oldRecipient, newRecipient)
+			}
+			profiles[name] = profile
+		}
+		config.Profiles = profiles
+	}
+
+	return config, nil
+}
+
+// withReplacedOverrides: return a copy of the overrides with a recipient
+// replaced by another
+func withReplacedOverrides(overrides map[string][]string, oldRecipient, newRecipient string) map[string][]string {
+	replaced := make(map[string][]string, len(overrides))
+	for filepath, recipients := range overrides {
+		replaced[filepath] = replaceString(recipients, oldRecipient, newRecipient)
+	}
+
+	return replaced
+}
+
+// checkMinRecipients: return an error when there are fewer recipients than
+// the config's minimum
+func checkMinRecipients(name string, recipients []string, config Config) error {
+	count := len(uniqueStrings(recipients))
+	if count >= config.MinRecipients {
+		return nil
+	}
+
+	return fmt.Errorf("Invalid config, %s has %d recipients but min_recipients is %d", name, count, config.MinRecipients)
+}
+
+// containsString: return whether the value is in the slice
+func containsString(values []string, value string) bool {
+	for _, existing := range values {
+		if existing == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// replaceString: return a copy of the slice with old replaced by new,
+// dropping duplicates of new
+func replaceString(values []string, old, new string) []string {
+	replaced := make([]string, 0, len(values))
+	for _, value := range values {
+		if value == old {
+			value = new
+		}
+
+		if !containsString(replaced, value) {
+			replaced = append(replaced, value)
+		}
+	}
+
+	return replaced
+}
+
+// ProbeResult: the outcome of a test encryption to a single recipient
+type ProbeResult struct {
+	Recipient string
+	Err       error
+}
+
+// ProbeRecipients: perform a test encryption to each gpg recipient in the
+// config individually, reporting which specific keys are unusable
+func ProbeRecipients(config Config) []ProbeResult {
+	backendName := config.Backend
+	if backendName == "" {
+		backendName = defaultBackendName(config)
+	}
+
+	recipients := make([]string, 0)
+	if backendName == "gpg" {
+		recipients = append(recipients, config.Recipients...)
+	}
+
+	for filepath, overrides := range config.Overrides {
+		backend, err := BackendFor(filepath, config)
+		if err != nil {
+			continue
+		}
+
+		if _, ok := backend.(gpgBackend); !ok {
+			continue
+		}
+		recipients = append(recipients, overrides...)
+	}
+
+	results := make([]ProbeResult, 0, len(recipients))
+	for _, recipient := range uniqueStrings(recipients) {
+		results = append(results, ProbeResult{
+			Recipient: recipient,
+			Err:       probeGPGRecipient(recipient, config),
+		})
+	}
+
+	return results
+}
+
+// uniqueStrings: return the sorted, de-duplicated values
+func uniqueStrings(values []string) []string {
+	unique := make([]string, 0, len(values))
+	for _, value := range values {
+		if !containsString(unique, value) {
+			unique = append(unique, value)
+		}
+	}
+	sort.Strings(unique)
+
+	return unique
+}
+
+// PinnedRecipients: verify that every pinned recipient's key in the local
+// keyring matches its pinned fingerprint, returning the recipients with
+// pinned entries replaced by their fingerprint so gpg can't pick another key
+func PinnedRecipients(recipients []string, config Config) ([]string, error) {
+	pinned := make([]string, 0, len(recipients))
+	for _, recipient := range recipients {
+		fingerprint, ok := config.Fingerprints[recipient]
+		if !ok {
+			pinned = append(pinned, recipient)
+			continue
+		}
+		fingerprint = normalizeFingerprint(fingerprint)
+
+		keys, err := listGPGKeys(recipient, config)
+		if err != nil {
+			return []string(nil), err
+		}
+
+		if len(keys) == 0 {
+			return []string(nil), errors.New("no key found for pinned recipient " + recipient)
+		}
+
+		for _, key := range keys {
+			if normalizeFingerprint(key.Fingerprint) != fingerprint {
+				return []string(nil), fmt.Errorf("fingerprint mismatch for %s: pinned %s, keyring has %s", recipient, fingerprint, key.Fingerprint)
+			}
+		}
+
+		pinned = append(pinned, fingerprint)
+	}
+
+	return pinned, nil
+}
+
+// deniedKeyIDs: return the key ids of every denied recipient, mapped to the
+// recipient
+func deniedKeyIDs(config Config) (map[string]string, error) {
+	return keyIDsOf(config.DeniedRecipients, config)
+}
+
+// keyIDsOf: return the key ids of each recipient, mapped to the recipient
+func keyIDsOf(recipients []string, config Config) (map[string]string, error) {
+	keyIDs := make(map[string]string)
+	for _, recipient := range recipients {
+		recipientKeyIDs, err := recipientKeyIDs(recipient, config)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, keyID := range recipientKeyIDs {
+			keyIDs[keyID] = recipient
+		}
+	}
+
+	return keyIDs, nil
+}
+
+// checkCIRecipients: return an error when a file that isn't tagged ci is
+// being encrypted to a ci recipient, or any of their keys
+func checkCIRecipients(targetFilepath string, recipients []string, config Config) error {
+	if len(config.CIRecipients) == 0 {
+		return nil
+	}
+
+	relFilepath, err := relativePath(targetFilepath, config)
+	if err != nil {
+		return err
+	}
+
+	if config.Metadata[relFilepath].CI {
+		return nil
+	}
+
+	ciKeyIDs, err := keyIDsOf(config.CIRecipients, config)
+	if err != nil {
+		return err
+	}
+
+	for _, recipient := range recipients {
+		if containsString(config.CIRecipients, recipient) {
+			return errors.New(relFilepath + " isn't tagged ci, so can't be encrypted to ci recipient " + recipient)
+		}
+
+		if !isKeyringRecipient(recipient) {
+			continue
+		}
+
+		keyIDs, err := recipientKeyIDs(recipient, config)
+		if err != nil {
+			return err
+		}
+
+		for _, keyID := range keyIDs {
+			if ciRecipient, ok := ciKeyIDs[keyID]; ok {
+				return fmt.Errorf("%s isn't tagged ci, so can't be encrypted to %s, which uses the key of ci recipient %s", relFilepath, recipient, ciRecipient)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkDeniedRecipients: return an error when any recipient, or any of
+// their keys, has been denied
+func checkDeniedRecipients(recipients []string, config Config) error {
+	if len(config.DeniedRecipients) == 0 {
+		return nil
+	}
+
+	denied, err := deniedKeyIDs(config)
+	if err != nil {
+		return err
+	}
+
+	for _, recipient := range recipients {
+		if containsString(config.DeniedRecipients, recipient) {
+			return errors.New(recipient + " is a denied recipient")
+		}
+
+		if !isKeyringRecipient(recipient) {
+			continue
+		}
+
+		keyIDs, err := recipientKeyIDs(recipient, config)
+		if err != nil {
+			return err
+		}
+
+		for _, keyID := range keyIDs {
+			if deniedRecipient, ok := denied[keyID]; ok {
+				return fmt.Errorf("%s uses the key of denied recipient %s", recipient, deniedRecipient)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Coverage: the protected files a recipient's keys can, and cannot, decrypt
+type Coverage struct {
+	Recipient  string
+	Readable   []string
+	Unreadable []string
+}
+
+// RecipientCoverage: inspect the ciphertext of every active gpg protected
+// file, reporting for each recipient in the config which files are actually
+// encrypted to one of their keys
+func RecipientCoverage(config Config) ([]Coverage, error) {
+	recipients := append([]string{}, config.Recipients...)
+	for _, overrides := range config.Overrides {
+		recipients = append(recipients, overrides...)
+	}
+
+	coverage := make([]Coverage, 0)
+	recipientKeys := make(map[string][]string)
+	for _, recipient := range uniqueStrings(recipients) {
+		// remote and file recipients aren't in the local keyring, so their
+		// keys can't be matched against the ciphertext
+		if !isKeyringRecipient(recipient) {
+			continue
+		}
+
+		keyIDs, err := recipientKeyIDs(recipient, config)
+		if err != nil {
+			return []Coverage(nil), err
+		}
+
+		recipientKeys[recipient] = keyIDs
+		coverage = append(coverage, Coverage{
+			Recipient:  recipient,
+			Readable:   make([]string, 0),
+			Unreadable: make([]string, 0),
+		})
+	}
+
+	filepaths, err := ProtectedFiles(config)
+	if err != nil {
+		return []Coverage(nil), err
+	}
+
+	for _, filepath := range filepaths {
+		if config.Metadata[filepath].Archived {
+			continue
+		}
+
+		backend, err := BackendFor(filepath, config)
+		if err != nil {
+			return []Coverage(nil), err
+		}
+
+		if _, ok := backend.(gpgBackend); !ok {
+			continue
+		}
+
+		// a file whose packets can't be listed is reported as unreadable by
+		// everyone
+		keyIDs, _ := ciphertextKeyIDs(configPath(filepath, config), config)
+
+		for idx := range coverage {
+			readable := false
+			for _, keyID := range recipientKeys[coverage[idx].Recipient] {
+				if containsString(keyIDs, keyID) {
+					readable = true
+					break
+				}
+			}
+
+			if readable {
+				coverage[idx].Readable = append(coverage[idx].Readable, filepath)
+			} else {
+				coverage[idx].Unreadable = append(coverage[idx].Unreadable, filepath)
+			}
+		}
+	}
+
+	return coverage, nil
+}
+
+// Access: whether a key can decrypt a protected file
+type Access struct {
+	Filepath string
+
+	// Configured is whether the config grants the key access, while
+	// Encrypted is whether the ciphertext is actually encrypted to it, which
+	// is only known when Verified
+	Configured bool
+	Encrypted  bool
+	Verified   bool
+}
+
+// TestAccess: report which active protected files a key can decrypt based
+// on the config, optionally verifying against the ciphertext of gpg files
+func TestAccess(keyID string, verify bool, config Config) ([]Access, error) {
+	targetKeyIDs, err := recipientKeyIDs(keyID, config)
+	if err != nil {
+		return []Access(nil), err
+	}
+
+	matches := func(keyIDs []string) bool {
+		for _, keyID := range keyIDs {
+			if containsString(targetKeyIDs, keyID) {
+				return true
+			}
+		}
+		return false
+	}
+
+	filepaths, err := ProtectedFiles(config)
+	if err != nil {
+		return []Access(nil), err
+	}
+
+	accesses := make([]Access, 0, len(filepaths))
+	for _, filepath := range filepaths {
+		metadata := config.Metadata[filepath]
+		if metadata.Archived {
+			continue
+		}
+
+		// a one-off encryption overrides the configured recipients until
+		// the file is next encrypted
+		recipients := RecipientsFor(filepath, config)
+		if encryption := config.encryptions[filepath]; len(encryption.Recipients) > 0 {
+			recipients = encryption.Recipients
+		}
+
+		access := Access{Filepath: filepath}
+		for _, recipient := range recipients {
+			if recipient == keyID {
+				access.Configured = true
+				break
+			}
+
+			if !isKeyringRecipient(recipient) {
+				continue
+			}
+
+			keyIDs, err := recipientKeyIDs(recipient, config)
+			if err != nil {
+				return []Access(nil), err
+			}
+
+			if matches(keyIDs) {
+				access.Configured = true
+				break
+			}
+		}
+
+		if verify {
+			backend, err := BackendFor(filepath, config)
+			if err != nil {
+				return []Access(nil), err
+			}
+
+			if _, ok := backend.(gpgBackend); ok {
+				keyIDs, err := ciphertextKeyIDs(configPath(filepath, config), config)
+				if err != nil {
+					return []Access(nil), err
+				}
+
+				access.Encrypted = matches(keyIDs)
+				access.Verified = true
+			}
+		}
+
+		accesses = append(accesses, access)
+	}
+
+	return accesses, nil
+}
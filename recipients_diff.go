@@ -0,0 +1,122 @@
+package safe
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// RecipientChange: a recipient gaining or losing access between two
+// versions of safe.yml
+type RecipientChange struct {
+	Recipient string `json:"recipient"`
+
+	// Added is true when the recipient gains access, and false when they
+	// lose it
+	Added bool `json:"added"`
+
+	// Scope is what the recipient's access changed for: an override's
+	// file or pattern, the files without overrides, or a profile
+	Scope string `json:"scope"`
+}
+
+func (c RecipientChange) String() string {
+	if c.Added {
+		return fmt.Sprintf("adds %s access to %s", c.Recipient, c.Scope)
+	}
+
+	return fmt.Sprintf("removes %s access to %s", c.Recipient, c.Scope)
+}
+
+// RecipientsDiff: compare the recipients, overrides and profiles of safe.yml
+// between two git revisions, eg: a pull request's base and head, reporting
+// who gains and loses access to what
+func RecipientsDiff(baseRev, headRev string, config Config) ([]RecipientChange, error) {
+	base, err := configAt(baseRev, config)
+	if err != nil {
+		return []RecipientChange(nil), err
+	}
+
+	head, err := configAt(headRev, config)
+	if err != nil {
+		return []RecipientChange(nil), err
+	}
+
+	changes := diffRecipients("files without overrides", base.Recipients, head.Recipients)
+
+	// an override replaces the top level recipients, so a file's access is
+	// compared through whichever applies on each side
+	for _, file := range unionKeys(base.Overrides, head.Overrides) {
+		before, ok := base.Overrides[file]
+		if !ok {
+			before = base.Recipients
+		}
+
+		after, ok := head.Overrides[file]
+		if !ok {
+			after = head.Recipients
+		}
+
+		changes = append(changes, diffRecipients(file, before, after)...)
+	}
+
+	names := make([]string, 0, len(base.Profiles)+len(head.Profiles))
+	for name := range base.Profiles {
+		names = append(names, name)
+	}
+	for name := range head.Profiles {
+		if _, ok := base.Profiles[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		scope := "profile " + name
+		changes = append(changes, diffRecipients(scope, base.Profiles[name].Recipients, head.Profiles[name].Recipients)...)
+
+		baseOverrides, headOverrides := base.Profiles[name].Overrides, head.Profiles[name].Overrides
+		for _, file := range unionKeys(baseOverrides, headOverrides) {
+			changes = append(changes, diffRecipients(file+" in "+scope, baseOverrides[file], headOverrides[file])...)
+		}
+	}
+
+	return changes, nil
+}
+
+// diffRecipients: return the recipients added and removed between two
+// lists, for the scope
+func diffRecipients(scope string, before, after []string) []RecipientChange {
+	changes := make([]RecipientChange, 0)
+	for _, recipient := range after {
+		if !containsString(before, recipient) {
+			changes = append(changes, RecipientChange{Recipient: recipient, Added: true, Scope: scope})
+		}
+	}
+
+	for _, recipient := range before {
+		if !containsString(after, recipient) {
+			changes = append(changes, RecipientChange{Recipient: recipient, Added: false, Scope: scope})
+		}
+	}
+
+	return changes
+}
+
+// unionKeys: return the keys of every map, which must all be keyed by
+// string, in order
+func unionKeys(maps ...interface{}) []string {
+	seen := make(map[string]bool)
+	keys := make([]string, 0)
+	for _, m := range maps {
+		for _, key := range reflect.ValueOf(m).MapKeys() {
+			if !seen[key.String()] {
+				seen[key.String()] = true
+				keys = append(keys, key.String())
+			}
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
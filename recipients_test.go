@@ -0,0 +1,90 @@
+package safe
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWithoutRecipient(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		config   Config
+		expected Config
+		err      string
+	}{
+		{
+			name:     "default recipients",
+			config:   Config{Recipients: []string{"a", "b"}},
+			expected: Config{Recipients: []string{"a"}},
+		},
+		{
+			name:     "overrides",
+			config:   Config{Recipients: []string{"a"}, Overrides: map[string][]string{"x.yml": {"a", "b"}, "y.yml": {"a"}}},
+			expected: Config{Recipients: []string{"a"}, Overrides: map[string][]string{"x.yml": {"a"}, "y.yml": {"a"}}},
+		},
+		{
+			name: "profiles and their overrides",
+			config: Config{
+				Recipients: []string{"a"},
+				Profiles: map[string]Profile{
+					"prod":    {Recipients: []string{"a", "b"}},
+					"staging": {Overrides: map[string][]string{"x.yml": {"a", "b"}}},
+				},
+			},
+			expected: Config{
+				Recipients: []string{"a"},
+				Profiles: map[string]Profile{
+					"prod":    {Recipients: []string{"a"}},
+					"staging": {Overrides: map[string][]string{"x.yml": {"a"}}},
+				},
+			},
+		},
+		{
+			name:   "not a recipient",
+			config: Config{Recipients: []string{"a"}, Overrides: map[string][]string{"x.yml": {"a"}}},
+			err:    "b is not a recipient",
+		},
+		{
+			name:   "last recipient",
+			config: Config{Recipients: []string{"b"}},
+			err:    "unable to remove the last recipient",
+		},
+		{
+			name:   "last recipient of an override",
+			config: Config{Recipients: []string{"a", "b"}, Overrides: map[string][]string{"x.yml": {"b"}}},
+			err:    "unable to remove the last recipient of x.yml",
+		},
+		{
+			name:   "last recipient of a profile override",
+			config: Config{Recipients: []string{"a"}, Profiles: map[string]Profile{"prod": {Overrides: map[string][]string{"x.yml": {"b"}}}}},
+			err:    "unable to remove the last recipient of x.yml in profile prod",
+		},
+	} {
+		config, err := withoutRecipient("b", test.config)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Fatalf("%s: expected error %q, got %v", test.name, test.err, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("%s: expected no error, got %v", test.name, err)
+		}
+
+		if !reflect.DeepEqual(config, test.expected) {
+			t.Fatalf("%s: expected %+v, got %+v", test.name, test.expected, config)
+		}
+	}
+}
+
+func TestWithoutRecipientLeavesConfigUnchanged(t *testing.T) {
+	overrides := map[string][]string{"x.yml": {"a", "b"}}
+	if _, err := withoutRecipient("b", Config{Recipients: []string{"a"}, Overrides: overrides}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if expected := []string{"a", "b"}; !reflect.DeepEqual(overrides["x.yml"], expected) {
+		t.Fatalf("expected the original overrides to be unchanged, got %v", overrides["x.yml"])
+	}
+}
@@ -0,0 +1,146 @@
+package safe
+
+import (
+	"fmt"
+	"os"
+)
+
+// ReconcileResult: the changes made, or in a dry run that would be made, to
+// bring the config back into agreement with the filesystem
+type ReconcileResult struct {
+	Result
+
+	Adopt AdoptResult
+	Prune PruneResult
+
+	// Protected are new plaintext files matching a pattern in files, which
+	// were protected
+	Protected []string
+}
+
+// Reconcile: bring the config and the filesystem back into agreement after a
+// rebase or a bulk move, by adopting encrypted files missing from the
+// config, pruning entries for files that no longer exist, and protecting
+// new plaintext files whose protected path matches a pattern in files, all
+// in a single commit
+func Reconcile(config Config, opts Options) (ReconcileResult, error) {
+	stepOpts := opts
+	stepOpts.Commit = false
+
+	adopted, err := Adopt(config.baseDir, config, stepOpts)
+	if err != nil {
+		return ReconcileResult{}, err
+	}
+
+	// NOTE: each step writes the config from its own copy, relying on
+	// WriteConfig to keep the changes the previous steps made on disk
+	pruned, err := Prune(config, stepOpts)
+	if err != nil {
+		return ReconcileResult{}, err
+	}
+
+	origFilepaths, err := unprotectedPatternFiles(config)
+	if err != nil {
+		return ReconcileResult{}, err
+	}
+
+	result := ReconcileResult{
+		Result: Result{
+			Written:       make([]string, 0, len(origFilepaths)),
+			Removed:       make([]string, 0, len(origFilepaths)),
+			ConfigChanged: adopted.ConfigChanged || pruned.ConfigChanged,
+		},
+		Adopt:     adopted,
+		Prune:     pruned,
+		Protected: make([]string, 0, len(origFilepaths)),
+	}
+
+	for _, origFilepath := range origFilepaths {
+		// the pattern already protects the file, so protecting it is forced
+		protected, err := Protect(origFilepath, config, Options{DryRun: opts.DryRun, Force: true})
+		if err != nil {
+			return result, err
+		}
+
+		result.Written = append(result.Written, protected.Written...)
+		result.Removed = append(result.Removed, protected.Removed...)
+
+		relFilepath, err := relativePath(origFilepath, config)
+		if err != nil {
+			return result, err
+		}
+		result.Protected = append(result.Protected, relFilepath)
+	}
+
+	if !result.Changed() {
+		return result, nil
+	}
+
+	result.Committed = opts.Commit
+	if opts.DryRun || !opts.Commit {
+		return result, nil
+	}
+
+	gitFilepaths := append(configFilepaths(config), configPaths(adopted.Adopted, config)...)
+	gitFilepaths = append(gitFilepaths, configPaths(pruned.Files, config)...)
+	gitFilepaths = append(append(gitFilepaths, result.Removed...), result.Written...)
+
+	detail := fmt.Sprintf("%d adopted, %d pruned and %d protected", len(adopted.Adopted), len(pruned.Files), len(result.Protected))
+	if err := Commit("sync", detail, gitFilepaths, config); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// unprotectedPatternFiles: return the plaintext files whose protected path
+// matches a pattern in files but hasn't been encrypted yet
+func unprotectedPatternFiles(config Config) ([]string, error) {
+	patterns := make([]string, 0)
+	for _, file := range config.Files {
+		if isPattern(file) {
+			patterns = append(patterns, file)
+		}
+	}
+
+	origFilepaths := make([]string, 0)
+	if len(patterns) == 0 {
+		return origFilepaths, nil
+	}
+
+	err := walkFiles(config.baseDir, config, func(path, relPath string) error {
+		if encryptedSuffix(path, config) != "" || isConfigFile(path, config) {
+			return nil
+		}
+
+		for _, pattern := range patterns {
+			if !matchPath(pattern, EnsureSuffix(relPath, config)) {
+				continue
+			}
+
+			if _, err := os.Stat(EnsureSuffix(path, config)); os.IsNotExist(err) {
+				origFilepaths = append(origFilepaths, path)
+			}
+			break
+		}
+
+		return nil
+	})
+	if err != nil {
+		return []string(nil), err
+	}
+
+	return origFilepaths, nil
+}
+
+// isConfigFile: return whether a path is one of safe's own files next to the
+// config, which are never protected
+func isConfigFile(path string, config Config) bool {
+	for _, name := range []string{LocalConfigName, IgnoreFileName, ".gitignore"} {
+		if configPath(name, config) == path {
+			return true
+		}
+	}
+
+	return path == config.filepath
+}
@@ -0,0 +1,132 @@
+package safe
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// remoteKeyTTL is how long fetched keys are cached before refetching
+const remoteKeyTTL = 24 * time.Hour
+
+// remoteUsernamePatterns match the usernames each remote key source
+// accepts, keyed by their recipient scheme, so a username can't escape the
+// key cache or the source's URL
+var remoteUsernamePatterns = map[string]*regexp.Regexp{
+	"github:":  regexp.MustCompile(`^[A-Za-z0-9-]+$`),
+	"keybase:": regexp.MustCompile(`^[A-Za-z0-9_]+$`),
+}
+
+// remoteKeySources fetch the armored keys for a username, keyed by their
+// recipient scheme
+var remoteKeySources = map[string]func(username string) ([][]byte, error){
+	"github:":  fetchGithubKeys,
+	"keybase:": fetchKeybaseKeys,
+}
+
+// isKeyringRecipient: return whether the recipient refers to a key in the
+// local keyring
+func isKeyringRecipient(recipient string) bool {
+	if strings.HasPrefix(recipient, "file:") {
+		return false
+	}
+
+	for scheme := range remoteKeySources {
+		if strings.HasPrefix(recipient, scheme) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ResolveRecipients: expand recipients such as `github:username` and
+// `keybase:username` into `file:` recipients for each of the user's
+// published gpg keys
+func ResolveRecipients(recipients []string) ([]string, error) {
+	resolved := make([]string, 0, len(recipients))
+	for _, recipient := range recipients {
+		keyFilepaths, err := remoteKeys(recipient)
+		if err != nil {
+			return []string(nil), err
+		}
+
+		if keyFilepaths == nil {
+			resolved = append(resolved, recipient)
+			continue
+		}
+
+		for _, keyFilepath := range keyFilepaths {
+			resolved = append(resolved, "file:"+keyFilepath)
+		}
+	}
+
+	return resolved, nil
+}
+
+// remoteKeys: return the paths of the cached keys for a remote recipient,
+// refreshing the cache when it's stale. When the source is unreachable,
+// stale keys are used. Returns nil for recipients that aren't remote.
+func remoteKeys(recipient string) ([]string, error) {
+	for scheme, fetch := range remoteKeySources {
+		username := strings.TrimPrefix(recipient, scheme)
+		if username == recipient {
+			continue
+		}
+
+		if !remoteUsernamePatterns[scheme].MatchString(username) {
+			return []string(nil), errors.New("invalid username in recipient " + recipient)
+		}
+
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return []string(nil), err
+		}
+		keyDir := filepath.Join(cacheDir, "safe", strings.TrimSuffix(scheme, ":"), username)
+
+		cached, _ := filepath.Glob(filepath.Join(keyDir, "*.asc"))
+		if info, err := os.Stat(keyDir); err == nil && time.Since(info.ModTime()) < remoteKeyTTL && len(cached) > 0 {
+			return cached, nil
+		}
+
+		keys, err := fetch(username)
+		if err != nil && len(cached) > 0 {
+			return cached, nil
+		}
+		if err != nil {
+			return []string(nil), err
+		}
+
+		return cacheRemoteKeys(keyDir, keys)
+	}
+
+	return []string(nil), nil
+}
+
+// cacheRemoteKeys: replace the keys cached in keyDir, returning the path of
+// each key file
+func cacheRemoteKeys(keyDir string, keys [][]byte) ([]string, error) {
+	if err := os.RemoveAll(keyDir); err != nil {
+		return []string(nil), err
+	}
+
+	if err := os.MkdirAll(keyDir, 0700); err != nil {
+		return []string(nil), err
+	}
+
+	keyFilepaths := make([]string, 0, len(keys))
+	for idx, key := range keys {
+		keyFilepath := filepath.Join(keyDir, strconv.Itoa(idx)+".asc")
+		if err := ioutil.WriteFile(keyFilepath, key, 0600); err != nil {
+			return []string(nil), err
+		}
+		keyFilepaths = append(keyFilepaths, keyFilepath)
+	}
+
+	return keyFilepaths, nil
+}
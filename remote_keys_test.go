@@ -0,0 +1,36 @@
+package safe
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRemoteKeysRejectsInvalidUsernames(t *testing.T) {
+	for _, recipient := range []string{
+		"github:x/../../..",
+		"github:a_b",
+		"keybase:a-b",
+		"keybase:../a",
+		"github:",
+	} {
+		_, err := remoteKeys(recipient)
+		if err == nil || !strings.HasPrefix(err.Error(), "invalid username") {
+			t.Fatalf("expected %s to be rejected, got %v", recipient, err)
+		}
+	}
+}
+
+func TestRemoteUsernamePatterns(t *testing.T) {
+	for _, test := range []struct {
+		scheme, username string
+	}{
+		{"github:", "octo-cat"},
+		{"github:", "octocat2"},
+		{"keybase:", "chris_c"},
+		{"keybase:", "max"},
+	} {
+		if !remoteUsernamePatterns[test.scheme].MatchString(test.username) {
+			t.Fatalf("expected %s%s to be accepted", test.scheme, test.username)
+		}
+	}
+}
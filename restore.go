@@ -0,0 +1,198 @@
+package safe
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Restore: recover a protected file deleted by Remove from git history,
+// registering it in the config again along with the overrides, backend and
+// metadata it had when it was deleted
+func Restore(targetFilepath string, config Config, opts Options) (Result, error) {
+	if err := checkRestorable(config); err != nil {
+		return Result{}, err
+	}
+
+	targetFilepath = EnsureSuffix(targetFilepath, config)
+	relFilepath, err := relativePath(targetFilepath, config)
+	if err != nil {
+		return Result{}, err
+	}
+
+	out, err := gitOutput("-C", config.baseDir, "log", "-n", "1", "--diff-filter=D", "--format=%H", "--", relFilepath)
+	if err != nil {
+		return Result{}, err
+	}
+
+	rev := strings.TrimSpace(out)
+	if rev == "" {
+		return Result{}, errors.New("no commit deleting " + relFilepath + " found")
+	}
+
+	return restoreFiles(rev, []string{relFilepath}, config, opts)
+}
+
+// RestoreLast: recover the files deleted by the most recent remove, as with
+// Restore
+func RestoreLast(config Config, opts Options) (Result, error) {
+	if err := checkRestorable(config); err != nil {
+		return Result{}, err
+	}
+
+	out, err := gitOutput("-C", config.baseDir, "log", "-n", "1", "--format=%H", "--grep=^safe: remove ", "--", ".")
+	if err != nil {
+		return Result{}, err
+	}
+
+	rev := strings.TrimSpace(out)
+	if rev == "" {
+		return Result{}, errors.New("no removed files found")
+	}
+
+	// --relative reports paths relative to the config rather than the
+	// repository root
+	out, err = gitOutput("-C", config.baseDir, "show", "--relative", "--diff-filter=D", "--name-only", "--format=", rev)
+	if err != nil {
+		return Result{}, err
+	}
+
+	relFilepaths := make([]string, 0)
+	for _, line := range strings.Split(out, "\n") {
+		if line = strings.TrimSpace(line); line != "" && encryptedSuffix(line, config) != "" {
+			relFilepaths = append(relFilepaths, filepath.FromSlash(line))
+		}
+	}
+
+	if len(relFilepaths) == 0 {
+		return Result{}, errors.New("the last remove, " + rev[:7] + ", didn't delete any protected files")
+	}
+
+	return restoreFiles(rev, relFilepaths, config, opts)
+}
+
+// checkRestorable: return an error unless the config's history can be
+// searched for removed files
+func checkRestorable(config Config) error {
+	name := config.VCS
+	if name == "" {
+		name = detectVCS(config.baseDir)
+	}
+
+	if name != "git" {
+		return errors.New("restoring removed files requires git history")
+	}
+
+	return nil
+}
+
+// restoreFiles: write the files as they were before the revision that
+// deleted them, and register them with their configuration from then
+func restoreFiles(rev string, relFilepaths []string, config Config, opts Options) (Result, error) {
+	// the config as it was before the files were removed
+	configName, err := filepath.Rel(config.baseDir, config.filepath)
+	if err != nil {
+		return Result{}, err
+	}
+
+	var previous Config
+	if byts, err := gitOutput("-C", config.baseDir, "show", rev+"^:./"+filepath.ToSlash(configName)); err == nil {
+		if err := decodeConfig([]byte(byts), configFormat(config.filepath), false, &previous); err != nil {
+			return Result{}, err
+		}
+	}
+
+	// the restored ciphertexts are as last encrypted then
+	previousEncryptions := make(map[string]Encryption)
+	if byts, err := gitOutput("-C", config.baseDir, "show", rev+"^:./.safe/"+EncryptionsName); err == nil {
+		if previousEncryptions, err = decodeEncryptions([]byte(byts)); err != nil {
+			return Result{}, err
+		}
+	}
+
+	result := Result{Written: make([]string, 0, len(relFilepaths)), Committed: opts.Commit}
+	contents := make([][]byte, 0, len(relFilepaths))
+	for _, relFilepath := range relFilepaths {
+		targetFilepath := configPath(relFilepath, config)
+		if _, err := os.Stat(targetFilepath); err == nil && !opts.Force {
+			return Result{}, errors.New(targetFilepath + " already exists")
+		}
+
+		ciphertext, err := gitOutput("-C", config.baseDir, "show", rev+"^:./"+filepath.ToSlash(relFilepath))
+		if err != nil {
+			return Result{}, errors.New(relFilepath + " didn't exist before " + rev[:7])
+		}
+
+		protected, err := IsProtected(targetFilepath, config)
+		if err != nil {
+			return Result{}, err
+		}
+
+		result.Written = append(result.Written, targetFilepath)
+		result.ConfigChanged = result.ConfigChanged || !protected
+		contents = append(contents, []byte(ciphertext))
+	}
+
+	if opts.DryRun {
+		return result, nil
+	}
+
+	for idx, relFilepath := range relFilepaths {
+		targetFilepath := result.Written[idx]
+		if err := ensureParentDir(targetFilepath); err != nil {
+			return Result{}, err
+		}
+
+		if err := ioutil.WriteFile(targetFilepath, contents[idx], 0644); err != nil {
+			return Result{}, err
+		}
+
+		if encryption, ok := previousEncryptions[relFilepath]; ok {
+			setEncryption(relFilepath, encryption, &config)
+		}
+
+		protected, err := IsProtected(targetFilepath, config)
+		if err != nil {
+			return Result{}, err
+		}
+		if protected {
+			continue
+		}
+
+		config.Files = append(config.Files, relFilepath)
+		if recipients, ok := previous.Overrides[relFilepath]; ok {
+			if config.Overrides == nil {
+				config.Overrides = make(map[string][]string)
+			}
+			config.Overrides[relFilepath] = recipients
+		}
+		if backend, ok := previous.Backends[relFilepath]; ok {
+			if config.Backends == nil {
+				config.Backends = make(map[string]string)
+			}
+			config.Backends[relFilepath] = backend
+		}
+		if metadata, ok := previous.Metadata[relFilepath]; ok {
+			setMetadata(relFilepath, metadata, &config)
+		}
+	}
+
+	// the encryption records change even for files that are still protected
+	if err := WriteConfig(&config); err != nil {
+		return Result{}, err
+	}
+
+	if !opts.Commit {
+		return result, nil
+	}
+
+	name := relFilepaths[0]
+	if len(relFilepaths) > 1 {
+		name = fmt.Sprintf("%d files", len(relFilepaths))
+	}
+
+	return result, Commit("restore", name, append(configFilepaths(config), result.Written...), config)
+}
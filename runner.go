@@ -0,0 +1,135 @@
+package safe
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Runner: creates the commands safe invokes, such as gpg, git and the
+// editor, so they can be recorded or run elsewhere
+type Runner interface {
+	Command(name string, args ...string) *exec.Cmd
+}
+
+// runner is used for every command safe invokes
+var runner Runner = LocalRunner{}
+
+// SetRunner: replace the runner used for every command safe invokes
+func SetRunner(r Runner) {
+	runner = r
+}
+
+// command: create a command with the current runner
+func command(name string, args ...string) *exec.Cmd {
+	logCommand(name, args)
+	cmd := runner.Command(name, args...)
+
+	// git asks for credentials when fetching, unless told not to
+	if name == "git" && batch {
+		cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	}
+
+	return cmd
+}
+
+// gpgCommand: create a gpg command with the current runner, using the gpg
+// binary from the config's safe.local.yml and the gpg_options from the
+// user's config
+func gpgCommand(config Config, args ...string) *exec.Cmd {
+	options := config.user.GPGOptions
+	return command(gpgBinaryFor(config), append(append(append([]string{}, options...), batchGPGArgs(options)...), args...)...)
+}
+
+// gpgBinaryFor: return the gpg binary from the config's safe.local.yml, or
+// gpg from the path
+func gpgBinaryFor(config Config) string {
+	if config.gpgBinary == "" {
+		return "gpg"
+	}
+
+	return config.gpgBinary
+}
+
+// runCommand: run a command created by the current runner. Commands
+// recorded by DryRunRunner succeed without being started.
+func runCommand(cmd *exec.Cmd) error {
+	if cmd.Path == dryRunPath {
+		return nil
+	}
+
+	return cmd.Run()
+}
+
+// commandOutput: run a command created by the current runner and return its
+// stdout. Commands recorded by DryRunRunner return no output.
+func commandOutput(cmd *exec.Cmd) ([]byte, error) {
+	if cmd.Path == dryRunPath {
+		return []byte{}, nil
+	}
+
+	return cmd.Output()
+}
+
+// LocalRunner: runs commands on the local machine
+type LocalRunner struct{}
+
+func (LocalRunner) Command(name string, args ...string) *exec.Cmd {
+	return exec.Command(name, args...)
+}
+
+// dryRunPath is the Path of the commands DryRunRunner returns, which
+// runCommand and commandOutput recognize and never start
+const dryRunPath = "safe-dry-run"
+
+// DryRunRunner: records commands instead of running them. Recorded commands
+// are never started, and succeed without output, so they behave the same on
+// every platform.
+type DryRunRunner struct {
+	mu       sync.Mutex
+	commands [][]string
+}
+
+func (d *DryRunRunner) Command(name string, args ...string) *exec.Cmd {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.commands = append(d.commands, append([]string{name}, args...))
+	return &exec.Cmd{Path: dryRunPath, Args: append([]string{name}, args...)}
+}
+
+// Commands: return the commands recorded so far
+func (d *DryRunRunner) Commands() [][]string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	commands := make([][]string, len(d.commands))
+	copy(commands, d.commands)
+	return commands
+}
+
+// SSHRunner: runs commands on a remote host over ssh, within Dir when set
+type SSHRunner struct {
+	Host string
+	Dir  string
+}
+
+func (s SSHRunner) Command(name string, args ...string) *exec.Cmd {
+	quoted := make([]string, 0, len(args)+1)
+	for _, arg := range append([]string{name}, args...) {
+		quoted = append(quoted, shellQuote(arg))
+	}
+
+	remoteCmd := strings.Join(quoted, " ")
+	if s.Dir != "" {
+		remoteCmd = "cd " + shellQuote(s.Dir) + " && " + remoteCmd
+	}
+
+	return exec.Command("ssh", s.Host, remoteCmd)
+}
+
+// shellQuote: quote an argument for a posix shell
+func shellQuote(arg string) string {
+	return "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+}
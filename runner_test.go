@@ -0,0 +1,55 @@
+package safe
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestDryRunRunnerRecordsCommands(t *testing.T) {
+	dryRun := &DryRunRunner{}
+	SetRunner(dryRun)
+	defer SetRunner(LocalRunner{})
+
+	if err := runCommand(command("git", "status")); err != nil {
+		t.Fatalf("expected the recorded command to succeed, got %v", err)
+	}
+
+	expected := [][]string{{"git", "status"}}
+	if commands := dryRun.Commands(); !reflect.DeepEqual(commands, expected) {
+		t.Fatalf("expected %v to be recorded, got %v", expected, commands)
+	}
+}
+
+func TestDryRunRunnerWritesNothing(t *testing.T) {
+	dryRun := &DryRunRunner{}
+	SetRunner(dryRun)
+	defer SetRunner(LocalRunner{})
+
+	cmd := command("git", "show", "HEAD:./a.yml")
+	cmd.Stdin = bytes.NewBufferString("ignored input\n")
+
+	stdout, err := commandOutput(cmd)
+	if err != nil {
+		t.Fatalf("expected the recorded command to succeed, got %v", err)
+	}
+
+	if len(stdout) != 0 {
+		t.Fatalf("expected no output, got %q", stdout)
+	}
+}
+
+func TestDryRunRunnerNeverStartsCommands(t *testing.T) {
+	dryRun := &DryRunRunner{}
+	SetRunner(dryRun)
+	defer SetRunner(LocalRunner{})
+
+	cmd := command("git", "commit", "-m", "message")
+	if err := runCommand(cmd); err != nil {
+		t.Fatalf("expected the recorded command to succeed, got %v", err)
+	}
+
+	if cmd.Process != nil {
+		t.Fatal("expected the recorded command not to be started")
+	}
+}
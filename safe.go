@@ -2,452 +2,1795 @@ package safe
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"reflect"
 	"sort"
-	"strconv"
 	"strings"
+	"time"
+)
+
+type Config struct {
+	filepath, baseDir string
+
+	// identity is the user's gpg identity for this repository
+	identity string
+
+	// profile is the name of the selected profile, if any
+	profile string
+
+	// inherited holds the recipients and overrides merged from the safe.yml
+	// files above this one and from its includes, which aren't written back
+	inherited *Config
+
+	// sources are the files the config was merged from, ie: its safe.yml,
+	// its includes and the safe.yml files above it
+	sources []string
+
+	// local is the user's safe.local.yml, if any
+	local *LocalConfig
+
+	// user is the user's config, which repository settings take precedence
+	// over
+	user UserConfig
+
+	// verifyErr is why the config couldn't be verified against the user's
+	// trusted signers, which prevents encrypting with it
+	verifyErr error
+
+	// orgPolicy is the verified policy referenced by OrgPolicy
+	orgPolicy *OrgPolicy
+
+	// ignoreFile holds the patterns from .safeignore
+	ignoreFile []string
+
+	// base is the config as last read from or written to disk, which
+	// changes made by other processes are merged against
+	base *configSnapshot
+
+	// encryptions are the records of each file's last encryption, kept in
+	// .safe/encryptions.yml. Like the metadata map, they're shared by copies
+	// of the config.
+	encryptions map[string]Encryption
+
+	// vcs is the version control system changes are committed to, as set
+	// by VCS or detected
+	vcs VCS
+
+	// gpgBinary is the gpg binary commands are run with, as configured by
+	// safe.local.yml
+	gpgBinary string
+
+	// Version is the layout of the config, where a missing version is the
+	// original layout
+	Version int `yaml:"version,omitempty"`
+
+	Recipients []string            `yaml:"recipients"`
+	Overrides  map[string][]string `yaml:"overrides"`
+	Files      []string            `yaml:"files"`
+
+	// Backend is the default backend for all files, while Backends
+	// allows a specific file to be protected with an alternate backend
+	Backend  string            `yaml:"backend,omitempty"`
+	Backends map[string]string `yaml:"backends,omitempty"`
+
+	// Fingerprints pins recipients to the full fingerprint of their key,
+	// which must match the local keyring before encrypting
+	Fingerprints map[string]string `yaml:"fingerprints,omitempty"`
+
+	// FetchKeys opts in to fetching missing recipient keys via WKD, or the
+	// Keyserver when configured
+	FetchKeys bool   `yaml:"fetch_keys,omitempty"`
+	Keyserver string `yaml:"keyserver,omitempty"`
+
+	// KeysDir is the directory of team public keys used when encrypting,
+	// defaulting to `keys`
+	KeysDir string `yaml:"keys_dir,omitempty"`
+
+	// ExpiryWarningDays is how soon before a recipient's key expires to warn
+	// when encrypting to it, while FailOnExpiry makes it an error
+	ExpiryWarningDays int  `yaml:"expiry_warning_days,omitempty"`
+	FailOnExpiry      bool `yaml:"fail_on_expiry,omitempty"`
+
+	// DeniedRecipients can never be encrypted to, eg: after a key is revoked
+	DeniedRecipients []string `yaml:"denied_recipients,omitempty"`
+
+	// CIRecipients are keys used by pipelines, which may only be included in
+	// files tagged ci
+	CIRecipients []string `yaml:"ci_recipients,omitempty"`
+
+	// Shred overwrites plaintext files, such as the originals of protected
+	// files and the temporary files used for editing, before removing them
+	Shred bool `yaml:"shred,omitempty"`
+
+	// MinRecipients is the fewest recipients any file may be encrypted to
+	MinRecipients int `yaml:"min_recipients,omitempty"`
+
+	// Policies are rules that protected files must satisfy when encrypted
+	Policies []Policy `yaml:"policies,omitempty"`
+
+	// OrgPolicy is the path of an organization wide policy, which must be
+	// signed by the key with the OrgPolicySigner fingerprint
+	OrgPolicy       string `yaml:"org_policy,omitempty"`
+	OrgPolicySigner string `yaml:"org_policy_signer,omitempty"`
+
+	// Metadata tracks per-file state, keyed by the file's path relative to
+	// the config
+	Metadata map[string]FileMetadata `yaml:"metadata,omitempty"`
+
+	// Merge controls how a nested config combines with the safe.yml files
+	// above it: `extend` (the default) adds to their recipients and
+	// overrides, while `replace` ignores them
+	Merge string `yaml:"merge,omitempty"`
+
+	// Profiles are named sets of recipients and overrides, used in place of
+	// the top level ones when selected
+	Profiles map[string]Profile `yaml:"profiles,omitempty"`
+
+	// Sync is the directory group that SyncRecipients replaces the
+	// recipients with
+	Sync SyncConfig `yaml:"sync,omitempty"`
+
+	// Include lists yaml files, relative to the config, whose recipients and
+	// overrides are merged in when loading, eg: a shared recipients.yml
+	Include []string `yaml:"include,omitempty"`
+
+	// Delegations grant recipients temporary access to some files
+	Delegations []Delegation `yaml:"delegations,omitempty"`
+
+	// Suffix is added to the names of protected files, defaulting to
+	// .gpg.asc
+	Suffix string `yaml:"suffix,omitempty"`
+
+	// Notify announces protects, edits and recipient rotations and
+	// revocations to slack, a webhook or by email
+	Notify NotifyConfig `yaml:"notify,omitempty"`
+
+	// Transforms convert the values of keys exported by exec, eg:
+	// DB_URL: base64decode
+	Transforms map[string]string `yaml:"transforms,omitempty"`
+
+	// Ignore lists patterns for files and directories that are never walked
+	// or protected, such as vendored code, in addition to those in
+	// .safeignore
+	Ignore []string `yaml:"ignore,omitempty"`
+
+	// GC holds the retention policies for the audit log and caches, applied
+	// by GC
+	GC GCConfig `yaml:"gc,omitempty"`
+
+	// Anomalies are the access patterns, such as mass decryption, that
+	// raise alerts
+	Anomalies AnomalyConfig `yaml:"anomalies,omitempty"`
+
+	// VCS is the version control system changes are committed to: git, hg
+	// or none, detected from the repository containing safe.yml when unset
+	VCS string `yaml:"vcs,omitempty"`
+
+	// Remote is the git remote that freshness is checked against,
+	// defaulting to origin
+	Remote string `yaml:"remote,omitempty"`
+}
+
+// FileMetadata: state that safe tracks about an individual protected file
+type FileMetadata struct {
+	// Archived files remain decryptable, but are retired from active use
+	Archived bool `yaml:"archived,omitempty"`
+
+	// RenamedFrom lists the previous paths of the file, oldest first
+	RenamedFrom []string `yaml:"renamed_from,omitempty"`
+
+	// ConfirmDecrypt requires a reason, recorded in the audit log, before
+	// the file is printed, edited or exec'd
+	ConfirmDecrypt bool `yaml:"confirm_decrypt,omitempty"`
+
+	// Consumers lists the services and repositories that read the file
+	Consumers []string `yaml:"consumers,omitempty"`
+
+	// CI allows the file to be encrypted to ci recipients
+	CI bool `yaml:"ci,omitempty"`
+
+	// Bundle marks a directory protected as a single tar archive, which is
+	// unpacked into a temporary directory for editing
+	Bundle bool `yaml:"bundle,omitempty"`
+}
+
+// setMetadata: store the metadata for a file, dropping the entry entirely
+// when it's empty
+func setMetadata(relFilepath string, metadata FileMetadata, config *Config) {
+	if reflect.DeepEqual(metadata, FileMetadata{}) {
+		delete(config.Metadata, relFilepath)
+		return
+	}
+
+	if config.Metadata == nil {
+		config.Metadata = make(map[string]FileMetadata)
+	}
+	config.Metadata[relFilepath] = metadata
+}
+
+// ConfigEnvVar selects the config to load, bypassing the search from the
+// working directory
+const ConfigEnvVar = "SAFE_CONFIG"
+
+// LoadConfig: walk up from the current working directory, looking for a
+// `safe.yml` file and build a config from it. The working directory is
+// changed to the config's directory, unless SAFE_CONFIG is set, in which
+// case that config is loaded as with LoadConfigFrom.
+func LoadConfig() (Config, error) {
+	if configFilepath := os.Getenv(ConfigEnvVar); configFilepath != "" {
+		return LoadConfigFrom(configFilepath)
+	}
+
+	for {
+		if _, ok := findConfigFile("."); ok {
+			break
+		}
+
+		if cwd, err := os.Getwd(); err != nil || cwd == "/" {
+			return Config{}, errors.New("no safe.yml file found")
+		}
+
+		if err := os.Chdir("../"); err != nil {
+			return Config{}, err
+		}
+
+	}
+
+	configName, _ := findConfigFile(".")
+
+	return LoadConfigFrom(configName)
+}
+
+// LoadConfigFrom: build a config from the given config file, without
+// changing the working directory. Relative paths passed to safe are then
+// relative to the working directory, rather than to the config.
+func LoadConfigFrom(configFilepath string) (Config, error) {
+	configFilepath, err := filepath.Abs(configFilepath)
+	if err != nil {
+		return Config{}, err
+	}
+
+	if _, err := os.Stat(configFilepath); err != nil {
+		return Config{}, err
+	}
+
+	unlock, err := lockConfig(configFilepath, false)
+	if err != nil {
+		return Config{}, err
+	}
+	config, err := loadConfigChain(configFilepath, ioutil.ReadFile)
+	unlock()
+	if err != nil {
+		return Config{}, err
+	}
+
+	if config.Version > CurrentConfigVersion {
+		return Config{}, fmt.Errorf("%s is version %d, but this version of safe only supports up to %d", configFilepath, config.Version, CurrentConfigVersion)
+	}
+
+	if config.Suffix != "" && (!strings.HasPrefix(config.Suffix, ".") || len(config.Suffix) == 1) {
+		return Config{}, errors.New("invalid suffix " + config.Suffix + ", expected an extension such as .enc")
+	}
+
+	if config.ignoreFile, err = loadIgnoreFile(config.baseDir); err != nil {
+		return Config{}, err
+	}
+
+	local, ok, err := loadLocalConfig(config.baseDir)
+	if err != nil {
+		return Config{}, err
+	}
+	if ok {
+		applyLocalConfig(local, &config)
+	}
+
+	userConfig, err := LoadUserConfig()
+	if err != nil {
+		return Config{}, err
+	}
+	config.identity = userConfig.IdentityFor(config.baseDir)
+	config.user = userConfig
+
+	// the policy is verified with the gpg binary and options set above
+	if config.orgPolicy, err = loadConfigOrgPolicy(config); err != nil {
+		return Config{}, err
+	}
+
+	if config.vcs, err = vcsFor(config); err != nil {
+		return Config{}, err
+	}
+	config.verifyErr = VerifyConfig(config)
+
+	if profile := os.Getenv(ProfileEnvVar); profile != "" {
+		if config, err = WithProfile(profile, config); err != nil {
+			return Config{}, err
+		}
+	}
+
+	if len(config.Recipients) == 0 {
+		return Config{}, errors.New("Invalid config, no recipients")
+	}
+
+	if err := checkMinRecipients("recipients", config.Recipients, config); err != nil {
+		return Config{}, err
+	}
+
+	for filepath, recipients := range config.Overrides {
+		if err := checkMinRecipients(filepath, recipients, config); err != nil {
+			return Config{}, err
+		}
+	}
+
+	for name, profile := range config.Profiles {
+		if len(profile.Recipients) > 0 {
+			if err := checkMinRecipients("profile "+name, profile.Recipients, config); err != nil {
+				return Config{}, err
+			}
+		}
+
+		for filepath, recipients := range profile.Overrides {
+			if err := checkMinRecipients(filepath, recipients, config); err != nil {
+				return Config{}, err
+			}
+		}
+	}
+
+	return config, nil
+}
+
+// WriteConfig: write the safe config to disk
+func WriteConfig(config *Config) error {
+	unlock, err := lockConfig(config.filepath, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// keep the changes other processes made since the config was loaded
+	if disk, err := loadConfigFile(config.filepath); err == nil {
+		mergeConcurrentChanges(config, disk)
+	}
+
+	sort.Strings(config.Files)
+
+	own := ownConfig(*config)
+	configByts, err := encodeConfig(own, configFormat(config.filepath))
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(config.filepath, configByts, 0644); err != nil {
+		return err
+	}
+
+	if err := writeEncryptions(*config); err != nil {
+		return err
+	}
+
+	config.base = snapshotConfig(own)
+	return nil
+}
+
+// writeFileAtomic: replace a file by writing a temporary file next to it and
+// renaming it into place, so a crash never leaves the file half written.
+// An existing file keeps its mode, while a new one is created with perm.
+func writeFileAtomic(path string, byts []byte, perm os.FileMode) error {
+	// replace the target of a symlink, rather than the symlink itself
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	writer, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tempFilepath := writer.Name()
+
+	writeErr := func() error {
+		if _, err := writer.Write(byts); err != nil {
+			return err
+		}
+
+		if err := writer.Chmod(perm); err != nil {
+			return err
+		}
+
+		return writer.Sync()
+	}()
+
+	if err := writer.Close(); writeErr == nil {
+		writeErr = err
+	}
+
+	if writeErr == nil {
+		writeErr = os.Rename(tempFilepath, path)
+	}
+
+	if writeErr != nil {
+		os.Remove(tempFilepath)
+		return writeErr
+	}
+
+	// sync the directory so the rename itself survives a crash
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	return nil
+}
+
+// configPath: return the path of a file from the config, such as an entry
+// in Files, which is relative to the config's base directory
+func configPath(relFilepath string, config Config) string {
+	return filepath.Join(config.baseDir, relFilepath)
+}
+
+// configPaths: return the paths of files from the config
+func configPaths(relFilepaths []string, config Config) []string {
+	filepaths := make([]string, 0, len(relFilepaths))
+	for _, relFilepath := range relFilepaths {
+		filepaths = append(filepaths, configPath(relFilepath, config))
+	}
+
+	return filepaths
+}
+
+// relativePath: return the filepath relative to the config's base directory
+func relativePath(checkFilepath string, config Config) (string, error) {
+	checkFilepath, err := filepath.Abs(checkFilepath)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Rel(config.baseDir, checkFilepath)
+}
+
+// IsProtected: return whether the absolute filepath is protected
+func IsProtected(checkFilepath string, config Config) (bool, error) {
+	relFilepath, err := relativePath(checkFilepath, config)
+	if err != nil {
+		return false, err
+	}
+
+	for _, protectedFilepath := range config.Files {
+		if relFilepath == protectedFilepath {
+			return true, nil
+		}
+
+		if isPattern(protectedFilepath) && matchPath(protectedFilepath, relFilepath) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// ProtectedFiles: return every protected file, expanding the glob patterns
+// in Files to the files they match
+func ProtectedFiles(config Config) ([]string, error) {
+	filepaths := make([]string, 0, len(config.Files))
+	patterns := make([]string, 0)
+	for _, file := range config.Files {
+		if isPattern(file) {
+			patterns = append(patterns, file)
+		} else {
+			filepaths = append(filepaths, file)
+		}
+	}
+
+	if len(patterns) == 0 {
+		return filepaths, nil
+	}
+
+	err := filepath.Walk(config.baseDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relFilepath, err := filepath.Rel(config.baseDir, path)
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if info.Name() == ".git" || (relFilepath != "." && isIgnored(relFilepath, config)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if isIgnored(relFilepath, config) {
+			return nil
+		}
+
+		for _, pattern := range patterns {
+			if matchPath(pattern, relFilepath) {
+				filepaths = append(filepaths, relFilepath)
+				break
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return []string(nil), err
+	}
+
+	return uniqueStrings(filepaths), nil
+}
+
+// IsArchived: return whether the filepath has been archived
+func IsArchived(checkFilepath string, config Config) (bool, error) {
+	relFilepath, err := relativePath(checkFilepath, config)
+	if err != nil {
+		return false, err
+	}
+
+	return config.Metadata[relFilepath].Archived, nil
+}
+
+// DefaultSuffix is added to the names of protected files, unless the config
+// sets another suffix
+const DefaultSuffix = ".gpg.asc"
+
+// knownSuffixes are the suffixes commonly given to encrypted files, longest
+// first
+var knownSuffixes = []string{".gpg.asc", ".pgp", ".gpg", ".age", ".enc"}
+
+// Suffix: return the suffix of protected files in the config
+func Suffix(config Config) string {
+	if config.Suffix != "" {
+		return config.Suffix
+	}
+
+	return DefaultSuffix
+}
+
+// EnsureSuffix: ensures that the config's suffix is present
+func EnsureSuffix(filepath string, config Config) string {
+	if !strings.HasSuffix(filepath, Suffix(config)) {
+		filepath += Suffix(config)
+	}
+
+	return filepath
+}
+
+// TrimSuffix: return the filepath with the config's suffix removed
+func TrimSuffix(filepath string, config Config) string {
+	return strings.TrimSuffix(filepath, Suffix(config))
+}
+
+// encryptedSuffix: return the configured or commonly used encrypted file
+// suffix that the filepath ends with, if any
+func encryptedSuffix(filepath string, config Config) string {
+	for _, suffix := range append([]string{Suffix(config)}, knownSuffixes...) {
+		if strings.HasSuffix(filepath, suffix) {
+			return suffix
+		}
+	}
+
+	return ""
+}
+
+// trimKnownSuffix: return the filepath with any commonly used encrypted file
+// suffix removed, for when the config isn't known
+func trimKnownSuffix(filepath string) string {
+	for _, suffix := range knownSuffixes {
+		if strings.HasSuffix(filepath, suffix) {
+			return strings.TrimSuffix(filepath, suffix)
+		}
+	}
+
+	return filepath
+}
+
+// Decrypt: decrypt a file, using the backend configured for it
+func Decrypt(filepath string, config Config) ([]byte, error) {
+	if _, err := os.Stat(filepath); err != nil {
+		return []byte(nil), err
+	}
+
+	relFilepath, err := relativePath(filepath, config)
+	if err != nil {
+		return []byte(nil), err
+	}
+
+	backend, err := BackendFor(relFilepath, config)
+	if err != nil {
+		return []byte(nil), err
+	}
+
+	return backend.Decrypt(filepath)
+}
+
+// DecryptToTempFile: decrypyt the src filepath into the target filepath,
+// returning the decrypted content and a cleanup function.
+func DecryptToFile(srcFilepath, targetFilepath string, config Config) ([]byte, func() error, error) {
+	byts, err := Decrypt(srcFilepath, config)
+	if err != nil {
+		return []byte(nil), nil, err
+	}
+
+	if err := ioutil.WriteFile(targetFilepath, byts, 0644); err != nil {
+		return []byte(nil), nil, err
+	}
+
+	cleanupFn := func() error {
+		return removePlaintext(targetFilepath, config)
+	}
+
+	return byts, cleanupFn, err
+}
+
+// DecryptToTempFile: decrypt to a temporary filepath
+func DecryptToTempFile(srcFilepath string, config Config) (string, []byte, func() error, error) {
+	tempFilepath := filepath.Join(tempDirFor(config), "safe--"+filepath.Base(TrimSuffix(srcFilepath, config)))
+
+	byts, err := Decrypt(srcFilepath, config)
+	if err != nil {
+		return tempFilepath, []byte(nil), nil, err
+	}
+
+	// editors pick their syntax highlighting from the extension, so files
+	// without one are named after their content
+	if filepath.Ext(tempFilepath) == "" {
+		tempFilepath += contentExtension(byts)
+	}
+
+	if err := ioutil.WriteFile(tempFilepath, byts, 0644); err != nil {
+		return tempFilepath, []byte(nil), nil, err
+	}
+
+	cleanupFn := func() error {
+		return removePlaintext(tempFilepath, config)
+	}
+
+	return tempFilepath, byts, cleanupFn, nil
+}
+
+// EncryptFromFile: take the contents of an existing file and encrypt them to
+// the output, deleting the original
+func EncryptFromFile(srcFilepath, targetFilepath string, config Config, commit bool, action string) error {
+	byts, err := ioutil.ReadFile(srcFilepath)
+	if err != nil {
+		return err
+	}
+
+	return Encrypt(targetFilepath, byts, config, commit, action)
+}
+
+// EncryptFromURL: fetch the contents of a url and encrypt them to the
+// output, without writing the plaintext to disk
+func EncryptFromURL(url, targetFilepath string, config Config, commit bool) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to fetch %s: %s", url, resp.Status)
+	}
+
+	byts, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	return Encrypt(targetFilepath, byts, config, commit, "encrypt")
+}
+
+// EncryptFromCommand: run a command and encrypt its stdout to the output,
+// without writing the plaintext to disk
+func EncryptFromCommand(cmdArgs []string, targetFilepath string, config Config, commit bool) error {
+	if len(cmdArgs) == 0 {
+		return errors.New("no command given")
+	}
+
+	cmd := command(cmdArgs[0], cmdArgs[1:]...)
+
+	var stdout bytes.Buffer
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := runCommand(cmd); err != nil {
+		return err
+	}
+
+	return Encrypt(targetFilepath, stdout.Bytes(), config, commit, "encrypt")
+}
+
+// Commit: commit an action to the given filepaths, referencing the safe
+// protected file, with the config's version control system in the repository
+// containing the config
+func Commit(action, target string, gitFilepaths []string, config Config) error {
+	absFilepaths, err := absolutePaths(gitFilepaths)
+	if err != nil {
+		return err
+	}
+
+	return configVCS(config).Commit(config.baseDir, commitMessage(action, target), absFilepaths)
+}
+
+// absolutePaths: return the filepaths made absolute, so they still refer to
+// the same files from the config's directory
+func absolutePaths(filepaths []string) ([]string, error) {
+	absFilepaths := make([]string, 0, len(filepaths))
+	for _, relFilepath := range filepaths {
+		absFilepath, err := filepath.Abs(relFilepath)
+		if err != nil {
+			return []string(nil), err
+		}
+		absFilepaths = append(absFilepaths, absFilepath)
+	}
+
+	return absFilepaths, nil
+}
+
+func Encrypt(filepath string, byts []byte, config Config, commit bool, action string) error {
+	return EncryptWith(filepath, byts, config, commit, action, RecipientOptions{})
+}
+
+// RecipientOptions: one-off changes to the recipients of a single encryption
+type RecipientOptions struct {
+	// Only replaces the file's configured recipients, while Add encrypts
+	// to recipients in addition to them
+	Only []string
+	Add  []string
+}
+
+// EncryptWith: encrypt a file to a narrower or wider set of recipients than
+// configured, recording the divergence in the file's metadata
+func EncryptWith(filepath string, byts []byte, config Config, commit bool, action string, opts RecipientOptions) error {
+	protected, err := IsProtected(filepath, config)
+	if err != nil {
+		return err
+	}
+
+	relFilepath, err := relativePath(filepath, config)
+	if err != nil {
+		return err
+	}
+
+	if !protected {
+		config.Files = append(config.Files, relFilepath)
+	}
+
+	recipients := RecipientsFor(relFilepath, config)
+	if len(opts.Only) > 0 {
+		recipients = opts.Only
+	}
+	for _, recipient := range opts.Add {
+		if !containsString(recipients, recipient) {
+			recipients = append(append([]string{}, recipients...), recipient)
+		}
+	}
+
+	// NOTE: the divergence is only kept until the file is next encrypted to
+	// its configured recipients
+	encryption := Encryption{
+		EncryptedTo:   recipients,
+		EncryptedWith: backendName(relFilepath, config),
+		EncryptedAt:   time.Now().UTC().Truncate(time.Second),
+	}
+	if len(opts.Only) > 0 || len(opts.Add) > 0 {
+		encryption.Recipients = recipients
+	}
+
+	if config.verifyErr != nil {
+		return config.verifyErr
+	}
+
+	if err := checkMinRecipients(filepath, recipients, config); err != nil {
+		return err
+	}
+
+	if err := checkDeniedRecipients(recipients, config); err != nil {
+		return err
+	}
+
+	if err := checkCIRecipients(filepath, recipients, config); err != nil {
+		return err
+	}
+
+	violations, err := CheckPolicies(filepath, recipients, config)
+	if err != nil {
+		return err
+	}
+	if len(violations) > 0 {
+		return violations[0]
+	}
+
+	backend, err := BackendFor(relFilepath, config)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := backend.(gpgBackend); ok {
+		if config.FetchKeys {
+			if err := FetchMissingKeys(recipients, config); err != nil {
+				return err
+			}
+		}
+
+		if err := CheckMissingKeys(recipients, config); err != nil {
+			return err
+		}
+
+		if err := checkKeySizes(recipients, config); err != nil {
+			return err
+		}
+
+		warnings, err := CheckKeyExpiry(recipients, config)
+		if err != nil {
+			return err
+		}
+
+		for _, warning := range warnings {
+			if config.FailOnExpiry {
+				return warning
+			}
+			logWarning(warning)
+		}
+
+		if recipients, err = PinnedRecipients(recipients, config); err != nil {
+			return err
+		}
+
+		if recipients, err = ResolveRecipients(recipients); err != nil {
+			return err
+		}
+	}
+
+	if err := backend.Encrypt(filepath, byts, recipients); err != nil {
+		return err
+	}
+
+	if encryption.Digest, err = ciphertextDigest(filepath); err != nil {
+		return err
+	}
+	setEncryption(relFilepath, encryption, &config)
+
+	if err := WriteConfig(&config); err != nil {
+		return err
+	}
+
+	// if no commit is requested, return early
+	if !commit {
+		return nil
+	}
+
+	return Commit(action, TrimSuffix(relFilepath, config), append([]string{filepath}, configFilepaths(config)...), config)
+}
+
+// Edit: edit a file if it's protected, creating and protecting a file if not
+func Edit(targetFilepath string, config Config, commit bool) error {
+	return EditWith(targetFilepath, config, commit, RecipientOptions{})
+}
+
+// EditWith: edit a file, encrypting the result to a narrower or wider set of
+// recipients than configured
+func EditWith(targetFilepath string, config Config, commit bool, opts RecipientOptions) error {
+	if err := checkInteractive("editing " + targetFilepath); err != nil {
+		return err
+	}
+
+	if err := CheckLock(targetFilepath, config); err != nil {
+		return err
+	}
+
+	if err := authorizeDecrypt("edit", targetFilepath, config); err != nil {
+		return err
+	}
+
+	if isBundle(targetFilepath, config) {
+		return editBundle(targetFilepath, config, commit, opts)
+	}
+
+	tempFilepath, byts, cleanupFn, err := DecryptToTempFile(targetFilepath, config)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if cleanupFn != nil {
+		defer cleanupFn()
+	}
+
+	editor := editorFor(config)
+
+	var editedByts []byte
+	for {
+		cmd := command(editor, tempFilepath)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		if err := runCommand(cmd); err != nil {
+			return err
+		}
+
+		// if the byts are the same before/after, then exit with no other changes (only if the file exists)
+		editedByts, err = ioutil.ReadFile(tempFilepath)
+		if err != nil {
+			return err
+		}
+
+		if bytes.Equal(byts, editedByts) {
+			logInfo("no changes found ...")
+			return nil
+		}
+
+		// reject saves that break the file's syntax, giving the user the
+		// chance to fix them rather than losing their changes
+		validationErr := ValidateContent(tempFilepath, editedByts)
+		if validationErr == nil {
+			break
+		}
+
+		retry, err := Confirm(fmt.Sprintf("%s is invalid: %s, edit again?", targetFilepath, validationErr))
+		if err != nil {
+			return err
+		}
+
+		if !retry {
+			return validationErr
+		}
+	}
+
+	if err := EncryptWith(targetFilepath, editedByts, config, commit, "edit", opts); err != nil {
+		return err
+	}
+
+	notify("edit", []string{targetFilepath}, "", config)
+	return nil
+}
+
+// ExecOptions: options for executing a command with decrypted values
+type ExecOptions struct {
+	// RequireFresh refuses to exec when the local ciphertext differs from
+	// the remote's
+	RequireFresh bool
+
+	// DebugEnv, when set, is the path a redacted snapshot of the command's
+	// environment is written to
+	DebugEnv string
+
+	// Transforms are applied to the values of keys before they're exported,
+	// taking precedence over the config's transforms, eg: parsed from
+	// --transform DB_URL=base64decode with ParseTransforms
+	Transforms map[string]string
+}
+
+// Exec: execute the given command in an environment with all values decrypted from the target
+func Exec(targetPath string, config Config, cmdArgs []string, opts ExecOptions) error {
+	env, err := BuildEnv(context.Background(), []string{targetPath}, config, opts)
+	if err != nil {
+		return err
+	}
+
+	cmd := command(cmdArgs[0], cmdArgs[1:]...)
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	cmd.Stdout = os.Stdout
+
+	return runCommand(cmd)
+}
+
+// EnvSnapshotEntry: a redacted environment variable passed to an exec'd
+// command, recording where its value came from without the value itself
+type EnvSnapshotEntry struct {
+	Key    string `json:"key"`
+	Length int    `json:"length"`
+	Source string `json:"source"`
+
+	// Overrode is the source of the value this one took precedence over
+	Overrode string `json:"overrode,omitempty"`
+}
+
+// writeEnvSnapshot: write the redacted environment, ordered by key, to the
+// given path
+func writeEnvSnapshot(path string, snapshot map[string]EnvSnapshotEntry) error {
+	keys := make([]string, 0, len(snapshot))
+	for key := range snapshot {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	entries := make([]EnvSnapshotEntry, 0, len(keys))
+	for _, key := range keys {
+		entries = append(entries, snapshot[key])
+	}
+
+	byts, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, append(byts, '\n'), 0600)
+}
+
+// Find: find all files in a directory that are protected, skipping archived
+// files unless requested
+func Find(dir string, config Config, includeArchived bool) ([]string, error) {
+	protectedFiles := make([]string, 0)
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relFilepath, err := relativePath(path, config)
+		if err != nil {
+			return err
+		}
+
+		// ignored directories, eg: vendored code, aren't walked at all
+		if relFilepath != "." && !strings.HasPrefix(relFilepath, "..") && isIgnored(relFilepath, config) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		protected, err := IsProtected(path, config)
+		if err != nil {
+			return err
+		}
+
+		if !protected {
+			return nil
+		}
+
+		archived, err := IsArchived(path, config)
+		if err != nil {
+			return err
+		}
+
+		if archived && !includeArchived {
+			return nil
+		}
+
+		protectedFiles = append(protectedFiles, path)
+		return nil
+	})
+	if err != nil {
+		return []string(nil), err
+	}
+
+	return protectedFiles, nil
+}
+
+// Print: prints the unencrypted file contents to stdout
+func Print(targetPath string, config Config) error {
+	protected, err := IsProtected(targetPath, config)
+	if err != nil {
+		return err
+	}
+	if !protected {
+		return errors.New(targetPath + " is not protected")
+	}
+
+	if err := authorizeDecrypt("print", targetPath, config); err != nil {
+		return err
+	}
+
+	byts, err := Decrypt(targetPath, config)
+	if os.IsNotExist(err) {
+		return errors.New(targetPath + " not found")
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(byts))
+	return nil
+}
+
+// Options: options for operations that change protected files
+type Options struct {
+	// Commit the change to git
+	Commit bool
+
+	// DryRun reports what would change, without touching disk or git
+	DryRun bool
+
+	// Force overwrites existing files that would otherwise cause an error,
+	// and skips confirmation prompts
+	Force bool
+
+	// Output is where Protect writes the ciphertext, instead of next to the
+	// plaintext. The config's suffix is added when missing.
+	Output string
+
+	// Gitignore adds the plaintext path of an unprotected file to the
+	// .gitignore next to safe.yml, so it isn't committed by accident
+	Gitignore bool
+}
+
+// Result: the changes made, or in a dry run that would be made, by an
+// operation
+type Result struct {
+	Written       []string
+	Removed       []string
+	ConfigChanged bool
+	Committed     bool
+}
+
+// Changed: return whether the operation changed anything
+func (r Result) Changed() bool {
+	return len(r.Written) > 0 || len(r.Removed) > 0 || r.ConfigChanged
+}
+
+// Protect: protect an unencrypted file. Protecting an already protected
+// file whose plaintext is gone is a no-op.
+func Protect(filepath string, config Config, opts Options) (Result, error) {
+	// files already encrypted with another tool's suffix would otherwise be
+	// encrypted a second time
+	if suffix := encryptedSuffix(filepath, config); suffix != "" && suffix != Suffix(config) {
+		return Result{}, errors.New(filepath + " already looks encrypted, set suffix: " + suffix + " in the config to manage files ending with " + suffix)
+	}
+
+	// the plaintext path is the natural argument, though the protected path
+	// is accepted too
+	origFilepath := TrimSuffix(filepath, config)
+	filepath = EnsureSuffix(origFilepath, config)
+	if opts.Output != "" {
+		filepath = EnsureSuffix(opts.Output, config)
+	}
+
+	relFilepath, err := relativePath(origFilepath, config)
+	if err != nil {
+		return Result{}, err
+	}
+
+	if isIgnored(relFilepath, config) {
+		return Result{}, errors.New(origFilepath + " is ignored, refusing to protect it")
+	}
+
+	protected, err := IsProtected(filepath, config)
+	if err != nil {
+		return Result{}, err
+	}
+
+	_, statErr := os.Stat(origFilepath)
+	origExists := statErr == nil
+
+	if protected && !origExists {
+		return Result{}, nil
+	}
+
+	if !origExists {
+		return Result{}, statErr
+	}
+
+	if protected && !opts.Force {
+		return Result{}, errors.New(filepath + " already protected")
+	}
+
+	if _, err := os.Stat(filepath); err == nil && !protected && !opts.Force {
+		return Result{}, errors.New(filepath + " already exists")
+	}
+
+	result := Result{
+		Written:       []string{filepath},
+		Removed:       []string{origFilepath},
+		ConfigChanged: !protected,
+		Committed:     opts.Commit,
+	}
+
+	if opts.DryRun {
+		return result, nil
+	}
+
+	if opts.Output != "" {
+		if err := ensureParentDir(filepath); err != nil {
+			return Result{}, err
+		}
+	}
+
+	// NOTE: we pass commit=false here so we can defer the commit until
+	// after encryption. This allows us to commit the removal of the original file.
+	if err := EncryptFromFile(origFilepath, filepath, config, false, "protect"); err != nil {
+		return Result{}, err
+	}
+
+	if err := removePlaintext(origFilepath, config); err != nil {
+		return Result{}, err
+	}
+
+	if opts.Commit {
+		if err := Commit("protect", origFilepath, append(configFilepaths(config), origFilepath, filepath), config); err != nil {
+			return result, err
+		}
+	}
+
+	notify("protect", []string{filepath}, "", config)
+	return result, nil
+}
+
+// ProtectRecursive: protect every plaintext file under dir, or only those
+// matching pattern when set, eg: *.secret.yml, in a single commit. Patterns
+// without a slash match file names, otherwise paths relative to the config.
+// Ignored files, encrypted files and safe's own files are skipped.
+func ProtectRecursive(dir, pattern string, config Config, opts Options) (Result, error) {
+	if pattern != "" {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return Result{}, errors.New("invalid pattern " + pattern)
+		}
+	}
+
+	origFilepaths := make([]string, 0)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+
+		relPath, err := relativePath(path, config)
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if info.Name() == ".git" || info.Name() == ".safe" || (relPath != "." && isIgnored(relPath, config)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !info.Mode().IsRegular() || isConfigFile(absPath, config) || isIgnored(relPath, config) || encryptedSuffix(path, config) != "" {
+			return nil
+		}
+
+		if pattern != "" {
+			matched := matchPath(pattern, filepath.ToSlash(relPath))
+			if !strings.Contains(pattern, "/") {
+				matched, _ = filepath.Match(pattern, info.Name())
+			}
+			if !matched {
+				return nil
+			}
+		}
+
+		protected, err := IsProtected(EnsureSuffix(path, config), config)
+		if err != nil {
+			return err
+		}
+		if protected && !opts.Force {
+			return nil
+		}
+
+		origFilepaths = append(origFilepaths, path)
+		return nil
+	})
+	if err != nil {
+		return Result{}, err
+	}
 
-	yaml "gopkg.in/yaml.v2"
-)
+	result := Result{
+		Written:       make([]string, 0, len(origFilepaths)),
+		Removed:       origFilepaths,
+		ConfigChanged: len(origFilepaths) > 0,
+		Committed:     opts.Commit && len(origFilepaths) > 0,
+	}
+	for _, origFilepath := range origFilepaths {
+		result.Written = append(result.Written, EnsureSuffix(origFilepath, config))
+	}
 
-type Config struct {
-	filepath, baseDir string
+	if opts.DryRun || len(origFilepaths) == 0 {
+		return result, nil
+	}
 
-	Recipients []string            `yaml:"recipients"`
-	Overrides  map[string][]string `yaml:"overrides"`
-	Files      []string            `yaml:"files"`
-}
+	progress := newProgress("protect", len(origFilepaths))
+	defer progress.finish()
 
-// LoadConfig: walk up from the current working directory, looking for a
-// `safe.yml` file and build a config from it.
-func LoadConfig() (Config, error) {
-	for {
-		if _, err := os.Stat("safe.yml"); err == nil {
-			break
+	// everything is encrypted before any plaintext is removed, so a failure
+	// part way through loses nothing
+	for _, origFilepath := range origFilepaths {
+		if relFilepath, err := relativePath(origFilepath, config); err == nil {
+			progress.advance(relFilepath)
 		}
 
-		if cwd, err := os.Getwd(); err != nil || cwd == "/" {
-			return Config{}, errors.New("no safe.yml file found")
+		targetFilepath := EnsureSuffix(origFilepath, config)
+		protected, err := IsProtected(targetFilepath, config)
+		if err != nil {
+			return Result{}, err
 		}
 
-		if err := os.Chdir("../"); err != nil {
-			return Config{}, err
+		if err := EncryptFromFile(origFilepath, targetFilepath, config, false, "protect"); err != nil {
+			return Result{}, err
+		}
+
+		// keep the files registered so far, as Encrypt registers each file
+		// in its own copy of the config
+		if !protected {
+			relFilepath, err := relativePath(targetFilepath, config)
+			if err != nil {
+				return Result{}, err
+			}
+			config.Files = append(config.Files, relFilepath)
 		}
+	}
+	progress.finish()
 
+	for _, origFilepath := range origFilepaths {
+		if err := removePlaintext(origFilepath, config); err != nil {
+			return Result{}, err
+		}
 	}
 
-	configFilepath, err := filepath.Abs("safe.yml")
-	if err != nil {
-		return Config{}, err
+	if opts.Commit {
+		gitFilepaths := append(append(configFilepaths(config), result.Removed...), result.Written...)
+		if err := Commit("protect", fmt.Sprintf("%d files in %s", len(origFilepaths), dir), gitFilepaths, config); err != nil {
+			return result, err
+		}
 	}
 
-	var config Config
-	reader, err := os.Open(configFilepath)
+	notify("protect", result.Written, "", config)
+	return result, nil
+}
+
+// ensureParentDir: create the directory a file is written to, if missing
+func ensureParentDir(path string) error {
+	return os.MkdirAll(filepath.Dir(path), 0755)
+}
+
+// ReencryptAll: reencrypt all files that are protected by safe, skipping
+// archived files and those already up to date, as told by the digest of
+// their ciphertext without decrypting them. A dry run decrypts nothing.
+// Progress is reported on stderr as each file is checked.
+func ReencryptAll(config Config, opts Options) (Result, error) {
+	filepaths, err := ProtectedFiles(config)
 	if err != nil {
-		return Config{}, err
+		return Result{}, err
 	}
-	defer reader.Close()
 
-	yamlDecoder := yaml.NewDecoder(reader)
-	if err := yamlDecoder.Decode(&config); err != nil {
-		return Config{}, err
+	active := make([]string, 0, len(filepaths))
+	for _, filepath := range filepaths {
+		if !config.Metadata[filepath].Archived {
+			active = append(active, filepath)
+		}
 	}
 
-	config.filepath = configFilepath
-	config.baseDir = filepath.Dir(configFilepath)
+	progress := newProgress("reencrypt", len(active))
+	defer progress.finish()
 
-	if len(config.Recipients) == 0 {
-		return Config{}, errors.New("Invalid config, no recipients")
+	result := Result{Written: make([]string, 0), Removed: make([]string, 0)}
+	for _, filepath := range active {
+		progress.advance(filepath)
+
+		// skip files that are unchanged and already encrypted to their
+		// recipients' current keys, avoiding needless git churn
+		current, err := upToDate(filepath, RecipientsFor(filepath, config), config)
+		if err != nil {
+			return Result{}, err
+		}
+		if current {
+			continue
+		}
+
+		result.Written = append(result.Written, configPath(filepath, config))
+		if opts.DryRun {
+			continue
+		}
+
+		byts, err := Decrypt(configPath(filepath, config), config)
+		if err != nil {
+			return Result{}, err
+		}
+
+		if err := Encrypt(configPath(filepath, config), byts, config, opts.Commit, "reencrypt"); err != nil {
+			return result, err
+		}
 	}
 
-	return config, nil
+	result.Committed = opts.Commit && len(result.Written) > 0
+	return result, nil
 }
 
-// WriteConfig: write the safe config to disk
-func WriteConfig(config *Config) error {
-	sort.Strings(config.Files)
+// untrack: remove all of safe's configuration for a file
+func untrack(relFilepath string, config *Config) {
+	filepaths := make([]string, 0, len(config.Files))
+	for _, file := range config.Files {
+		if file != relFilepath {
+			filepaths = append(filepaths, file)
+		}
+	}
+	config.Files = filepaths
 
-	configByts, err := yaml.Marshal(config)
+	delete(config.Overrides, relFilepath)
+	delete(config.Backends, relFilepath)
+	delete(config.Metadata, relFilepath)
+	delete(config.encryptions, relFilepath)
+}
+
+// Remove: remove a protected file, deleting the secret entirely. Removing a
+// file that is neither protected nor present is a no-op. On a terminal the
+// user is asked to confirm first, unless forced.
+func Remove(targetFilepath string, config Config, opts Options) (Result, error) {
+	protected, err := IsProtected(targetFilepath, config)
 	if err != nil {
-		return err
+		return Result{}, err
 	}
 
-	if err := ioutil.WriteFile(config.filepath, configByts, 0644); err != nil {
-		return err
+	_, statErr := os.Stat(targetFilepath)
+	exists := statErr == nil
+
+	if !protected && !exists {
+		return Result{}, nil
 	}
 
-	return nil
-}
+	// forcing only skips the confirmation, files safe doesn't manage are
+	// never deleted
+	if !protected {
+		return Result{}, errors.New(targetFilepath + " is not protected")
+	}
 
-// IsProtected: return whether the absolute filepath is protected
-func IsProtected(checkFilepath string, config Config) (bool, error) {
-	checkFilepath, err := filepath.Abs(checkFilepath)
+	relFilepath, err := relativePath(targetFilepath, config)
 	if err != nil {
-		return false, err
+		return Result{}, err
 	}
 
-	relFilepath, err := filepath.Rel(config.baseDir, checkFilepath)
-	if err != nil {
-		return false, err
+	result := Result{ConfigChanged: true, Committed: opts.Commit}
+	if exists {
+		result.Removed = []string{targetFilepath}
 	}
 
-	for _, protectedFilepath := range config.Files {
-		if relFilepath == protectedFilepath {
-			return true, nil
+	if opts.DryRun {
+		return result, nil
+	}
+
+	if exists {
+		ok, err := confirmDestructive("this will permanently delete "+targetFilepath+", continue?", opts)
+		if err != nil || !ok {
+			return Result{}, err
 		}
 	}
 
-	return false, nil
-}
+	untrack(relFilepath, &config)
 
-// EnsureSuffix: ensures that the .gpg.asc suffix is present
-func EnsureSuffix(filepath string) string {
-	if !strings.HasSuffix(filepath, ".gpg.asc") {
-		filepath += ".gpg.asc"
+	if exists {
+		if err := os.Remove(targetFilepath); err != nil {
+			return Result{}, err
+		}
 	}
 
-	return filepath
-}
+	if err := WriteConfig(&config); err != nil {
+		return Result{}, err
+	}
 
-// TrimSuffix: return the filepath with the .gpg.asc suffix removed
-func TrimSuffix(filepath string) string {
-	return strings.TrimSuffix(filepath, ".gpg.asc")
+	if !opts.Commit {
+		return result, nil
+	}
+
+	return result, Commit("remove", targetFilepath, append([]string{targetFilepath}, configFilepaths(config)...), config)
 }
 
-// Decrypt: decrypt a file
-func Decrypt(filepath string) ([]byte, error) {
-	if _, err := os.Stat(filepath); err != nil {
-		return []byte(nil), err
+// Unprotect: the inverse of Protect, decrypting a protected file back to
+// its plaintext path and removing the ciphertext and its configuration.
+// Bundles are unpacked back into their directory. Unprotecting a file that
+// is no longer protected is a no-op. As with Remove, the user is asked to
+// confirm first on a terminal.
+func Unprotect(targetFilepath string, config Config, opts Options) (Result, error) {
+	targetFilepath = EnsureSuffix(targetFilepath, config)
+	origFilepath := TrimSuffix(targetFilepath, config)
+
+	bundle := isBundle(targetFilepath, config)
+	if bundle {
+		origFilepath = strings.TrimSuffix(origFilepath, ".tar")
 	}
 
-	cmd := exec.Command("gpg", "-d", filepath)
+	protected, err := IsProtected(targetFilepath, config)
+	if err != nil {
+		return Result{}, err
+	}
 
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	if !protected {
+		if _, err := os.Stat(origFilepath); err == nil {
+			return Result{}, nil
+		}
+		return Result{}, errors.New(targetFilepath + " is not protected")
+	}
 
-	if err := cmd.Run(); err != nil {
-		return []byte(nil), err
+	if _, err := os.Stat(origFilepath); err == nil && (bundle || !opts.Force) {
+		return Result{}, errors.New(origFilepath + " already exists")
 	}
 
-	// note: we trim the last character before returning, since it's a new
-	// line added in by the command output
-	return stdout.Bytes()[:stdout.Len()-1], nil
-}
+	relFilepath, err := relativePath(targetFilepath, config)
+	if err != nil {
+		return Result{}, err
+	}
 
-// DecryptToTempFile: decrypyt the src filepath into the target filepath,
-// returning the decrypted content and a cleanup function.
-func DecryptToFile(srcFilepath, targetFilepath string) ([]byte, func() error, error) {
-	byts, err := Decrypt(srcFilepath)
+	relOrigFilepath, err := relativePath(origFilepath, config)
 	if err != nil {
-		return []byte(nil), nil, err
+		return Result{}, err
 	}
 
-	if err := ioutil.WriteFile(targetFilepath, byts, 0644); err != nil {
-		return []byte(nil), nil, err
+	result := Result{
+		Written:       []string{origFilepath},
+		Removed:       []string{targetFilepath},
+		ConfigChanged: true,
+		Committed:     opts.Commit,
 	}
 
-	cleanupFn := func() error {
-		return os.Remove(targetFilepath)
+	gitignoreFilepath := filepath.Join(config.baseDir, ".gitignore")
+	if opts.Gitignore {
+		result.Written = append(result.Written, gitignoreFilepath)
 	}
 
-	return byts, cleanupFn, err
-}
+	if opts.DryRun {
+		return result, nil
+	}
 
-// DecryptToTempFile: decrypt to a temporary filepath
-func DecryptToTempFile(srcFilepath string) (string, []byte, func() error, error) {
-	tempFilepath := "/tmp/safe--" + filepath.Base(strings.Replace(srcFilepath, ".gpg.asc", "", 1))
+	ok, err := confirmDestructive("this will decrypt "+targetFilepath+" to "+origFilepath+" and delete the ciphertext, continue?", opts)
+	if err != nil || !ok {
+		return Result{}, err
+	}
 
-	byts, cleanupFn, err := DecryptToFile(srcFilepath, tempFilepath)
-	return tempFilepath, byts, cleanupFn, err
-}
+	if err := authorizeDecrypt("unprotect", targetFilepath, config); err != nil {
+		return Result{}, err
+	}
 
-// EncryptFromFile: take the contents of an existing file and encrypt them to
-// the output, deleting the original
-func EncryptFromFile(srcFilepath, targetFilepath string, config Config, commit bool, action string) error {
-	byts, err := ioutil.ReadFile(srcFilepath)
+	byts, err := Decrypt(targetFilepath, config)
 	if err != nil {
-		return err
+		return Result{}, err
 	}
 
-	return Encrypt(targetFilepath, byts, config, commit, action)
-}
+	if bundle {
+		err = untarDirectory(byts, origFilepath)
+	} else {
+		err = ioutil.WriteFile(origFilepath, byts, 0600)
+	}
+	if err != nil {
+		return Result{}, err
+	}
 
-// Commit: commit an action to the given filepaths, referencing the safe protected file
-func Commit(action, filepath string, gitFilepaths []string) error {
-	// NOTE: if an origin file was "protected" that had _never_ been
-	// checked into source control, it will fail during the `git add`.
-	// Adding a removed file that wasn't checked returns a 128 error in
-	// git. To get around this, we add each file separately, and ignore
-	// errors for git add
-	for _, filepath := range gitFilepaths {
-		exec.Command("git", "add", filepath).Run()
+	if opts.Gitignore {
+		if _, err := addToGitignore(config.baseDir, []string{relOrigFilepath}); err != nil {
+			return Result{}, err
+		}
 	}
 
-	cmd := exec.Command("git", "commit", "-m", fmt.Sprintf("safe: %s %s", action, TrimSuffix(filepath)))
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		return err
+	untrack(relFilepath, &config)
+
+	if err := os.Remove(targetFilepath); err != nil {
+		return Result{}, err
 	}
 
-	return nil
+	if err := WriteConfig(&config); err != nil {
+		return Result{}, err
+	}
+
+	if !opts.Commit {
+		return result, nil
+	}
+
+	gitFilepaths := append([]string{targetFilepath}, configFilepaths(config)...)
+	if opts.Gitignore {
+		gitFilepaths = append(gitFilepaths, gitignoreFilepath)
+	}
+
+	return result, Commit("unprotect", targetFilepath, gitFilepaths, config)
 }
 
-func Encrypt(filepath string, byts []byte, config Config, commit bool, action string) error {
-	protected, err := IsProtected(filepath, config)
+// Archive: mark a protected file as archived, retiring it from active use
+// while keeping it decryptable
+func Archive(targetFilepath string, commit bool, config Config) error {
+	protected, err := IsProtected(targetFilepath, config)
 	if err != nil {
 		return err
 	}
 
 	if !protected {
-		config.Files = append(config.Files, filepath)
+		return errors.New(targetFilepath + " is not protected")
 	}
 
-	args := []string{"-a", "-e", "--yes", "--output", filepath}
-	recipients, ok := config.Overrides[filepath]
-	if !ok {
-		recipients = config.Recipients
+	relFilepath, err := relativePath(targetFilepath, config)
+	if err != nil {
+		return err
 	}
 
-	for _, recipient := range recipients {
-		args = append(args, "-r", recipient)
+	if config.Metadata == nil {
+		config.Metadata = make(map[string]FileMetadata)
 	}
 
-	cmd := exec.Command("gpg", args...)
-	cmd.Stdin = bytes.NewBuffer(append(byts, '\n'))
-	if err := cmd.Run(); err != nil {
-		return err
+	metadata := config.Metadata[relFilepath]
+	if metadata.Archived {
+		return errors.New(targetFilepath + " already archived")
 	}
 
+	metadata.Archived = true
+	config.Metadata[relFilepath] = metadata
+
 	if err := WriteConfig(&config); err != nil {
 		return err
 	}
 
-	// if no commit is requested, return early
 	if !commit {
 		return nil
 	}
 
-	return Commit(action, TrimSuffix(filepath), []string{filepath, config.filepath})
+	return Commit("archive", targetFilepath, configFilepaths(config), config)
 }
 
-// Edit: edit a file if it's protected, creating and protecting a file if not
-func Edit(targetFilepath string, config Config, commit bool) error {
-	tempFilepath, byts, cleanupFn, err := DecryptToTempFile(targetFilepath)
-	if err != nil && !os.IsNotExist(err) {
+// Move: rename a protected file in a single commit, carrying its overrides
+// and backend over to the new name, and recording the rename in its metadata
+// so that its history can be followed
+func Move(srcFilepath, targetFilepath string, commit bool, config Config) error {
+	targetFilepath = EnsureSuffix(targetFilepath, config)
+
+	protected, err := IsProtected(srcFilepath, config)
+	if err != nil {
 		return err
 	}
-
-	if cleanupFn != nil {
-		defer cleanupFn()
+	if !protected {
+		return errors.New(srcFilepath + " is not protected")
 	}
 
-	editor := os.Getenv("EDITOR")
-	if editor == "" {
-		editor = "vim"
+	if _, err := os.Stat(targetFilepath); err == nil {
+		return errors.New(targetFilepath + " already exists")
 	}
 
-	cmd := exec.Command(editor, tempFilepath)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	if err := cmd.Run(); err != nil {
+	srcRelFilepath, err := relativePath(srcFilepath, config)
+	if err != nil {
 		return err
 	}
 
-	// if the byts are the same before/after, then exit with no other changes (only if the file exists)
-	editedByts, err := ioutil.ReadFile(tempFilepath)
+	targetRelFilepath, err := relativePath(targetFilepath, config)
 	if err != nil {
 		return err
 	}
 
-	if bytes.Equal(byts, editedByts) {
-		log.Println("no changes found ...")
-		return nil
-	}
-
-	return Encrypt(targetFilepath, editedByts, config, commit, "edit")
-}
-
-// Exec: execute the given command in an environment with all values decrypted from the target
-func Exec(targetPath string, config Config, cmdArgs []string) error {
-	if _, err := IsProtected(targetPath, config); err != nil {
+	if err := os.MkdirAll(filepath.Dir(targetFilepath), 0755); err != nil {
 		return err
 	}
 
-	if !strings.HasSuffix(TrimSuffix(targetPath), ".yml") {
-		return errors.New("Only able to exec protected .yml files")
-	}
-
-	byts, err := Decrypt(targetPath)
-	if err != nil {
+	if err := os.Rename(srcFilepath, targetFilepath); err != nil {
 		return err
 	}
 
-	env := make(map[string]interface{})
-	if err := yaml.Unmarshal(byts, &env); err != nil {
-		return err
+	// recipients, backends and profile overrides follow the file
+	if recipients, ok := config.Overrides[srcRelFilepath]; ok {
+		delete(config.Overrides, srcRelFilepath)
+		config.Overrides[targetRelFilepath] = recipients
 	}
 
-	for key, rawValue := range env {
-		var value string
+	if backend, ok := config.Backends[srcRelFilepath]; ok {
+		delete(config.Backends, srcRelFilepath)
+		config.Backends[targetRelFilepath] = backend
+	}
 
-		switch rawValue.(type) {
-		case string:
-			value = rawValue.(string)
-		case []string:
-			value = strings.Join(rawValue.([]string), ",")
-		case int:
-			value = strconv.Itoa(rawValue.(int))
-		default:
-			value = fmt.Sprintf("%v", rawValue)
+	for _, profile := range config.Profiles {
+		if recipients, ok := profile.Overrides[srcRelFilepath]; ok {
+			delete(profile.Overrides, srcRelFilepath)
+			profile.Overrides[targetRelFilepath] = recipients
 		}
+	}
 
-		if err := os.Setenv(strings.ToUpper(key), value); err != nil {
-			return err
+	// the files are rebuilt rather than edited in place, as the caller's
+	// config shares their backing array
+	filepaths := make([]string, 0, len(config.Files)+1)
+	for _, file := range config.Files {
+		if file == srcRelFilepath {
+			file = targetRelFilepath
 		}
+		filepaths = append(filepaths, file)
 	}
+	config.Files = filepaths
 
-	cmd := exec.Command(cmdArgs[0], cmdArgs[1:]...)
+	// a file protected by a pattern stays protected when moved outside of it
+	protected, err = IsProtected(targetFilepath, config)
 	if err != nil {
 		return err
 	}
+	if !protected {
+		config.Files = append(config.Files, targetRelFilepath)
+	}
 
-	cmd.Stdin = os.Stdin
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
-
-	return cmd.Run()
-}
-
-// Find: find all files in a directory that are protected
-func Find(dir string, config Config) ([]string, error) {
-	protectedFiles := make([]string, 0)
+	if config.Metadata == nil {
+		config.Metadata = make(map[string]FileMetadata)
+	}
 
-	err := filepath.Walk(dir, func(path string, _ os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
+	metadata := config.Metadata[srcRelFilepath]
+	metadata.RenamedFrom = append(metadata.RenamedFrom, srcRelFilepath)
+	delete(config.Metadata, srcRelFilepath)
+	config.Metadata[targetRelFilepath] = metadata
 
-		protected, err := IsProtected(path, config)
-		if err != nil {
-			return err
-		}
+	if encryption, ok := config.encryptions[srcRelFilepath]; ok {
+		delete(config.encryptions, srcRelFilepath)
+		setEncryption(targetRelFilepath, encryption, &config)
+	}
 
-		if !protected {
-			return nil
-		}
+	if err := WriteConfig(&config); err != nil {
+		return err
+	}
 
-		protectedFiles = append(protectedFiles, path)
+	if !commit {
 		return nil
-	})
-	if err != nil {
-		return []string(nil), err
 	}
 
-	return protectedFiles, nil
+	return Commit("move", targetFilepath, append([]string{srcFilepath, targetFilepath}, configFilepaths(config)...), config)
 }
 
-// Print: prints the unencrypted file contents to stdout
-func Print(targetPath string, config Config) error {
-	protected, err := IsProtected(targetPath, config)
+// Copy: decrypt a protected file and encrypt it to a new protected file, eg:
+// to fork a prod secret into a staging variant. The copy is encrypted to the
+// given recipients, which are recorded as its override, or else to the
+// recipients configured for its path.
+func Copy(srcFilepath, targetFilepath string, recipients []string, commit bool, config Config) error {
+	targetFilepath = EnsureSuffix(targetFilepath, config)
+
+	protected, err := IsProtected(srcFilepath, config)
 	if err != nil {
 		return err
 	}
 	if !protected {
-		return errors.New(targetPath + " is not protected")
+		return errors.New(srcFilepath + " is not protected")
 	}
 
-	byts, err := Decrypt(targetPath)
-	if os.IsNotExist(err) {
-		return errors.New(targetPath + " not found")
+	if _, err := os.Stat(targetFilepath); err == nil {
+		return errors.New(targetFilepath + " already exists")
 	}
+
+	srcRelFilepath, err := relativePath(srcFilepath, config)
 	if err != nil {
 		return err
 	}
 
-	fmt.Println(string(byts))
-	return nil
-}
-
-// Protect: protect an unencrypted file
-func Protect(filepath string, commit bool, config Config) error {
-	protected, err := IsProtected(filepath, config)
+	targetRelFilepath, err := relativePath(targetFilepath, config)
 	if err != nil {
 		return err
 	}
 
-	if protected {
-		return errors.New(filepath + " already protected")
-	}
-
-	origFilepath := TrimSuffix(filepath)
-
-	// NOTE: we pass commit=false here so we can defer the commit until
-	// after encryption. This allows us to commit the removal of the original file.
-	if err := EncryptFromFile(origFilepath, filepath, config, false, "protect"); err != nil {
+	if err := authorizeDecrypt("copy", srcFilepath, config); err != nil {
 		return err
 	}
 
-	if err := os.Remove(origFilepath); err != nil {
+	byts, err := Decrypt(srcFilepath, config)
+	if err != nil {
 		return err
 	}
 
-	if !commit {
-		return nil
-	}
-
-	return Commit("protect", origFilepath, []string{config.filepath, origFilepath, filepath})
-}
-
-// ReencryptAll: reencrypt all files that are protected by safe
-func ReencryptAll(config Config, commit bool) error {
-	for _, filepath := range config.Files {
-		byts, err := Decrypt(filepath)
-		if err != nil {
-			return err
-		}
-
-		if err := Encrypt(filepath, byts, config, commit, "reencrypt"); err != nil {
-			return err
+	if len(recipients) > 0 {
+		if config.Overrides == nil {
+			config.Overrides = make(map[string][]string)
 		}
+		config.Overrides[targetRelFilepath] = recipients
 	}
 
-	return nil
-}
-
-// Remove: remove a file
-func Remove(targetFilepath string, commit bool, config Config) error {
-	protected, err := IsProtected(targetFilepath, config)
-	if err != nil {
-		return err
+	// the copy is encrypted the same way as the original
+	if backend, ok := config.Backends[srcRelFilepath]; ok {
+		config.Backends[targetRelFilepath] = backend
 	}
-
-	if !protected {
-		return errors.New(targetFilepath + " is not protected")
+	if config.Metadata[srcRelFilepath].Bundle {
+		setMetadata(targetRelFilepath, FileMetadata{Bundle: true}, &config)
 	}
 
-	filepaths := make([]string, 0, len(config.Files)-1)
-	for _, file := range config.Files {
-		if file != targetFilepath {
-			filepaths = append(filepaths, file)
-		}
+	if err := ensureParentDir(targetFilepath); err != nil {
+		return err
 	}
-	config.Files = filepaths
 
-	if err := os.Remove(targetFilepath); err != nil {
+	if err := Encrypt(targetFilepath, byts, config, false, "copy"); err != nil {
 		return err
 	}
 
-	if err := WriteConfig(&config); err != nil {
-		return err
+	if !commit {
+		return nil
 	}
 
-	return Commit("remove", targetFilepath, []string{targetFilepath, config.filepath})
+	return Commit("copy", TrimSuffix(srcRelFilepath, config)+" to "+TrimSuffix(targetRelFilepath, config), append([]string{targetFilepath}, configFilepaths(config)...), config)
 }
@@ -0,0 +1,230 @@
+package safe
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// selfTestRecipient is the identity of the throwaway key selftest generates
+const selfTestRecipient = "selftest@safe.invalid"
+
+// SelfTestStep: the outcome of one step of the self test
+type SelfTestStep struct {
+	Name string
+
+	// Err is why the step failed, if it did. Steps after a failure are
+	// skipped, and have no error.
+	Err     error
+	Skipped bool
+}
+
+// SelfTestReport: the steps run by SelfTest, and the directory they ran in
+type SelfTestReport struct {
+	Dir   string
+	Steps []SelfTestStep
+}
+
+// Failed: return whether any step of the self test failed
+func (r SelfTestReport) Failed() bool {
+	for _, step := range r.Steps {
+		if step.Err != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SelfTest: exercise protect, edit, exec, reencrypt and remove end to end,
+// with a throwaway gpg home, key and git repository in a temporary
+// directory, to check that gpg, git and safe work together. The user's own
+// keyring and configs are never touched. The directory is removed unless
+// keep is set.
+func SelfTest(keep bool) (SelfTestReport, error) {
+	dir, err := ioutil.TempDir("", "safe-selftest")
+	if err != nil {
+		return SelfTestReport{}, err
+	}
+	if !keep {
+		defer os.RemoveAll(dir)
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return SelfTestReport{}, err
+	}
+	defer os.Chdir(cwd)
+
+	// isolate gpg and safe from the user's keyring and configs
+	restoreEnv := setEnv(map[string]string{
+		"GNUPGHOME":       filepath.Join(dir, "gnupg"),
+		"XDG_CONFIG_HOME": filepath.Join(dir, "config"),
+		ConfigEnvVar:      "",
+		ProfileEnvVar:     "",
+		IdentityEnvVar:    "",
+	})
+	defer restoreEnv()
+
+	// stop the gpg agent started for the throwaway gpg home
+	defer runCommand(command("gpgconf", "--kill", "gpg-agent"))
+
+	// the self test's editor and key never need the user, so it runs the
+	// same in batch mode
+	savedBatch := batch
+	batch = false
+	defer func() { batch = savedBatch }()
+
+	report := SelfTestReport{Dir: dir, Steps: make([]SelfTestStep, 0)}
+	repoDir := filepath.Join(dir, "repo")
+
+	var config Config
+	steps := []struct {
+		name string
+		run  func() error
+	}{
+		{"generate a test key", func() error {
+			if err := os.MkdirAll(filepath.Join(dir, "gnupg"), 0700); err != nil {
+				return err
+			}
+
+			return runCommand(gpgCommand(config, "--batch", "--passphrase", "", "--quick-gen-key", selfTestRecipient, "default", "default", "never"))
+		}},
+		{"create a git repository", func() error {
+			if err := os.MkdirAll(repoDir, 0755); err != nil {
+				return err
+			}
+
+			for _, args := range [][]string{
+				{"init", "--quiet"},
+				{"config", "user.email", selfTestRecipient},
+				{"config", "user.name", "safe selftest"},
+				{"config", "commit.gpgsign", "false"},
+			} {
+				if _, err := gitOutput(append([]string{"-C", repoDir}, args...)...); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}},
+		{"init safe", func() error {
+			config, err = Init(repoDir, InitOptions{Recipients: []string{selfTestRecipient}, Commit: true})
+			return err
+		}},
+		{"protect a file", func() error {
+			if err := ioutil.WriteFile(filepath.Join(repoDir, "secrets.yml"), []byte("key: value\n"), 0600); err != nil {
+				return err
+			}
+
+			_, err := Protect("secrets.yml", config, Options{Commit: true})
+			return err
+		}},
+		{"decrypt it", func() error {
+			if config, err = LoadConfig(); err != nil {
+				return err
+			}
+
+			return checkSelfTestContent(config, "key: value\n")
+		}},
+		{"edit it", func() error {
+			editorFilepath := filepath.Join(dir, "editor")
+			editor := "#!/bin/sh\necho 'edited: true' >> \"$1\"\n"
+			if err := ioutil.WriteFile(editorFilepath, []byte(editor), 0755); err != nil {
+				return err
+			}
+
+			if err := ioutil.WriteFile(filepath.Join(repoDir, LocalConfigName), []byte("editor: "+editorFilepath+"\n"), 0600); err != nil {
+				return err
+			}
+
+			if config, err = LoadConfig(); err != nil {
+				return err
+			}
+
+			if err := Edit(EnsureSuffix("secrets.yml", config), config, true); err != nil {
+				return err
+			}
+
+			return checkSelfTestContent(config, "key: value\nedited: true\n")
+		}},
+		{"exec it", func() error {
+			return Exec(EnsureSuffix("secrets.yml", config), config, []string{"sh", "-c", `test "$KEY" = value && test "$EDITED" = true`}, ExecOptions{})
+		}},
+		{"reencrypt every file", func() error {
+			_, err := ReencryptAll(config, Options{Commit: true})
+			return err
+		}},
+		{"remove it", func() error {
+			if _, err := Remove(EnsureSuffix("secrets.yml", config), config, Options{Commit: true}); err != nil {
+				return err
+			}
+
+			if _, err := os.Stat(EnsureSuffix("secrets.yml", config)); !os.IsNotExist(err) {
+				return errors.New("the protected file still exists")
+			}
+
+			return nil
+		}},
+	}
+
+	failed := false
+	for _, step := range steps {
+		if failed {
+			report.Steps = append(report.Steps, SelfTestStep{Name: step.name, Skipped: true})
+			continue
+		}
+
+		stepErr := step.run()
+		report.Steps = append(report.Steps, SelfTestStep{Name: step.name, Err: stepErr})
+		failed = stepErr != nil
+	}
+
+	return report, nil
+}
+
+// checkSelfTestContent: return an error unless the self test's protected
+// file decrypts to the expected content
+func checkSelfTestContent(config Config, expected string) error {
+	byts, err := Decrypt(EnsureSuffix("secrets.yml", config), config)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(byts, []byte(expected)) {
+		return errors.New("decrypted content doesn't match what was encrypted")
+	}
+
+	return nil
+}
+
+// setEnv: set environment variables, unsetting those with empty values,
+// and return a function restoring their previous values
+func setEnv(values map[string]string) func() {
+	previous := make(map[string]*string, len(values))
+	for key, value := range values {
+		if existing, ok := os.LookupEnv(key); ok {
+			previous[key] = &existing
+		} else {
+			previous[key] = nil
+		}
+
+		if value == "" {
+			os.Unsetenv(key)
+		} else {
+			os.Setenv(key, value)
+		}
+	}
+
+	return func() {
+		for key, value := range previous {
+			if value == nil {
+				os.Unsetenv(key)
+			} else {
+				os.Setenv(key, *value)
+			}
+		}
+	}
+}
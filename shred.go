@@ -0,0 +1,62 @@
+package safe
+
+import (
+	"crypto/rand"
+	"io"
+	"os"
+)
+
+// removePlaintext: remove a plaintext file, shredding it first when the
+// config enables it
+func removePlaintext(path string, config Config) error {
+	if config.Shred {
+		return Shred(path)
+	}
+
+	return os.Remove(path)
+}
+
+// Shred: overwrite a file with random data before removing it. This is best
+// effort, since copy on write filesystems and SSDs may keep the original
+// blocks, in which case a warning is logged.
+func Shred(path string) error {
+	if warning := overwriteWarning(path); warning != "" {
+		logWarning(path, warning)
+	}
+
+	if err := overwrite(path); err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// overwrite: replace the content of a file with random data, in place
+func overwrite(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.CopyN(file, rand.Reader, info.Size()); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Truncate(0); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,50 @@
+package safe
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// copyOnWriteFilesystems are the statfs magic numbers of filesystems that
+// write modified blocks elsewhere, leaving the originals in place
+var copyOnWriteFilesystems = map[uint32]string{
+	0x9123683e: "btrfs",
+	0x2fc12fc1: "zfs",
+	0xca451a4e: "bcachefs",
+}
+
+// overwriteWarning: return why overwriting the file may not remove its
+// content from disk, if it may not
+func overwriteWarning(path string) string {
+	var statfs syscall.Statfs_t
+	if err := syscall.Statfs(path, &statfs); err == nil {
+		if name, ok := copyOnWriteFilesystems[uint32(statfs.Type)]; ok {
+			return "is on a copy on write filesystem (" + name + "), overwriting it may not remove the plaintext"
+		}
+	}
+
+	var stat syscall.Stat_t
+	if err := syscall.Stat(path, &stat); err != nil {
+		return ""
+	}
+
+	// the block device, or the disk of a partition, reports whether it is
+	// rotational
+	device := fmt.Sprintf("/sys/dev/block/%d:%d", (stat.Dev>>8)&0xfff, (stat.Dev&0xff)|((stat.Dev>>12)&0xfff00))
+	for _, rotational := range []string{filepath.Join(device, "queue", "rotational"), filepath.Join(device, "..", "queue", "rotational")} {
+		byts, err := ioutil.ReadFile(rotational)
+		if err != nil {
+			continue
+		}
+
+		if strings.TrimSpace(string(byts)) == "0" {
+			return "is on an SSD, where wear leveling means overwriting it may not remove the plaintext"
+		}
+		return ""
+	}
+
+	return ""
+}
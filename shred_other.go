@@ -0,0 +1,9 @@
+//go:build !linux
+
+package safe
+
+// overwriteWarning: return why overwriting the file may not remove its
+// content from disk. Outside of linux, the filesystem isn't inspected.
+func overwriteWarning(path string) string {
+	return "may be on a copy on write filesystem or SSD, overwriting it may not remove the plaintext"
+}
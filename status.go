@@ -0,0 +1,199 @@
+package safe
+
+import (
+	"os"
+)
+
+// File states reported by Status
+const (
+	StatusCurrent           = "current"
+	StatusUnknown           = "unknown"
+	StatusContentChanged    = "content-changed"
+	StatusRecipientsChanged = "recipients-changed"
+)
+
+// FileStatus: whether a protected file is up to date with safe.yml
+type FileStatus struct {
+	Filepath string `json:"filepath"`
+
+	// State is current, unknown when no encryption was recorded for the
+	// file, content-changed when its ciphertext was replaced since safe
+	// last encrypted it, or recipients-changed when it isn't encrypted to
+	// exactly its recipients' current keys
+	State string `json:"state"`
+}
+
+// String: format the status as file: state
+func (s FileStatus) String() string {
+	return s.Filepath + ": " + s.State
+}
+
+// coloredString: format the status with its state in green when current,
+// yellow when unknown and red when stale
+func (s FileStatus) coloredString() string {
+	color := redColor
+	switch s.State {
+	case StatusCurrent:
+		color = greenColor
+	case StatusUnknown:
+		color = yellowColor
+	}
+
+	return s.Filepath + ": " + colorize(color, s.State)
+}
+
+// ciphertextDigest: return the digest of a ciphertext, formatted as
+// sha256:<digest>. It's derived from the ciphertext alone, so recording it
+// reveals nothing about the plaintext.
+func ciphertextDigest(filepath string) (string, error) {
+	hash, err := fileHash(filepath)
+	if err != nil {
+		return "", err
+	}
+
+	return "sha256:" + hash, nil
+}
+
+// ciphertextMatches: return whether a ciphertext is the one safe recorded
+// when it last encrypted the file
+func ciphertextMatches(digest, filepath string) (bool, error) {
+	if digest == "" {
+		return false, nil
+	}
+
+	current, err := ciphertextDigest(filepath)
+	if err != nil {
+		return false, err
+	}
+
+	return current == digest, nil
+}
+
+// encryptedToRecipients: return whether a gpg ciphertext is encrypted to
+// exactly the current keys of the recipients. Recipients whose keys aren't
+// in the keyring can't be checked, so are never considered current.
+func encryptedToRecipients(filepath string, recipients []string, config Config) (bool, error) {
+	ciphertextIDs, err := ciphertextKeyIDs(filepath, config)
+	if err != nil {
+		return false, err
+	}
+
+	matched := make(map[string]bool)
+	for _, recipient := range recipients {
+		if !isKeyringRecipient(recipient) {
+			return false, nil
+		}
+
+		keyIDs, err := recipientKeyIDs(recipient, config)
+		if err != nil {
+			return false, err
+		}
+
+		found := false
+		for _, keyID := range keyIDs {
+			if containsString(ciphertextIDs, keyID) {
+				matched[keyID] = true
+				found = true
+			}
+		}
+
+		if !found {
+			return false, nil
+		}
+	}
+
+	return len(matched) == len(uniqueStrings(ciphertextIDs)), nil
+}
+
+// upToDate: return whether a protected file is as safe last encrypted it,
+// to the recipients, so that reencrypting it would change nothing
+func upToDate(relFilepath string, recipients []string, config Config) (bool, error) {
+	// files without a recorded encryption are reencrypted to record one
+	encryption := config.encryptions[relFilepath]
+	if encryption.EncryptedAt.IsZero() {
+		return false, nil
+	}
+
+	matches, err := ciphertextMatches(encryption.Digest, configPath(relFilepath, config))
+	if err != nil || !matches {
+		return false, err
+	}
+
+	backend, err := BackendFor(relFilepath, config)
+	if err != nil {
+		return false, err
+	}
+
+	if _, ok := backend.(gpgBackend); !ok {
+		return false, nil
+	}
+
+	return encryptedToRecipients(configPath(relFilepath, config), recipients, config)
+}
+
+// Status: report whether each active protected file is up to date, ie: its
+// ciphertext is the one safe recorded when it was last encrypted, and it's
+// encrypted to its recipients' current keys. Nothing is decrypted.
+func Status(config Config) ([]FileStatus, error) {
+	protectedFiles, err := ProtectedFiles(config)
+	if err != nil {
+		return []FileStatus(nil), err
+	}
+
+	filepaths := make([]string, 0, len(protectedFiles))
+	for _, filepath := range protectedFiles {
+		if !config.Metadata[filepath].Archived {
+			filepaths = append(filepaths, filepath)
+		}
+	}
+
+	statuses := make([]FileStatus, 0, len(filepaths))
+	for _, relFilepath := range filepaths {
+		status := FileStatus{Filepath: relFilepath, State: StatusCurrent}
+
+		digest := config.encryptions[relFilepath].Digest
+		matches, err := ciphertextMatches(digest, configPath(relFilepath, config))
+		if os.IsNotExist(err) {
+			matches, err = false, nil
+		}
+		if err != nil {
+			return []FileStatus(nil), err
+		}
+
+		switch {
+		case digest == "":
+			status.State = StatusUnknown
+		case !matches:
+			status.State = StatusContentChanged
+		default:
+			backend, err := BackendFor(relFilepath, config)
+			if err != nil {
+				return []FileStatus(nil), err
+			}
+
+			// only gpg ciphertexts can be checked for their recipients
+			if _, ok := backend.(gpgBackend); !ok {
+				break
+			}
+
+			// a one-off encryption is kept until the file is next encrypted
+			recipients := RecipientsFor(relFilepath, config)
+			if encryption := config.encryptions[relFilepath]; len(encryption.Recipients) > 0 {
+				recipients = encryption.Recipients
+			}
+
+			current, err := encryptedToRecipients(configPath(relFilepath, config), recipients, config)
+			if err != nil {
+				return []FileStatus(nil), err
+			}
+
+			if !current {
+				status.State = StatusRecipientsChanged
+			}
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
@@ -0,0 +1,88 @@
+package safe
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUpToDate(t *testing.T) {
+	defer SetRunner(LocalRunner{})
+	SetRunner(outputRunner{":pubkey enc packet: version 3, algo 1, keyid 1111111111111111\n"})
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.yml.gpg"), []byte("ciphertext"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	digest, err := ciphertextDigest(filepath.Join(dir, "a.yml.gpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encryptedAt := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		name       string
+		encryption Encryption
+		backend    string
+		recipients []string
+		expected   bool
+	}{
+		{
+			name:       "current",
+			encryption: Encryption{Digest: digest, EncryptedAt: encryptedAt},
+			recipients: []string{"1111111111111111"},
+			expected:   true,
+		},
+		{
+			name:       "never encrypted",
+			recipients: []string{"1111111111111111"},
+		},
+		{
+			name:       "without a digest",
+			encryption: Encryption{EncryptedAt: encryptedAt},
+			recipients: []string{"1111111111111111"},
+		},
+		{
+			name:       "ciphertext changed",
+			encryption: Encryption{Digest: "sha256:0000", EncryptedAt: encryptedAt},
+			recipients: []string{"1111111111111111"},
+		},
+		{
+			name:       "recipient added",
+			encryption: Encryption{Digest: digest, EncryptedAt: encryptedAt},
+			recipients: []string{"1111111111111111", "2222222222222222"},
+		},
+		{
+			name:       "recipient removed",
+			encryption: Encryption{Digest: digest, EncryptedAt: encryptedAt},
+			recipients: []string{"2222222222222222"},
+		},
+		{
+			name:       "not gpg",
+			encryption: Encryption{Digest: digest, EncryptedAt: encryptedAt},
+			backend:    "kms",
+			recipients: []string{"1111111111111111"},
+		},
+	} {
+		config := Config{
+			baseDir:     dir,
+			Backend:     "gpg",
+			Backends:    map[string]string{},
+			encryptions: map[string]Encryption{"a.yml.gpg": test.encryption},
+		}
+		if test.backend != "" {
+			config.Backends["a.yml.gpg"] = test.backend
+		}
+
+		current, err := upToDate("a.yml.gpg", test.recipients, config)
+		if err != nil {
+			t.Fatalf("%s: expected no error, got %v", test.name, err)
+		}
+
+		if current != test.expected {
+			t.Fatalf("%s: expected up to date to be %v", test.name, test.expected)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package safe
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// minPasswordLength is the shortest secret value not considered weak
+const minPasswordLength = 12
+
+// Severities of weak secrets, from most to least urgent
+const (
+	SeverityHigh   = "high"
+	SeverityMedium = "medium"
+	SeverityLow    = "low"
+)
+
+var severityOrder = map[string]int{SeverityHigh: 0, SeverityMedium: 1, SeverityLow: 2}
+
+// placeholderValues are defaults and placeholders that should never be used
+// as real secrets
+var placeholderValues = []string{
+	"changeme", "change_me", "password", "passw0rd", "secret", "default",
+	"admin", "root", "test", "example", "todo", "fixme", "xxx", "123456",
+	"12345678", "qwerty", "letmein",
+}
+
+// secretKeyPattern matches the names of keys that hold secrets, rather than
+// other configuration such as hosts and ports
+var secretKeyPattern = regexp.MustCompile(`(?i)(pass|pwd|secret|token|key|credential|auth|salt)`)
+
+// StrengthIssue: a weak secret value found in a protected file
+type StrengthIssue struct {
+	Filepath string `json:"filepath"`
+	Key      string `json:"key"`
+	Severity string `json:"severity"`
+	Problem  string `json:"problem"`
+}
+
+func (i StrengthIssue) String() string {
+	return fmt.Sprintf("[%s] %s: %s: %s", i.Severity, i.Filepath, i.Key, i.Problem)
+}
+
+// AuditStrength: decrypt every active structured protected file and flag
+// weak secret values: placeholders, values reused across keys, short
+// passwords and tokens that aren't random. Issues are ordered by severity.
+func AuditStrength(config Config) ([]StrengthIssue, error) {
+	protectedFiles, err := ProtectedFiles(config)
+	if err != nil {
+		return []StrengthIssue(nil), err
+	}
+
+	filepaths := make([]string, 0, len(protectedFiles))
+	for _, filepath := range protectedFiles {
+		if !config.Metadata[filepath].Archived && FormatOf(filepath, config) != "" {
+			filepaths = append(filepaths, filepath)
+		}
+	}
+
+	results, err := DecryptMany(context.Background(), configPaths(filepaths, config), config, DecryptOptions{})
+	if err != nil {
+		return []StrengthIssue(nil), err
+	}
+
+	issues := make([]StrengthIssue, 0)
+	seen := make(map[string]string)
+	for idx, result := range results {
+		relFilepath := filepaths[idx]
+
+		data, err := parseStructured(result.Byts, FormatOf(relFilepath, config))
+		if err != nil {
+			issues = append(issues, StrengthIssue{Filepath: relFilepath, Severity: SeverityLow, Problem: "unable to parse: " + err.Error()})
+			continue
+		}
+
+		env := make(map[string]string)
+		flattenEnv("", data, env)
+
+		for _, key := range sortedStringKeys(env) {
+			value := env[key]
+			if !secretKeyPattern.MatchString(key) || value == "" {
+				continue
+			}
+
+			issue := StrengthIssue{Filepath: relFilepath, Key: key}
+			location := relFilepath + ":" + key
+
+			switch {
+			case isPlaceholder(value):
+				issue.Severity, issue.Problem = SeverityHigh, "placeholder or default value"
+			case seen[value] != "":
+				issue.Severity, issue.Problem = SeverityHigh, "same value as "+seen[value]
+			case len(value) < minPasswordLength:
+				issue.Severity, issue.Problem = SeverityMedium, fmt.Sprintf("only %d characters long", len(value))
+			case entropyBits(value) < 64:
+				issue.Severity, issue.Problem = SeverityMedium, fmt.Sprintf("only %.0f bits of entropy, it doesn't look random", entropyBits(value))
+			}
+
+			if seen[value] == "" {
+				seen[value] = location
+			}
+
+			if issue.Problem != "" {
+				issues = append(issues, issue)
+			}
+		}
+	}
+
+	sort.SliceStable(issues, func(i, j int) bool {
+		return severityOrder[issues[i].Severity] < severityOrder[issues[j].Severity]
+	})
+
+	return issues, nil
+}
+
+// sortedStringKeys: return the keys of the map in order
+func sortedStringKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// isPlaceholder: return whether the value is a placeholder, ignoring case
+// and any trailing digits, eg: Password1
+func isPlaceholder(value string) bool {
+	normalized := strings.TrimRight(strings.ToLower(strings.TrimSpace(value)), "0123456789!")
+	for _, placeholder := range placeholderValues {
+		if normalized == placeholder || strings.ToLower(value) == placeholder {
+			return true
+		}
+	}
+
+	// values such as <password> or ${TOKEN} are unfilled templates
+	return strings.HasPrefix(value, "<") && strings.HasSuffix(value, ">") ||
+		strings.HasPrefix(value, "${") && strings.HasSuffix(value, "}")
+}
+
+// entropyBits: estimate the entropy of a value from the distribution of its
+// characters
+func entropyBits(value string) float64 {
+	counts := make(map[rune]int)
+	total := 0
+	for _, r := range value {
+		counts[r]++
+		total++
+	}
+
+	perChar := 0.0
+	for _, count := range counts {
+		p := float64(count) / float64(total)
+		perChar -= p * math.Log2(p)
+	}
+
+	return perChar * float64(total)
+}
@@ -0,0 +1,252 @@
+package safe
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// SyncConfig: the directory group whose members are the config's recipients
+type SyncConfig struct {
+	// Group is the group's DN for ldap, or its email address for google
+	Group string `yaml:"group"`
+
+	// LDAPURI and LDAPBaseDN locate the ldap directory to search
+	LDAPURI    string `yaml:"ldap_uri,omitempty"`
+	LDAPBaseDN string `yaml:"ldap_base_dn,omitempty"`
+
+	// KeyAttribute is the ldap attribute holding members' public keys, eg:
+	// pgpKey, which are imported when they aren't in the keyring
+	KeyAttribute string `yaml:"key_attribute,omitempty"`
+}
+
+// SyncResult: the recipient changes made, or in a dry run that would be
+// made, by syncing with a directory group
+type SyncResult struct {
+	Result
+
+	Added   []string
+	Removed []string
+
+	// Missing are members whose keys couldn't be found, who are left out of
+	// the recipients
+	Missing []string
+}
+
+// groupMember: a member of a directory group, along with any public key the
+// directory holds for them
+type groupMember struct {
+	Email string
+	Key   []byte
+}
+
+// groupSources list the members of the configured group, using the
+// directory's command line tools
+var groupSources = map[string]func(SyncConfig) ([]groupMember, error){
+	"ldap":   ldapGroupMembers,
+	"google": googleGroupMembers,
+}
+
+// SyncRecipients: replace the recipients with the members of the configured
+// group in an ldap or google directory, resolving each member's key from
+// the keyring, the directory, or WKD and the keyserver. Members without a
+// key are reported rather than added.
+func SyncRecipients(source string, reencrypt bool, config Config, opts Options) (SyncResult, error) {
+	listMembers, ok := groupSources[source]
+	if !ok {
+		return SyncResult{}, errors.New("unknown source " + source + ", expected ldap or google")
+	}
+
+	if config.Sync.Group == "" {
+		return SyncResult{}, errors.New("no sync group configured")
+	}
+
+	members, err := listMembers(config.Sync)
+	if err != nil {
+		return SyncResult{}, err
+	}
+
+	result := SyncResult{Added: make([]string, 0), Removed: make([]string, 0), Missing: make([]string, 0)}
+
+	recipients := make([]string, 0, len(members))
+	for _, member := range members {
+		found, err := resolveMemberKey(member, config, opts.DryRun)
+		if err != nil {
+			return SyncResult{}, err
+		}
+
+		if !found {
+			result.Missing = append(result.Missing, member.Email)
+			continue
+		}
+
+		recipients = append(recipients, member.Email)
+	}
+	sort.Strings(recipients)
+
+	if len(recipients) == 0 {
+		return SyncResult{}, errors.New("no members of " + config.Sync.Group + " have keys, refusing to remove every recipient")
+	}
+
+	own := ownConfig(config).Recipients
+	for _, recipient := range recipients {
+		if !containsString(own, recipient) {
+			result.Added = append(result.Added, recipient)
+		}
+	}
+	for _, recipient := range own {
+		if !containsString(recipients, recipient) {
+			result.Removed = append(result.Removed, recipient)
+		}
+	}
+
+	if len(result.Added) == 0 && len(result.Removed) == 0 {
+		return result, nil
+	}
+
+	// inherited recipients are kept, since they're managed by the parent
+	// config
+	if config.inherited != nil {
+		recipients = mergeRecipients(config.inherited.Recipients, recipients)
+	}
+	config.Recipients = recipients
+
+	updated, err := updateRecipients("sync", "recipients from "+source, reencrypt, config, opts)
+	if err != nil {
+		return SyncResult{}, err
+	}
+	result.Result = updated
+
+	return result, nil
+}
+
+// resolveMemberKey: make sure the member's key is in the keyring, importing
+// it from the directory or fetching it when needed, and return whether it
+// is. Nothing is imported in a dry run.
+func resolveMemberKey(member groupMember, config Config, dryRun bool) (bool, error) {
+	keys, err := listGPGKeys(member.Email, config)
+	if err != nil {
+		return false, err
+	}
+
+	if len(keys) > 0 {
+		return true, nil
+	}
+
+	if dryRun {
+		return len(member.Key) > 0, nil
+	}
+
+	if len(member.Key) > 0 {
+		cmd := gpgCommand(config, "--batch", "--import")
+		cmd.Stdin = bytes.NewReader(member.Key)
+		if err := runCommand(cmd); err != nil {
+			return false, err
+		}
+
+		return true, nil
+	}
+
+	// a member whose key can't be fetched is reported as missing
+	if err := FetchKey(member.Email, config); err != nil {
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// ldapGroupMembers: list the members of an ldap group with ldapsearch
+func ldapGroupMembers(sync SyncConfig) ([]groupMember, error) {
+	args := []string{"-x", "-LLL"}
+	if sync.LDAPURI != "" {
+		args = append(args, "-H", sync.LDAPURI)
+	}
+	if sync.LDAPBaseDN != "" {
+		args = append(args, "-b", sync.LDAPBaseDN)
+	}
+	args = append(args, "(memberOf="+sync.Group+")", "mail")
+	if sync.KeyAttribute != "" {
+		args = append(args, sync.KeyAttribute)
+	}
+
+	cmd := command("ldapsearch", args...)
+
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := runCommand(cmd); err != nil {
+		return []groupMember(nil), err
+	}
+
+	members := make([]groupMember, 0)
+	for _, entry := range parseLDIF(stdout.String()) {
+		if len(entry["mail"]) == 0 {
+			continue
+		}
+
+		member := groupMember{Email: string(entry["mail"][0])}
+		if sync.KeyAttribute != "" && len(entry[sync.KeyAttribute]) > 0 {
+			member.Key = entry[sync.KeyAttribute][0]
+		}
+		members = append(members, member)
+	}
+
+	return members, nil
+}
+
+// parseLDIF: parse ldapsearch's output into entries of attribute values,
+// decoding base64 values and joining folded lines
+func parseLDIF(output string) []map[string][][]byte {
+	entries := make([]map[string][][]byte, 0)
+
+	for _, block := range strings.Split(strings.ReplaceAll(output, "\r\n", "\n"), "\n\n") {
+		// lines beginning with a space continue the previous line
+		block = strings.ReplaceAll(block, "\n ", "")
+
+		entry := make(map[string][][]byte)
+		for _, line := range strings.Split(block, "\n") {
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+
+			if idx := strings.Index(line, ":: "); idx > 0 {
+				value, err := base64.StdEncoding.DecodeString(line[idx+3:])
+				if err == nil {
+					entry[line[:idx]] = append(entry[line[:idx]], value)
+				}
+				continue
+			}
+
+			if idx := strings.Index(line, ": "); idx > 0 {
+				entry[line[:idx]] = append(entry[line[:idx]], []byte(line[idx+2:]))
+			}
+		}
+
+		if len(entry) > 0 {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+// googleGroupMembers: list the members of a google group with gcloud
+func googleGroupMembers(sync SyncConfig) ([]groupMember, error) {
+	cmd := command("gcloud", "identity", "groups", "memberships", "list", "--group-email", sync.Group, "--format", "value(preferredMemberKey.id)")
+
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := runCommand(cmd); err != nil {
+		return []groupMember(nil), err
+	}
+
+	members := make([]groupMember, 0)
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		if email := strings.TrimSpace(line); email != "" {
+			members = append(members, groupMember{Email: email})
+		}
+	}
+
+	return members, nil
+}
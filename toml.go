@@ -0,0 +1,106 @@
+package safe
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var bareTOMLKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// tomlKey: return the key, quoted if it can't be bare
+func tomlKey(key string) string {
+	if bareTOMLKey.MatchString(key) {
+		return key
+	}
+
+	return strconv.Quote(key)
+}
+
+// tomlValue: encode a scalar, array or inline table value
+func tomlValue(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return strconv.Quote(value)
+	case bool, int, int64, uint64, float64:
+		return fmt.Sprintf("%v", value)
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, nested := range value {
+			values = append(values, tomlValue(nested))
+		}
+		return "[" + strings.Join(values, ", ") + "]"
+	case map[string]interface{}:
+		values := make([]string, 0, len(value))
+		for _, key := range sortedKeys(value) {
+			values = append(values, tomlKey(key)+" = "+tomlValue(value[key]))
+		}
+		return "{" + strings.Join(values, ", ") + "}"
+	case nil:
+		return `""`
+	default:
+		return strconv.Quote(fmt.Sprintf("%v", value))
+	}
+}
+
+// tomlTables: return the tables of an array of tables
+func tomlTables(value interface{}) ([]map[string]interface{}, bool) {
+	values, ok := value.([]interface{})
+	if !ok || len(values) == 0 {
+		return nil, false
+	}
+
+	tables := make([]map[string]interface{}, 0, len(values))
+	for _, nested := range values {
+		table, ok := nested.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		tables = append(tables, table)
+	}
+
+	return tables, true
+}
+
+// encodeTOMLTable: encode the scalar values of a table, followed by each of
+// its nested tables and arrays of tables
+func encodeTOMLTable(buf *bytes.Buffer, name string, data map[string]interface{}) {
+	if name != "" {
+		fmt.Fprintf(buf, "\n[%s]\n", name)
+	}
+
+	encodeTOMLBody(buf, name, data)
+}
+
+// encodeTOMLBody: encode the contents of a table, without its header
+func encodeTOMLBody(buf *bytes.Buffer, name string, data map[string]interface{}) {
+	for _, key := range sortedKeys(data) {
+		if _, ok := data[key].(map[string]interface{}); ok {
+			continue
+		}
+		if _, ok := tomlTables(data[key]); ok {
+			continue
+		}
+		fmt.Fprintf(buf, "%s = %s\n", tomlKey(key), tomlValue(data[key]))
+	}
+
+	for _, key := range sortedKeys(data) {
+		tableName := tomlKey(key)
+		if name != "" {
+			tableName = name + "." + tableName
+		}
+
+		if table, ok := data[key].(map[string]interface{}); ok {
+			encodeTOMLTable(buf, tableName, table)
+		}
+
+		if tables, ok := tomlTables(data[key]); ok {
+			for _, table := range tables {
+				fmt.Fprintf(buf, "\n[[%s]]\n", tableName)
+				encodeTOMLBody(buf, tableName, table)
+			}
+		}
+	}
+}
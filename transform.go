@@ -0,0 +1,148 @@
+package safe
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// transformFuncs convert a decrypted value into the form the consuming
+// application expects. A transform is written as its name, followed by
+// `:` and an argument for those that take one, eg: json-extract:db.url
+var transformFuncs = map[string]func(value, arg string) (string, error){
+	"base64decode": base64DecodeTransform,
+	"base64encode": func(value, _ string) (string, error) {
+		return base64.StdEncoding.EncodeToString([]byte(value)), nil
+	},
+	"trim": func(value, _ string) (string, error) {
+		return strings.TrimSpace(value), nil
+	},
+	"json-extract": jsonExtractTransform,
+}
+
+// ParseTransforms: parse KEY=transform pairs, such as those given on the
+// command line, into a map of keys to transforms
+func ParseTransforms(pairs []string) (map[string]string, error) {
+	transforms := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return map[string]string(nil), errors.New("invalid transform " + pair + ", expected KEY=transform")
+		}
+
+		if err := validateTransform(parts[1]); err != nil {
+			return map[string]string(nil), err
+		}
+
+		transforms[strings.ToUpper(parts[0])] = parts[1]
+	}
+
+	return transforms, nil
+}
+
+// validateTransform: return an error if a transform, or any step of a
+// chain of transforms separated by |, is unknown
+func validateTransform(transform string) error {
+	for _, step := range strings.Split(transform, "|") {
+		name := strings.SplitN(strings.TrimSpace(step), ":", 2)[0]
+		if _, ok := transformFuncs[name]; !ok {
+			return errors.New("unknown transform " + name + ", expected base64decode, base64encode, trim or json-extract")
+		}
+	}
+
+	return nil
+}
+
+// applyTransform: apply a transform, or a chain of transforms separated by
+// |, to a value
+func applyTransform(key, value, transform string) (string, error) {
+	for _, step := range strings.Split(transform, "|") {
+		parts := strings.SplitN(strings.TrimSpace(step), ":", 2)
+
+		fn, ok := transformFuncs[parts[0]]
+		if !ok {
+			return "", errors.New("unknown transform " + parts[0] + " for " + key)
+		}
+
+		arg := ""
+		if len(parts) == 2 {
+			arg = parts[1]
+		}
+
+		var err error
+		if value, err = fn(value, arg); err != nil {
+			return "", fmt.Errorf("unable to %s %s: %s", parts[0], key, err)
+		}
+	}
+
+	return value, nil
+}
+
+// execTransforms: return the transforms for exec, with those given in the
+// options taking precedence over the config's
+func execTransforms(config Config, opts ExecOptions) map[string]string {
+	transforms := make(map[string]string, len(config.Transforms)+len(opts.Transforms))
+	for key, transform := range config.Transforms {
+		transforms[strings.ToUpper(key)] = transform
+	}
+	for key, transform := range opts.Transforms {
+		transforms[strings.ToUpper(key)] = transform
+	}
+
+	return transforms
+}
+
+// base64DecodeTransform: decode standard or url safe base64, padded or not
+func base64DecodeTransform(value, _ string) (string, error) {
+	value = strings.TrimSpace(value)
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if byts, err := encoding.DecodeString(value); err == nil {
+			return string(byts), nil
+		}
+	}
+
+	return "", errors.New("not valid base64")
+}
+
+// jsonExtractTransform: extract the field at a dotted path, eg: db.url or
+// hosts.0, from a json value. Strings are extracted as is, and anything
+// else as json.
+func jsonExtractTransform(value, path string) (string, error) {
+	if path == "" {
+		return "", errors.New("json-extract requires a path, eg: json-extract:db.url")
+	}
+
+	var data interface{}
+	if err := json.Unmarshal([]byte(value), &data); err != nil {
+		return "", err
+	}
+
+	for _, field := range strings.Split(path, ".") {
+		switch node := data.(type) {
+		case map[string]interface{}:
+			child, ok := node[field]
+			if !ok {
+				return "", errors.New("no field " + field)
+			}
+			data = child
+		case []interface{}:
+			idx, err := strconv.Atoi(field)
+			if err != nil || idx < 0 || idx >= len(node) {
+				return "", errors.New("no index " + field)
+			}
+			data = node[idx]
+		default:
+			return "", errors.New("no field " + field)
+		}
+	}
+
+	if str, ok := data.(string); ok {
+		return str, nil
+	}
+
+	byts, err := json.Marshal(data)
+	return string(byts), err
+}
@@ -0,0 +1,122 @@
+package safe
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// TreeOptions: how Tree renders the repository
+type TreeOptions struct {
+	// ProtectedOnly leaves out files that aren't protected, and directories
+	// without any protected files
+	ProtectedOnly bool
+
+	// Color highlights protected files in green, eg: when UseColor, rather than
+	// marking them with (protected)
+	Color bool
+}
+
+// treeNode: a file or directory in the rendered tree
+type treeNode struct {
+	name      string
+	protected bool
+	children  []*treeNode
+	dir       bool
+}
+
+// Tree: render the directory tree of the repository, highlighting protected
+// files, for a quick map of what's encrypted. Ignored paths are left out.
+func Tree(config Config, opts TreeOptions) (string, error) {
+	filepaths, err := ProtectedFiles(config)
+	if err != nil {
+		return "", err
+	}
+
+	protected := make(map[string]bool, len(filepaths))
+	for _, relFilepath := range filepaths {
+		protected[relFilepath] = true
+	}
+
+	root, err := buildTree(config.baseDir, ".", protected, config, opts)
+	if err != nil {
+		return "", err
+	}
+
+	var builder strings.Builder
+	builder.WriteString(".\n")
+	if root != nil {
+		renderTree(&builder, root.children, "", opts)
+	}
+
+	return builder.String(), nil
+}
+
+// buildTree: read a directory into a tree, returning nil for directories
+// left out of it
+func buildTree(dir, relDir string, protected map[string]bool, config Config, opts TreeOptions) (*treeNode, error) {
+	// entries are sorted by name
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	node := &treeNode{name: filepath.Base(dir), dir: true}
+	for _, info := range infos {
+		relPath := filepath.Join(relDir, info.Name())
+		// .safe only holds safe's own state, such as the config lock
+		if info.Name() == ".git" || (relDir == "." && info.Name() == ".safe") || isIgnored(relPath, config) {
+			continue
+		}
+
+		if info.IsDir() {
+			child, err := buildTree(filepath.Join(dir, info.Name()), relPath, protected, config, opts)
+			if err != nil {
+				return nil, err
+			}
+
+			if child != nil {
+				node.children = append(node.children, child)
+			}
+			continue
+		}
+
+		child := &treeNode{name: info.Name(), protected: protected[relPath]}
+		if child.protected || !opts.ProtectedOnly {
+			node.children = append(node.children, child)
+		}
+	}
+
+	if opts.ProtectedOnly && len(node.children) == 0 {
+		return nil, nil
+	}
+
+	return node, nil
+}
+
+// renderTree: write the nodes, and their children, indented under prefix
+func renderTree(builder *strings.Builder, nodes []*treeNode, prefix string, opts TreeOptions) {
+	for i, node := range nodes {
+		connector, indent := "├── ", "│   "
+		if i == len(nodes)-1 {
+			connector, indent = "└── ", "    "
+		}
+
+		name := node.name
+		switch {
+		case node.dir:
+			name += string(os.PathSeparator)
+		case node.protected && opts.Color:
+			name = colorize(greenColor, name)
+		case node.protected:
+			name += " (protected)"
+		}
+
+		builder.WriteString(prefix + connector + name + "\n")
+
+		if node.dir {
+			renderTree(builder, node.children, prefix+indent, opts)
+		}
+	}
+}
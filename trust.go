@@ -0,0 +1,184 @@
+package safe
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultKeysDir is the directory, relative to the config, where the team's
+// public keys are kept
+const DefaultKeysDir = "keys"
+
+// KeysDir: return the absolute path of the team keys directory
+func KeysDir(config Config) string {
+	keysDir := config.KeysDir
+	if keysDir == "" {
+		keysDir = DefaultKeysDir
+	}
+
+	if filepath.IsAbs(keysDir) {
+		return keysDir
+	}
+
+	return filepath.Join(config.baseDir, keysDir)
+}
+
+// teamKeyFiles: return the exported public keys in the team keys directory
+func teamKeyFiles(config Config) ([]string, error) {
+	keyFilepaths := make([]string, 0)
+	for _, pattern := range []string{"*.asc", "*.gpg", "*.pub"} {
+		matches, err := filepath.Glob(filepath.Join(KeysDir(config), pattern))
+		if err != nil {
+			return []string(nil), err
+		}
+		keyFilepaths = append(keyFilepaths, matches...)
+	}
+
+	return keyFilepaths, nil
+}
+
+// TeamKeyring: build a keyring from the team keys directory, returning its
+// path, or an empty path when the repository has no team keys. The keyring
+// lives in the user's cache directory, named for the repository and the
+// contents of its keys, so it's only rebuilt when they change.
+func TeamKeyring(config Config) (string, error) {
+	keyFilepaths, err := teamKeyFiles(config)
+	if err != nil || len(keyFilepaths) == 0 {
+		return "", err
+	}
+
+	keysSum := sha256.New()
+	for _, keyFilepath := range keyFilepaths {
+		byts, err := ioutil.ReadFile(keyFilepath)
+		if err != nil {
+			return "", err
+		}
+
+		keysSum.Write([]byte(filepath.Base(keyFilepath) + "\x00"))
+		keysSum.Write(byts)
+	}
+
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	keyringDir := filepath.Join(cacheDir, "safe", "keyrings")
+	if err := os.MkdirAll(keyringDir, 0700); err != nil {
+		return "", err
+	}
+
+	dirSum := sha256.Sum256([]byte(config.baseDir))
+	prefix := filepath.Join(keyringDir, hex.EncodeToString(dirSum[:8]))
+	keyring := prefix + "-" + hex.EncodeToString(keysSum.Sum(nil)[:8]) + ".kbx"
+	if _, err := os.Stat(keyring); err == nil {
+		return keyring, nil
+	}
+
+	// drop the keyrings built from previous keys, along with gpg's backups
+	stale, err := filepath.Glob(prefix + "*")
+	if err != nil {
+		return "", err
+	}
+	for _, staleFilepath := range stale {
+		if err := os.Remove(staleFilepath); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+
+	args := append([]string{"--batch", "--no-default-keyring", "--keyring", keyring, "--import"}, keyFilepaths...)
+	if err := runCommand(gpgCommand(config, args...)); err != nil {
+		os.Remove(keyring)
+		return "", errors.New("unable to import team keys from " + KeysDir(config))
+	}
+
+	return keyring, nil
+}
+
+// teamKeyringArgs: return the gpg arguments that add the team keyring to
+// the local one, if the repository has team keys
+func teamKeyringArgs(config Config) ([]string, error) {
+	keyring, err := TeamKeyring(config)
+	if err != nil || keyring == "" {
+		return []string(nil), err
+	}
+
+	return []string{"--keyring", keyring}, nil
+}
+
+// Trust: import the team's public keys into the local keyring, optionally
+// locally signing them so gpg considers them valid for encryption
+func Trust(sign bool, config Config) error {
+	keyFilepaths, err := teamKeyFiles(config)
+	if err != nil {
+		return err
+	}
+
+	if len(keyFilepaths) == 0 {
+		return errors.New("no keys found in " + KeysDir(config))
+	}
+
+	for _, keyFilepath := range keyFilepaths {
+		cmd := gpgCommand(config, "--batch", "--import", keyFilepath)
+		cmd.Stderr = os.Stderr
+		if err := runCommand(cmd); err != nil {
+			return err
+		}
+
+		if !sign {
+			continue
+		}
+
+		fingerprints, err := keyFileFingerprints(keyFilepath, config)
+		if err != nil {
+			return err
+		}
+
+		for _, fingerprint := range fingerprints {
+			cmd := gpgCommand(config, "--yes", "--quick-lsign-key", fingerprint)
+			cmd.Stdin = os.Stdin
+			cmd.Stdout = os.Stderr
+			cmd.Stderr = os.Stderr
+			if err := runCommand(cmd); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// keyFileFingerprints: return the fingerprints of the primary keys in an
+// exported key file
+func keyFileFingerprints(keyFilepath string, config Config) ([]string, error) {
+	cmd := gpgCommand(config, "--batch", "--with-colons", "--import-options", "show-only", "--import", keyFilepath)
+
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := runCommand(cmd); err != nil {
+		return []string(nil), err
+	}
+
+	fingerprints := make([]string, 0)
+	inPrimary := false
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		fields := strings.Split(line, ":")
+		switch {
+		case fields[0] == "pub":
+			inPrimary = true
+		case fields[0] == "sub":
+			inPrimary = false
+		case fields[0] == "fpr" && inPrimary && len(fields) > 9:
+			fingerprints = append(fingerprints, fields[9])
+			inPrimary = false
+		}
+	}
+
+	return fingerprints, nil
+}
@@ -0,0 +1,120 @@
+package safe
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// IdentityEnvVar overrides the identity selected by the user config
+const IdentityEnvVar = "SAFE_IDENTITY"
+
+// UserConfig: per-user settings, shared across every repository
+type UserConfig struct {
+	// Identity is the default gpg identity to use, while Identities
+	// selects an identity per repository, keyed by a glob matching the
+	// repository's directory
+	Identity   string            `yaml:"identity"`
+	Identities map[string]string `yaml:"identities"`
+
+	// Recipients are the default recipients offered for new repositories
+	Recipients []string `yaml:"recipients,omitempty"`
+
+	// Editor is used by edit in place of $EDITOR, unless safe.local.yml
+	// sets one
+	Editor string `yaml:"editor,omitempty"`
+
+	// Color is when to color output: auto, always or never
+	Color string `yaml:"color,omitempty"`
+
+	// GPGOptions are passed to every gpg command, eg: --pinentry-mode
+	GPGOptions []string `yaml:"gpg_options,omitempty"`
+
+	// TrustedSigners are the fingerprints of the keys that may sign a
+	// safe.yml. When set, files are only encrypted with signed configs.
+	TrustedSigners []string `yaml:"trusted_signers,omitempty"`
+}
+
+// UserConfigPath: return the path of the user config file, respecting
+// XDG_CONFIG_HOME
+func UserConfigPath() (string, error) {
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		configHome = filepath.Join(homeDir, ".config")
+	}
+
+	return filepath.Join(configHome, "safe", "config.yml"), nil
+}
+
+// LoadUserConfig: load the user config, returning an empty config when the
+// user doesn't have one
+func LoadUserConfig() (UserConfig, error) {
+	var userConfig UserConfig
+
+	userConfigPath, err := UserConfigPath()
+	if err != nil {
+		return userConfig, err
+	}
+
+	reader, err := os.Open(userConfigPath)
+	if os.IsNotExist(err) {
+		return userConfig, nil
+	}
+	if err != nil {
+		return userConfig, err
+	}
+	defer reader.Close()
+
+	// an empty file decodes as io.EOF, and is treated as an empty config
+	if err := yaml.NewDecoder(reader).Decode(&userConfig); err != nil && err != io.EOF {
+		return UserConfig{}, err
+	}
+
+	switch userConfig.Color {
+	case "", "auto", "always", "never":
+	default:
+		return UserConfig{}, errors.New("invalid color " + userConfig.Color + " in " + userConfigPath + ", expected auto, always or never")
+	}
+
+	return userConfig, nil
+}
+
+// IdentityFor: return the gpg identity to use for the repository, preferring
+// SAFE_IDENTITY, then the most specific matching entry in Identities and
+// finally the default Identity. An empty identity leaves the choice to gpg.
+func (u UserConfig) IdentityFor(repoDir string) string {
+	if identity := os.Getenv(IdentityEnvVar); identity != "" {
+		return identity
+	}
+
+	patterns := make([]string, 0, len(u.Identities))
+	for pattern := range u.Identities {
+		patterns = append(patterns, pattern)
+	}
+
+	// the longest pattern is considered the most specific
+	sort.Slice(patterns, func(i, j int) bool {
+		return len(patterns[i]) > len(patterns[j])
+	})
+
+	for _, pattern := range patterns {
+		if matched, err := filepath.Match(pattern, repoDir); err == nil && matched {
+			return u.Identities[pattern]
+		}
+	}
+
+	return u.Identity
+}
+
+// Identity: return the gpg identity selected for the config's repository
+func Identity(config Config) string {
+	return config.identity
+}
@@ -0,0 +1,120 @@
+package safe
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// DefaultVCS is used when the config doesn't set one and no repository is
+// detected while initializing
+const DefaultVCS = "git"
+
+// VCS: the version control system that safe commits its changes to
+type VCS interface {
+	// Commit the changes to the filepaths, including removals, with the
+	// given message, in the repository containing dir
+	Commit(dir, message string, filepaths []string) error
+}
+
+var vcses = map[string]VCS{
+	"git":  gitVCS{},
+	"hg":   hgVCS{},
+	"none": noVCS{},
+}
+
+// RegisterVCS: make a version control system available under the given
+// name, so that it can be implemented outside of safe's core
+func RegisterVCS(name string, v VCS) {
+	vcses[name] = v
+}
+
+// vcsFor: return the version control system for the config, as set by vcs in
+// safe.yml, or detected from the repository containing it
+func vcsFor(config Config) (VCS, error) {
+	name := config.VCS
+	if name == "" {
+		name = detectVCS(config.baseDir)
+	}
+
+	v, ok := vcses[name]
+	if !ok {
+		return nil, errors.New("unknown vcs " + name + " in " + config.filepath)
+	}
+
+	return v, nil
+}
+
+// configVCS: return the version control system the config was loaded with,
+// which is git for configs that weren't loaded
+func configVCS(config Config) VCS {
+	if config.vcs == nil {
+		return gitVCS{}
+	}
+
+	return config.vcs
+}
+
+// detectVCS: return the name of the version control system of the nearest
+// repository containing dir, or none for plain directories
+func detectVCS(dir string) string {
+	for {
+		for _, name := range []string{"git", "hg"} {
+			if _, err := os.Stat(filepath.Join(dir, "."+name)); err == nil {
+				return name
+			}
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "none"
+		}
+		dir = parent
+	}
+}
+
+// gitVCS: commits to git
+type gitVCS struct{}
+
+func (gitVCS) Commit(dir, message string, filepaths []string) error {
+	// NOTE: if an origin file was "protected" that had _never_ been
+	// checked into source control, it will fail during the `git add`.
+	// Adding a removed file that wasn't checked returns a 128 error in
+	// git. To get around this, we add each file separately, and ignore
+	// errors for git add
+	for _, filepath := range filepaths {
+		runCommand(command("git", "-C", dir, "add", filepath))
+	}
+
+	cmd := command("git", "-C", dir, "commit", "-m", message)
+	cmd.Stdout = logWriter(LogNormal)
+	cmd.Stderr = os.Stderr
+	return runCommand(cmd)
+}
+
+// hgVCS: commits to mercurial
+type hgVCS struct{}
+
+func (hgVCS) Commit(dir, message string, filepaths []string) error {
+	// addremove tracks new files and records removed ones, and fails for
+	// files that were removed without ever being tracked, which are left
+	// out of the commit
+	committed := make([]string, 0, len(filepaths))
+	for _, filepath := range filepaths {
+		if err := runCommand(command("hg", "--cwd", dir, "addremove", filepath)); err == nil {
+			committed = append(committed, filepath)
+		}
+	}
+
+	cmd := command("hg", append([]string{"--cwd", dir, "commit", "-m", message}, committed...)...)
+	cmd.Stdout = logWriter(LogNormal)
+	cmd.Stderr = os.Stderr
+	return runCommand(cmd)
+}
+
+// noVCS: for plain directories, where changes aren't committed
+type noVCS struct{}
+
+func (noVCS) Commit(dir, message string, filepaths []string) error {
+	return nil
+}
@@ -0,0 +1,175 @@
+package safe
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Verify: compare the recipients of every active protected file with its
+// current configuration, reporting files that need to be reencrypted
+// because their recipients or backend changed since it was encrypted. The
+// key ids of gpg ciphertexts are inspected directly, while other backends
+// rely on the recorded last encryption.
+func Verify(config Config) ([]AuditIssue, error) {
+	filepaths, err := ProtectedFiles(config)
+	if err != nil {
+		return []AuditIssue(nil), err
+	}
+
+	issues := make([]AuditIssue, 0)
+	for _, filepath := range filepaths {
+		if config.Metadata[filepath].Archived {
+			continue
+		}
+		encryption := config.encryptions[filepath]
+
+		backend, err := BackendFor(filepath, config)
+		if err != nil {
+			return []AuditIssue(nil), err
+		}
+
+		_, isGPG := backend.(gpgBackend)
+		if !isGPG && encryption.EncryptedAt.IsZero() {
+			issues = append(issues, AuditIssue{Filepath: filepath, Problem: "no encryption recorded, reencrypt it to record one"})
+			continue
+		}
+
+		// a one-off encryption is kept until the file is next encrypted
+		recipients := RecipientsFor(filepath, config)
+		if len(encryption.Recipients) > 0 {
+			recipients = encryption.Recipients
+		}
+
+		var added, removed []string
+		if isGPG {
+			added, removed, err = ciphertextChanges(configPath(filepath, config), recipients, encryption, config)
+			if err != nil {
+				issues = append(issues, AuditIssue{Filepath: filepath, Problem: "unable to list packets: " + err.Error()})
+				continue
+			}
+		} else {
+			added, removed = recordedChanges(recipients, encryption)
+		}
+
+		since := ", reencrypt it"
+		if !encryption.EncryptedAt.IsZero() {
+			since = " since it was encrypted on " + encryption.EncryptedAt.Format("2006-01-02") + since
+		}
+		if len(added) > 0 {
+			issues = append(issues, AuditIssue{Filepath: filepath, Problem: "recipients " + strings.Join(added, ", ") + " were added" + since})
+		}
+		if len(removed) > 0 {
+			issues = append(issues, AuditIssue{Filepath: filepath, Problem: "recipients " + strings.Join(removed, ", ") + " were removed" + since})
+		}
+
+		if backend := backendName(filepath, config); encryption.EncryptedWith != "" && encryption.EncryptedWith != backend {
+			issues = append(issues, AuditIssue{Filepath: filepath, Problem: "backend changed from " + encryption.EncryptedWith + " to " + backend + since})
+		}
+	}
+
+	// an expired delegation stays in effect until its files are reencrypted
+	for _, delegation := range config.Delegations {
+		if delegation.Active() {
+			continue
+		}
+
+		issues = append(issues, AuditIssue{
+			Filepath: filepath.Base(config.filepath),
+			Problem:  "delegation to " + delegation.Recipient + " for " + strings.Join(delegation.Files, ", ") + " expired on " + delegation.Until.Format("2006-01-02") + ", expire it to revoke access",
+		})
+	}
+
+	return issues, nil
+}
+
+// recordedChanges: return the recipients added and removed since the file's
+// recorded last encryption
+func recordedChanges(recipients []string, encryption Encryption) ([]string, []string) {
+	added, removed := make([]string, 0), make([]string, 0)
+	for _, recipient := range recipients {
+		if !containsString(encryption.EncryptedTo, recipient) {
+			added = append(added, recipient)
+		}
+	}
+	for _, recipient := range encryption.EncryptedTo {
+		if !containsString(recipients, recipient) {
+			removed = append(removed, recipient)
+		}
+	}
+
+	return added, removed
+}
+
+// ciphertextChanges: compare the key ids a gpg ciphertext is encrypted to
+// with the keys of its recipients, returning the recipients it isn't
+// encrypted to and the keys it's encrypted to that no recipient holds,
+// named by their user id when they're in the keyring. Recipients whose keys
+// aren't in the keyring can only be checked against the recorded last
+// encryption.
+func ciphertextChanges(ciphertextFilepath string, recipients []string, encryption Encryption, config Config) ([]string, []string, error) {
+	ciphertextIDs, err := ciphertextKeyIDs(ciphertextFilepath, config)
+	if err != nil {
+		return []string(nil), []string(nil), err
+	}
+
+	added := make([]string, 0)
+	unresolved := make([]string, 0)
+	matched := make(map[string]bool)
+	for _, recipient := range recipients {
+		var keyIDs []string
+		if isKeyringRecipient(recipient) {
+			if keyIDs, err = recipientKeyIDs(recipient, config); err != nil {
+				return []string(nil), []string(nil), err
+			}
+		}
+
+		if len(keyIDs) == 0 {
+			unresolved = append(unresolved, recipient)
+			continue
+		}
+
+		found := false
+		for _, keyID := range keyIDs {
+			if containsString(ciphertextIDs, keyID) {
+				matched[keyID] = true
+				found = true
+			}
+		}
+
+		if !found {
+			added = append(added, recipient)
+		}
+	}
+
+	// the keys of unresolved recipients can't be told apart from those of
+	// removed recipients
+	if len(unresolved) > 0 {
+		recordedAdded, recordedRemoved := recordedChanges(recipients, encryption)
+		for _, recipient := range recordedAdded {
+			if containsString(unresolved, recipient) {
+				added = append(added, recipient)
+			}
+		}
+
+		return added, recordedRemoved, nil
+	}
+
+	removed := make([]string, 0)
+	for _, keyID := range uniqueStrings(ciphertextIDs) {
+		// hidden recipients are listed with an all zero key id
+		if matched[keyID] || strings.Trim(keyID, "0") == "" {
+			continue
+		}
+
+		name := "key " + keyID
+		if keys, err := listGPGKeys(keyID, config); err == nil && len(keys) > 0 && len(keys[0].UserIDs) > 0 {
+			name = keys[0].UserIDs[0]
+		}
+
+		if !containsString(removed, name) {
+			removed = append(removed, name)
+		}
+	}
+
+	return added, removed, nil
+}
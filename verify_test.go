@@ -0,0 +1,78 @@
+package safe
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRecordedChanges(t *testing.T) {
+	for _, test := range []struct {
+		name           string
+		recipients     []string
+		encryptedTo    []string
+		added, removed []string
+	}{
+		{"unchanged", []string{"a", "b"}, []string{"b", "a"}, []string{}, []string{}},
+		{"added", []string{"a", "b"}, []string{"a"}, []string{"b"}, []string{}},
+		{"removed", []string{"a"}, []string{"a", "b"}, []string{}, []string{"b"}},
+		{"replaced", []string{"a", "c"}, []string{"a", "b"}, []string{"c"}, []string{"b"}},
+		{"never encrypted", []string{"a"}, nil, []string{"a"}, []string{}},
+	} {
+		added, removed := recordedChanges(test.recipients, Encryption{EncryptedTo: test.encryptedTo})
+		if !reflect.DeepEqual(added, test.added) || !reflect.DeepEqual(removed, test.removed) {
+			t.Fatalf("%s: expected %v added and %v removed, got %v and %v", test.name, test.added, test.removed, added, removed)
+		}
+	}
+}
+
+func TestCiphertextChanges(t *testing.T) {
+	defer SetRunner(LocalRunner{})
+
+	// gpg lists the packets of a ciphertext encrypted to two keys. The
+	// recipients are given as key ids, which identify their keys without
+	// a keyring.
+	SetRunner(outputRunner{":pubkey enc packet: version 3, algo 1, keyid 1111111111111111\n" +
+		":pubkey enc packet: version 3, algo 1, keyid 2222222222222222\n"})
+
+	for _, test := range []struct {
+		name           string
+		recipients     []string
+		encryption     Encryption
+		added, removed []string
+	}{
+		{
+			name:       "unchanged",
+			recipients: []string{"1111111111111111", "2222222222222222"},
+			added:      []string{},
+			removed:    []string{},
+		},
+		{
+			name:       "added",
+			recipients: []string{"1111111111111111", "2222222222222222", "3333333333333333"},
+			added:      []string{"3333333333333333"},
+			removed:    []string{},
+		},
+		{
+			name:       "removed",
+			recipients: []string{"1111111111111111"},
+			added:      []string{},
+			removed:    []string{"key 2222222222222222"},
+		},
+		{
+			name:       "unresolved recipients fall back to the recorded encryption",
+			recipients: []string{"1111111111111111", "github:octocat"},
+			encryption: Encryption{EncryptedTo: []string{"1111111111111111", "old@example.com"}},
+			added:      []string{"github:octocat"},
+			removed:    []string{"old@example.com"},
+		},
+	} {
+		added, removed, err := ciphertextChanges("a.yml.gpg", test.recipients, test.encryption, Config{baseDir: t.TempDir()})
+		if err != nil {
+			t.Fatalf("%s: expected no error, got %v", test.name, err)
+		}
+
+		if !reflect.DeepEqual(added, test.added) || !reflect.DeepEqual(removed, test.removed) {
+			t.Fatalf("%s: expected %v added and %v removed, got %v and %v", test.name, test.added, test.removed, added, removed)
+		}
+	}
+}
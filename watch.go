@@ -0,0 +1,168 @@
+package safe
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// postMergeHook is installed by InstallPostMergeHook to reencrypt files
+// after a pull changes their recipients
+const postMergeHook = `#!/bin/sh
+# installed by safe: reencrypt files whose recipients changed in the merge
+exec safe watch ORIG_HEAD
+`
+
+// InstallPostMergeHook: install a git post-merge hook that reencrypts files
+// whose recipients were changed by a pull
+func InstallPostMergeHook() error {
+	return installHook("post-merge", postMergeHook)
+}
+
+// RecipientChanges: return the active protected files whose recipients in
+// the config at the git revision differ from the current config
+func RecipientChanges(rev string, config Config) ([]string, error) {
+	prev, err := configAt(rev, config)
+	if err != nil {
+		return []string(nil), err
+	}
+
+	filepaths, err := ProtectedFiles(config)
+	if err != nil {
+		return []string(nil), err
+	}
+
+	changed := make([]string, 0)
+	for _, filepath := range filepaths {
+		if config.Metadata[filepath].Archived {
+			continue
+		}
+
+		// files that weren't protected at the revision have been encrypted
+		// with the current config since
+		if protected, err := IsProtected(configPath(filepath, config), prev); err != nil || !protected {
+			continue
+		}
+
+		if !sameRecipients(RecipientsFor(filepath, prev), RecipientsFor(filepath, config)) {
+			changed = append(changed, filepath)
+		}
+	}
+
+	return changed, nil
+}
+
+// configAt: load the config as it was at the git revision, merging its
+// parents and includes as they were then. The user's safe.local.yml and
+// profile are applied as they are now, as they aren't committed.
+func configAt(rev string, config Config) (Config, error) {
+	read, err := revisionReader(rev, config)
+	if err != nil {
+		return Config{}, err
+	}
+
+	prev, err := loadConfigChain(config.filepath, read)
+	if err != nil {
+		return Config{}, err
+	}
+
+	if config.local != nil {
+		applyLocalConfig(*config.local, &prev)
+	}
+
+	// a profile added since the revision selected nothing then
+	if _, ok := prev.Profiles[config.profile]; ok && config.profile != "" {
+		if prev, err = WithProfile(config.profile, prev); err != nil {
+			return Config{}, err
+		}
+	}
+
+	return prev, nil
+}
+
+// revisionReader: return a reader of the files in the config's git
+// repository as they were at the revision. Files outside of the repository
+// aren't versioned with the config, so they're read as they are now.
+func revisionReader(rev string, config Config) (fileReader, error) {
+	toplevel, err := gitOutput("-C", config.baseDir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	toplevel = strings.TrimSpace(toplevel)
+
+	return func(path string) ([]byte, error) {
+		// git reports the toplevel with symlinks resolved
+		dir := filepath.Dir(path)
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			dir = resolved
+		}
+
+		relFilepath, err := filepath.Rel(toplevel, filepath.Join(dir, filepath.Base(path)))
+		if err != nil || strings.HasPrefix(relFilepath, "..") {
+			return ioutil.ReadFile(path)
+		}
+
+		byts, err := gitOutput("-C", toplevel, "show", rev+":"+filepath.ToSlash(relFilepath))
+		if err != nil {
+			return []byte(nil), &os.PathError{Op: "show", Path: rev + ":" + relFilepath, Err: os.ErrNotExist}
+		}
+
+		return []byte(byts), nil
+	}, nil
+}
+
+// sameRecipients: return whether the recipients are the same, in any order
+func sameRecipients(a, b []string) bool {
+	a, b = append([]string{}, a...), append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+
+	return reflect.DeepEqual(a, b)
+}
+
+// ReencryptChanged: reencrypt only the files whose recipients changed since
+// the git revision, eg: ORIG_HEAD after a pull, so access changes take
+// effect. Confirms before reencrypting unless forced.
+func ReencryptChanged(rev string, config Config, opts Options) (Result, error) {
+	changed, err := RecipientChanges(rev, config)
+	if err != nil {
+		return Result{}, err
+	}
+
+	result := Result{Written: changed, Committed: opts.Commit && len(changed) > 0}
+	if opts.DryRun || len(changed) == 0 {
+		return result, nil
+	}
+
+	if !opts.Force {
+		ok, err := Confirm(fmt.Sprintf("recipients changed for %d files, reencrypt them?", len(changed)))
+		if err != nil {
+			return Result{}, err
+		}
+
+		if !ok {
+			return Result{}, nil
+		}
+	}
+
+	for _, filepath := range configPaths(changed, config) {
+		byts, err := Decrypt(filepath, config)
+		if err != nil {
+			return Result{}, err
+		}
+
+		if err := Encrypt(filepath, byts, config, false, "reencrypt"); err != nil {
+			return Result{}, err
+		}
+	}
+
+	if !opts.Commit {
+		return result, nil
+	}
+
+	return result, Commit("reencrypt", "for safe.yml changes", append(configPaths(changed, config), configFilepaths(config)...), config)
+}